@@ -0,0 +1,375 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// migrationFiles holds the SQL migrations of each dialect, named
+// <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// goMigrations holds the migrations of each dialect that are written in Go,
+// for changes SQL alone cannot make. They share version numbers with the
+// SQL migrations.
+var goMigrations = map[string][]Migration{}
+
+// preludes holds Go steps that run before the SQL migration of the same
+// version of each dialect, in its transaction, for the parts of it SQL alone
+// cannot make
+var preludes = map[string]map[int]func(tx *sql.Tx) error{
+	"sqlite": {1: adoptLegacyTodos},
+}
+
+// ErrIrreversible is returned when reverting a migration that has no down step
+var ErrIrreversible = errors.New("migration cannot be reverted")
+
+// ErrUnknownMigration is returned when migrating to a version that does not exist
+var ErrUnknownMigration = errors.New("unknown migration version")
+
+// Migration is one versioned change to the schema. Up applies it and Down
+// reverts it, each inside the transaction that records the change in
+// schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	// Down is nil for migrations that cannot be reverted
+	Down func(tx *sql.Tx) error
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version int
+	Name    string
+	// AppliedAt is nil for pending migrations
+	AppliedAt *time.Time
+}
+
+// Migrator applies migrations to a database and reverts them
+type Migrator struct {
+	DB *sql.DB
+	// Migrations is ordered by version
+	Migrations []Migration
+	postgres   bool
+}
+
+// NewMigrator creates a Migrator with the embedded SQL migrations and the Go
+// migrations for the database's dialect
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	_, postgres := db.Driver().(*pq.Driver)
+	dialect := "sqlite"
+	if postgres {
+		dialect = "postgres"
+	}
+
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", dialect))
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range migrations {
+		if prelude := preludes[dialect][migration.Version]; prelude != nil {
+			migrations[i].Up = withPrelude(prelude, migration.Up)
+		}
+	}
+	for _, migration := range goMigrations[dialect] {
+		for _, existing := range migrations {
+			if existing.Version == migration.Version {
+				return nil, fmt.Errorf("migrations %q and %q share version %d", existing.Name, migration.Name, migration.Version)
+			}
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return &Migrator{DB: db, Migrations: migrations, postgres: postgres}, nil
+}
+
+// loadMigrations reads the SQL migrations in dir, ordered by version
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		base, direction := strings.TrimSuffix(entry.Name(), ".sql"), ""
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base, direction = strings.TrimSuffix(base, ".up"), "up"
+		case strings.HasSuffix(base, ".down"):
+			base, direction = strings.TrimSuffix(base, ".down"), "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither .up.sql nor .down.sql", entry.Name())
+		}
+
+		prefix, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s does not start with a version number", entry.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", migration.Name, name, version)
+		}
+		if direction == "up" {
+			migration.Up = execSQL(string(data))
+		} else {
+			migration.Down = execSQL(string(data))
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d has no .up.sql file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// execSQL returns a migration step that runs a script of SQL statements
+func execSQL(script string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+// withPrelude returns a migration step that runs prelude and then up
+func withPrelude(prelude, up func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		if err := prelude(tx); err != nil {
+			return err
+		}
+		return up(tx)
+	}
+}
+
+// createMigrationsTable creates schema_migrations, which lists the versions
+// applied to the database
+func (m *Migrator) createMigrationsTable() error {
+	appliedAt := "DATETIME"
+	if m.postgres {
+		appliedAt = "TIMESTAMPTZ"
+	}
+
+	_, err := m.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at ` + appliedAt + ` NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return nil
+}
+
+// applied returns when each applied version was applied
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	if err := m.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// Version returns the newest applied version, or 0 if none is applied
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// Status lists every migration along with when it was applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(m.Migrations))
+	for i, migration := range m.Migrations {
+		statuses[i] = MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up() error {
+	if len(m.Migrations) == 0 {
+		return nil
+	}
+	return m.To(m.Migrations[len(m.Migrations)-1].Version)
+}
+
+// Down reverts the newest applied migration
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.Migrations[i].Version]; ok {
+			return m.revert(m.Migrations[i])
+		}
+	}
+
+	return nil
+}
+
+// To applies the pending migrations up to version and reverts the applied
+// ones above it, so To(0) reverts every migration
+func (m *Migrator) To(version int) error {
+	known := version == 0
+	for _, migration := range m.Migrations {
+		known = known || migration.Version == version
+	}
+	if !known {
+		return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err := m.revert(migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err := m.apply(migration); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// apply runs a migration's up step and records it, unless another process
+// applied it first
+func (m *Migrator) apply(migration Migration) error {
+	return m.inTx(migration.Version, func(tx *sql.Tx, applied bool) error {
+		if applied {
+			return nil
+		}
+		if err := migration.Up(tx); err != nil {
+			return fmt.Errorf("failed to apply migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			migration.Version, migration.Name, time.Now().UTC())
+		return err
+	})
+}
+
+// revert runs a migration's down step and forgets it, unless another
+// process reverted it first
+func (m *Migrator) revert(migration Migration) error {
+	if migration.Down == nil {
+		return fmt.Errorf("%w: %d %s", ErrIrreversible, migration.Version, migration.Name)
+	}
+
+	return m.inTx(migration.Version, func(tx *sql.Tx, applied bool) error {
+		if !applied {
+			return nil
+		}
+		if err := migration.Down(tx); err != nil {
+			return fmt.Errorf("failed to revert migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+		return err
+	})
+}
+
+// inTx runs fn in a transaction, telling it whether version is applied.
+// In Postgres, migrating processes take turns so they see each other's work.
+func (m *Migrator) inTx(version int, fn func(tx *sql.Tx, applied bool) error) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.postgres {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))`); err != nil {
+			return err
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&count); err != nil {
+		return err
+	}
+	if err := fn(tx, count > 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS todo_events;
+DROP FUNCTION IF EXISTS todo_events_next_id();
+DROP SEQUENCE IF EXISTS todo_events_id_seq;
+DROP TABLE IF EXISTS todo_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS todos;
+DROP TABLE IF EXISTS projects;
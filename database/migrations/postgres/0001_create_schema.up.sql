@@ -0,0 +1,129 @@
+-- The same tables as the SQLite schema. Tag names are citext so they compare
+-- without case like SQLite's NOCASE, and titles sort bytewise like SQLite's
+-- BINARY. The release that added Postgres already created all of these
+-- tables as they are here, so no database needs columns added.
+
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS projects (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	archived BOOLEAN NOT NULL DEFAULT FALSE,
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- search is the full-text index; title matches weigh more than description matches
+CREATE TABLE IF NOT EXISTS todos (
+	id BIGSERIAL PRIMARY KEY,
+	title TEXT COLLATE "C" NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	completed BOOLEAN NOT NULL DEFAULT FALSE,
+	priority INTEGER NOT NULL DEFAULT 0,
+	project_id BIGINT REFERENCES projects (id) ON DELETE SET NULL,
+	parent_id BIGINT REFERENCES todos (id) ON DELETE CASCADE,
+	due_at TIMESTAMPTZ,
+	due_timezone TEXT NOT NULL DEFAULT '',
+	recurrence TEXT NOT NULL DEFAULT '',
+	recurrence_start TIMESTAMPTZ,
+	series_id BIGINT REFERENCES todos (id) ON DELETE SET NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	deleted_at TIMESTAMPTZ,
+	search TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', description), 'D')
+	) STORED
+);
+
+CREATE INDEX IF NOT EXISTS idx_todos_created_at_id ON todos (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_todos_project_id ON todos (project_id);
+CREATE INDEX IF NOT EXISTS idx_todos_parent_id ON todos (parent_id);
+CREATE INDEX IF NOT EXISTS idx_todos_due_at ON todos (due_at);
+CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_todos_search ON todos USING GIN (search);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id BIGSERIAL PRIMARY KEY,
+	name CITEXT COLLATE "C" NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+	todo_id BIGINT NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	tag_id BIGINT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+	PRIMARY KEY (todo_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_todo_tags_tag_id ON todo_tags (tag_id);
+
+CREATE SEQUENCE IF NOT EXISTS todo_events_id_seq;
+
+CREATE TABLE IF NOT EXISTS todo_events (
+	id BIGINT PRIMARY KEY,
+	type TEXT NOT NULL,
+	todo_id BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_todo_events_created_at ON todo_events (created_at);
+
+-- Readers resume the event stream after the last ID they saw, so IDs must
+-- become visible in order. Transactions take turns writing events, and an ID
+-- is only drawn once it is a transaction's turn.
+CREATE OR REPLACE FUNCTION todo_events_next_id() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_advisory_xact_lock(hashtext('todo_events'));
+	NEW.id := nextval('todo_events_id_seq');
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS todo_events_next_id ON todo_events;
+CREATE TRIGGER todo_events_next_id BEFORE INSERT ON todo_events
+	FOR EACH ROW EXECUTE FUNCTION todo_events_next_id();
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	headers TEXT NOT NULL DEFAULT '{}',
+	body BYTEA,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL DEFAULT '[]',
+	secret TEXT NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event_id BIGINT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	response_status INTEGER,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at TIMESTAMPTZ,
+	delivered_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
@@ -0,0 +1,4 @@
+ALTER TABLE todo_events DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE todos DROP COLUMN IF EXISTS owner_id;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,29 @@
+CREATE TABLE users (
+	id BIGSERIAL PRIMARY KEY,
+	email CITEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL DEFAULT '',
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Only a hash of each session token is kept, so the table cannot be used to log in
+CREATE TABLE sessions (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions (expires_at);
+
+-- Todos created before accounts existed have no owner
+ALTER TABLE todos ADD COLUMN owner_id BIGINT REFERENCES users (id) ON DELETE CASCADE;
+CREATE INDEX idx_todos_owner_id ON todos (owner_id);
+
+-- Events are only streamed to the owner of their todo. They outlive the
+-- todo, and its owner, so owner_id is not a foreign key.
+ALTER TABLE todo_events ADD COLUMN owner_id BIGINT;
+CREATE INDEX idx_todo_events_owner_id ON todo_events (owner_id);
@@ -0,0 +1,45 @@
+-- Tag names become unique overall again; tags of the same name in different
+-- workspaces are merged into the oldest
+ALTER TABLE tags DROP CONSTRAINT IF EXISTS tags_workspace_id_name_key;
+
+INSERT INTO todo_tags (todo_id, tag_id)
+SELECT todo_tags.todo_id, oldest.id
+FROM todo_tags
+JOIN tags ON tags.id = todo_tags.tag_id
+JOIN tags AS oldest ON oldest.name = tags.name
+WHERE oldest.id = (SELECT MIN(same.id) FROM tags AS same WHERE same.name = tags.name)
+	AND oldest.id <> tags.id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM tags WHERE id <> (SELECT MIN(same.id) FROM tags AS same WHERE same.name = tags.name);
+ALTER TABLE tags DROP COLUMN IF EXISTS workspace_id;
+ALTER TABLE tags ADD CONSTRAINT tags_name_key UNIQUE (name);
+
+ALTER TABLE webhooks DROP COLUMN IF EXISTS workspace_id;
+ALTER TABLE projects DROP COLUMN IF EXISTS workspace_id;
+
+-- Todos go back to the oldest owner of their workspace
+ALTER TABLE todos DROP CONSTRAINT IF EXISTS todos_workspace_id_fkey;
+
+UPDATE todos SET workspace_id = (
+	SELECT MIN(user_id) FROM workspace_members
+	WHERE workspace_members.workspace_id = todos.workspace_id AND role = 'owner'
+)
+WHERE workspace_id IS NOT NULL;
+
+UPDATE todo_events SET workspace_id = (
+	SELECT MIN(user_id) FROM workspace_members
+	WHERE workspace_members.workspace_id = todo_events.workspace_id AND role = 'owner'
+)
+WHERE workspace_id IS NOT NULL;
+
+ALTER INDEX idx_todo_events_workspace_id RENAME TO idx_todo_events_owner_id;
+ALTER TABLE todo_events RENAME COLUMN workspace_id TO owner_id;
+
+ALTER INDEX idx_todos_workspace_id RENAME TO idx_todos_owner_id;
+ALTER TABLE todos RENAME COLUMN workspace_id TO owner_id;
+ALTER TABLE todos ADD CONSTRAINT todos_owner_id_fkey
+	FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,79 @@
+CREATE TABLE workspaces (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	-- personal_user_id is set on each user's own workspace, which cannot be
+	-- shared or deleted
+	personal_user_id BIGINT UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE workspace_members (
+	workspace_id BIGINT NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+-- Every user gets a personal workspace with the user's ID, so the todos they
+-- owned move into it without renumbering
+INSERT INTO workspaces (id, name, personal_user_id, created_at, updated_at)
+SELECT id, 'Personal', id, created_at, created_at FROM users;
+
+SELECT setval(pg_get_serial_sequence('workspaces', 'id'), COALESCE((SELECT MAX(id) FROM workspaces), 0) + 1, false);
+
+INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+SELECT id, id, 'owner', created_at FROM users;
+
+-- Todos and their events belong to workspaces instead of users
+ALTER TABLE todos DROP CONSTRAINT todos_owner_id_fkey;
+ALTER INDEX idx_todos_owner_id RENAME TO idx_todos_workspace_id;
+ALTER TABLE todos RENAME COLUMN owner_id TO workspace_id;
+ALTER TABLE todos ADD CONSTRAINT todos_workspace_id_fkey
+	FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE;
+
+ALTER INDEX idx_todo_events_owner_id RENAME TO idx_todo_events_workspace_id;
+ALTER TABLE todo_events RENAME COLUMN owner_id TO workspace_id;
+
+-- A project moves into the workspace of its todos. Projects with no todos, or
+-- with todos in several workspaces, are left out of every workspace.
+ALTER TABLE projects ADD COLUMN workspace_id BIGINT REFERENCES workspaces (id) ON DELETE CASCADE;
+CREATE INDEX idx_projects_workspace_id ON projects (workspace_id);
+
+UPDATE projects SET workspace_id = (SELECT MIN(workspace_id) FROM todos WHERE todos.project_id = projects.id)
+WHERE (SELECT COUNT(DISTINCT workspace_id) FROM todos WHERE todos.project_id = projects.id) = 1;
+
+-- Webhooks created before workspaces only hear about todos outside every
+-- workspace
+ALTER TABLE webhooks ADD COLUMN workspace_id BIGINT REFERENCES workspaces (id) ON DELETE CASCADE;
+CREATE INDEX idx_webhooks_workspace_id ON webhooks (workspace_id);
+
+-- Tag names are unique within a workspace rather than overall. Each
+-- workspace gets its own copy of the tags its todos use.
+ALTER TABLE tags ADD COLUMN workspace_id BIGINT REFERENCES workspaces (id) ON DELETE CASCADE;
+ALTER TABLE tags DROP CONSTRAINT tags_name_key;
+
+UPDATE tags SET workspace_id = (
+	SELECT MIN(todos.workspace_id) FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id
+	WHERE todo_tags.tag_id = tags.id
+);
+
+INSERT INTO tags (workspace_id, name, created_at, updated_at)
+SELECT DISTINCT todos.workspace_id, tags.name, tags.created_at, tags.updated_at
+FROM tags
+JOIN todo_tags ON todo_tags.tag_id = tags.id
+JOIN todos ON todos.id = todo_tags.todo_id
+WHERE todos.workspace_id IS DISTINCT FROM tags.workspace_id;
+
+UPDATE todo_tags SET tag_id = copy.id
+FROM todos, tags AS original, tags AS copy
+WHERE todos.id = todo_tags.todo_id
+	AND original.id = todo_tags.tag_id
+	AND copy.name = original.name
+	AND copy.workspace_id IS NOT DISTINCT FROM todos.workspace_id
+	AND copy.id <> original.id;
+
+ALTER TABLE tags ADD CONSTRAINT tags_workspace_id_name_key UNIQUE (workspace_id, name);
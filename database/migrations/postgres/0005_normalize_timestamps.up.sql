@@ -0,0 +1,2 @@
+-- TIMESTAMPTZ columns compare as moments whatever offset they were written
+-- with, so Postgres has nothing to normalize. The version matches SQLite's.
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS todo_events;
+DROP TABLE IF EXISTS todo_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS todos;
+-- Created by InitDB when SQLite has FTS5; its triggers went with todos
+DROP TABLE IF EXISTS todos_fts;
+DROP TABLE IF EXISTS projects;
@@ -0,0 +1,112 @@
+-- The schema as it was before migrations existed. Every statement is
+-- idempotent, so databases created by earlier releases keep their tables and
+-- data. Those releases never added columns to an existing todos table, so
+-- before this runs the migrator adds the ones it lacks (see adoptLegacyTodos).
+
+CREATE TABLE IF NOT EXISTS projects (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	archived BOOLEAN NOT NULL DEFAULT FALSE,
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS todos (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	description TEXT,
+	completed BOOLEAN DEFAULT FALSE,
+	priority INTEGER NOT NULL DEFAULT 0,
+	project_id INTEGER REFERENCES projects (id) ON DELETE SET NULL,
+	parent_id INTEGER REFERENCES todos (id) ON DELETE CASCADE,
+	due_at DATETIME,
+	due_timezone TEXT NOT NULL DEFAULT '',
+	recurrence TEXT NOT NULL DEFAULT '',
+	recurrence_start DATETIME,
+	series_id INTEGER REFERENCES todos (id) ON DELETE SET NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	deleted_at DATETIME
+);
+
+-- Supports keyset pagination over (created_at, id)
+CREATE INDEX IF NOT EXISTS idx_todos_created_at_id ON todos (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_todos_project_id ON todos (project_id);
+CREATE INDEX IF NOT EXISTS idx_todos_parent_id ON todos (parent_id);
+-- Supports the overdue, today and upcoming views
+CREATE INDEX IF NOT EXISTS idx_todos_due_at ON todos (due_at);
+-- Supports the trash view and purging old trash
+CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos (deleted_at);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+	PRIMARY KEY (todo_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_todo_tags_tag_id ON todo_tags (tag_id);
+
+-- Events outlive the todos they describe, so todo_id is not a foreign key
+CREATE TABLE IF NOT EXISTS todo_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT NOT NULL,
+	todo_id INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_todo_events_created_at ON todo_events (created_at);
+
+-- A row with status 0 is a request that is still being handled
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	headers TEXT NOT NULL DEFAULT '{}',
+	body BLOB,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+-- A webhook's events are stored as a JSON array
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL DEFAULT '[]',
+	secret TEXT NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+-- Both the queue of events waiting to be sent and the log of past deliveries
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event_id INTEGER NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	response_status INTEGER,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at DATETIME,
+	delivered_at DATETIME,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
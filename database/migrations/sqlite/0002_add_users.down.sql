@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_todo_events_owner_id;
+ALTER TABLE todo_events DROP COLUMN owner_id;
+DROP INDEX IF EXISTS idx_todos_owner_id;
+ALTER TABLE todos DROP COLUMN owner_id;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,30 @@
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	name TEXT NOT NULL DEFAULT '',
+	password_hash TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+-- Only a hash of each session token is kept, so the table cannot be used to log in
+CREATE TABLE sessions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions (expires_at);
+
+-- Todos created before accounts existed have no owner. SQLite cannot drop a
+-- column that is a foreign key, so owner_id is not one, to keep this
+-- migration reversible.
+ALTER TABLE todos ADD COLUMN owner_id INTEGER;
+CREATE INDEX idx_todos_owner_id ON todos (owner_id);
+
+-- Events are only streamed to the owner of their todo
+ALTER TABLE todo_events ADD COLUMN owner_id INTEGER;
+CREATE INDEX idx_todo_events_owner_id ON todo_events (owner_id);
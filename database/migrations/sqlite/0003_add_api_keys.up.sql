@@ -0,0 +1,15 @@
+-- Only a hash of each key is kept; prefix is its first few characters, so
+-- users can tell their keys apart
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME,
+	last_used_at DATETIME
+);
+
+CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
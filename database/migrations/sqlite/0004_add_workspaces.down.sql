@@ -0,0 +1,60 @@
+-- Tag names become unique overall again; tags of the same name in different
+-- workspaces are merged into the oldest
+CREATE TABLE tags_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+INSERT INTO tags_old (id, name, created_at, updated_at)
+SELECT id, name, created_at, updated_at FROM tags
+WHERE id = (SELECT MIN(same.id) FROM tags AS same WHERE same.name = tags.name);
+
+CREATE TABLE todo_tags_old (
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags_old (id) ON DELETE CASCADE,
+	PRIMARY KEY (todo_id, tag_id)
+);
+
+INSERT OR IGNORE INTO todo_tags_old (todo_id, tag_id)
+SELECT todo_tags.todo_id, tags_old.id
+FROM todo_tags
+JOIN tags ON tags.id = todo_tags.tag_id
+JOIN tags_old ON tags_old.name = tags.name;
+
+DROP TABLE todo_tags;
+DROP TABLE tags;
+ALTER TABLE tags_old RENAME TO tags;
+ALTER TABLE todo_tags_old RENAME TO todo_tags;
+CREATE INDEX idx_todo_tags_tag_id ON todo_tags (tag_id);
+
+DROP INDEX idx_webhooks_workspace_id;
+ALTER TABLE webhooks DROP COLUMN workspace_id;
+
+DROP INDEX idx_projects_workspace_id;
+ALTER TABLE projects DROP COLUMN workspace_id;
+
+-- Todos go back to the oldest owner of their workspace
+UPDATE todos SET workspace_id = (
+	SELECT MIN(user_id) FROM workspace_members
+	WHERE workspace_members.workspace_id = todos.workspace_id AND role = 'owner'
+)
+WHERE workspace_id IS NOT NULL;
+
+UPDATE todo_events SET workspace_id = (
+	SELECT MIN(user_id) FROM workspace_members
+	WHERE workspace_members.workspace_id = todo_events.workspace_id AND role = 'owner'
+)
+WHERE workspace_id IS NOT NULL;
+
+DROP INDEX idx_todo_events_workspace_id;
+ALTER TABLE todo_events RENAME COLUMN workspace_id TO owner_id;
+CREATE INDEX idx_todo_events_owner_id ON todo_events (owner_id);
+
+DROP INDEX idx_todos_workspace_id;
+ALTER TABLE todos RENAME COLUMN workspace_id TO owner_id;
+CREATE INDEX idx_todos_owner_id ON todos (owner_id);
+
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
@@ -0,0 +1,94 @@
+CREATE TABLE workspaces (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	-- personal_user_id is set on each user's own workspace, which cannot be
+	-- shared or deleted
+	personal_user_id INTEGER UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE workspace_members (
+	workspace_id INTEGER NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+-- Every user gets a personal workspace with the user's ID, so the todos they
+-- owned move into it without renumbering
+INSERT INTO workspaces (id, name, personal_user_id, created_at, updated_at)
+SELECT id, 'Personal', id, created_at, created_at FROM users;
+
+INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+SELECT id, id, 'owner', created_at FROM users;
+
+-- Todos and their events belong to workspaces instead of users
+DROP INDEX idx_todos_owner_id;
+ALTER TABLE todos RENAME COLUMN owner_id TO workspace_id;
+CREATE INDEX idx_todos_workspace_id ON todos (workspace_id);
+
+DROP INDEX idx_todo_events_owner_id;
+ALTER TABLE todo_events RENAME COLUMN owner_id TO workspace_id;
+CREATE INDEX idx_todo_events_workspace_id ON todo_events (workspace_id);
+
+-- A project moves into the workspace of its todos. Projects with no todos, or
+-- with todos in several workspaces, are left out of every workspace.
+ALTER TABLE projects ADD COLUMN workspace_id INTEGER;
+CREATE INDEX idx_projects_workspace_id ON projects (workspace_id);
+
+UPDATE projects SET workspace_id = (SELECT MIN(workspace_id) FROM todos WHERE todos.project_id = projects.id)
+WHERE (SELECT COUNT(DISTINCT workspace_id) FROM todos WHERE todos.project_id = projects.id) = 1;
+
+-- Webhooks created before workspaces only hear about todos outside every
+-- workspace
+ALTER TABLE webhooks ADD COLUMN workspace_id INTEGER;
+CREATE INDEX idx_webhooks_workspace_id ON webhooks (workspace_id);
+
+-- Tag names are unique within a workspace rather than overall. SQLite cannot
+-- drop a UNIQUE constraint, so tags and todo_tags are rebuilt, giving each
+-- workspace its own copy of the tags its todos use.
+CREATE TABLE tags_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	workspace_id INTEGER,
+	name TEXT NOT NULL COLLATE NOCASE,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	UNIQUE (workspace_id, name)
+);
+
+INSERT INTO tags_new (id, workspace_id, name, created_at, updated_at)
+SELECT id, (
+	SELECT MIN(todos.workspace_id) FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id
+	WHERE todo_tags.tag_id = tags.id
+), name, created_at, updated_at
+FROM tags;
+
+INSERT INTO tags_new (workspace_id, name, created_at, updated_at)
+SELECT DISTINCT todos.workspace_id, tags.name, tags.created_at, tags.updated_at
+FROM tags
+JOIN todo_tags ON todo_tags.tag_id = tags.id
+JOIN todos ON todos.id = todo_tags.todo_id
+WHERE todos.workspace_id IS NOT (SELECT workspace_id FROM tags_new WHERE tags_new.id = tags.id);
+
+CREATE TABLE todo_tags_new (
+	todo_id INTEGER NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES tags_new (id) ON DELETE CASCADE,
+	PRIMARY KEY (todo_id, tag_id)
+);
+
+INSERT INTO todo_tags_new (todo_id, tag_id)
+SELECT todo_tags.todo_id, tags_new.id
+FROM todo_tags
+JOIN tags ON tags.id = todo_tags.tag_id
+JOIN todos ON todos.id = todo_tags.todo_id
+JOIN tags_new ON tags_new.name = tags.name AND tags_new.workspace_id IS todos.workspace_id;
+
+DROP TABLE todo_tags;
+DROP TABLE tags;
+ALTER TABLE tags_new RENAME TO tags;
+ALTER TABLE todo_tags_new RENAME TO todo_tags;
+CREATE INDEX idx_todo_tags_tag_id ON todo_tags (tag_id);
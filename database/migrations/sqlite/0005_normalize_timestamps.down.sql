@@ -0,0 +1,2 @@
+-- The rewritten times are the same moments as before, so there is nothing to
+-- undo
@@ -0,0 +1,12 @@
+-- Releases before 0001 wrote the times of todos with the server's offset,
+-- such as 2024-01-01 12:00:00+02:00, where every later release writes UTC.
+-- Times are compared and sorted as text, so those are rewritten in UTC,
+-- keeping their fraction of a second.
+
+UPDATE todos
+SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE created_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND substr(created_at, -6) <> '+00:00';
+
+UPDATE todos
+SET updated_at = strftime('%Y-%m-%d %H:%M:%S', updated_at) || substr(updated_at, 20, length(updated_at) - 25) || '+00:00'
+WHERE updated_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND substr(updated_at, -6) <> '+00:00';
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// InitPostgres connects to the Postgres database at dsn and migrates it to
+// the latest schema
+func InitPostgres(dsn string) (*sql.DB, error) {
+	db, err := OpenPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Println("Database initialized successfully")
+	return db, nil
+}
+
+// OpenPostgres connects to the Postgres database at dsn without migrating
+// it. The models write their queries with SQLite's ? placeholders;
+// connections opened here rewrite them into $1, $2, ...
+func OpenPostgres(dsn string) (*sql.DB, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+	// Read timestamps back in UTC, as they are stored in SQLite
+	dsn += " timezone=UTC"
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db := sql.OpenDB(postgresConnector{connector})
+
+	// Test the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return db, nil
+}
+
+// postgresConnector opens lib/pq connections that accept ? placeholders
+type postgresConnector struct {
+	*pq.Connector
+}
+
+// Connect opens a connection to the database
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return postgresConn{conn}, nil
+}
+
+// postgresConn rebinds the placeholders of every query before lib/pq sees
+// it. lib/pq connections implement all the optional interfaces used here.
+type postgresConn struct {
+	driver.Conn
+}
+
+func (c postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(Rebind(query))
+}
+
+func (c postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, Rebind(query))
+}
+
+func (c postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, Rebind(query), args)
+}
+
+func (c postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, Rebind(query), args)
+}
+
+func (c postgresConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c postgresConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c postgresConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c postgresConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+// Rebind numbers the ? placeholders of a query as $1, $2, ... Question
+// marks inside string constants, quoted identifiers, dollar-quoted strings
+// and comments are left alone, and ?? stands for a literal ?, as in the JSONB
+// operators ?, ?| and ?&.
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); {
+		switch {
+		case strings.HasPrefix(query[i:], "??"):
+			b.WriteByte('?')
+			i += 2
+		case query[i] == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			i++
+		default:
+			end := skipQuoted(query, i)
+			b.WriteString(query[i:end])
+			i = end
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns where the string constant, quoted identifier,
+// dollar-quoted string or comment starting at query[i] ends, or i+1 when
+// none starts there. One left open runs to the end of the query.
+func skipQuoted(query string, i int) int {
+	rest := query[i:]
+	end := -1
+	switch {
+	case strings.HasPrefix(rest, "--"):
+		if end = strings.IndexByte(rest, '\n'); end >= 0 {
+			end++
+		}
+	case strings.HasPrefix(rest, "/*"):
+		// Block comments nest
+		depth := 0
+		for j := 0; j+1 < len(rest); j++ {
+			if rest[j] == '/' && rest[j+1] == '*' {
+				depth++
+				j++
+			} else if rest[j] == '*' && rest[j+1] == '/' {
+				depth--
+				j++
+				if depth == 0 {
+					end = j + 1
+					break
+				}
+			}
+		}
+	case rest[0] == '\'':
+		// In E'...' strings a backslash escapes the next character; a doubled
+		// quote is read as two strings, which skips it just the same
+		escapes := i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i == 1 || !isIdentByte(query[i-2]))
+		for j := 1; j < len(rest) && end < 0; j++ {
+			if escapes && rest[j] == '\\' {
+				j++
+			} else if rest[j] == '\'' {
+				end = j + 1
+			}
+		}
+	case rest[0] == '"':
+		if end = strings.IndexByte(rest[1:], '"'); end >= 0 {
+			end += 2
+		}
+	case rest[0] == '$' && (i == 0 || !isIdentByte(query[i-1])):
+		tag := dollarTag(rest)
+		if tag == "" {
+			return i + 1
+		}
+		if end = strings.Index(rest[len(tag):], tag); end >= 0 {
+			end += 2 * len(tag)
+		}
+	default:
+		return i + 1
+	}
+
+	if end < 0 {
+		return len(query)
+	}
+	return i + end
+}
+
+// dollarTag returns the $tag$ or $$ opening a dollar-quoted string at the
+// start of s, or "" if there is none. Tags cannot start with a digit, which
+// tells them apart from $1 parameters.
+func dollarTag(s string) string {
+	j := 1
+	for j < len(s) && s[j] != '$' && isIdentByte(s[j]) && !(j == 1 && s[j] >= '0' && s[j] <= '9') {
+		j++
+	}
+	if j < len(s) && s[j] == '$' {
+		return s[:j+1]
+	}
+	return ""
+}
+
+// isIdentByte reports whether c can be part of an unquoted identifier
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
@@ -4,47 +4,172 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// InitDB initializes the SQLite database and creates the todos table
+// InitDB opens the SQLite database at dbPath and migrates it to the latest schema
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// The search index depends on how SQLite was built rather than on the
+	// schema version, so it is created outside the migrations
+	if err := createTodosSearchTable(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create todos search table: %w", err)
+	}
+
+	log.Println("Database initialized successfully")
+	return db, nil
+}
+
+// OpenDB opens the SQLite database at dbPath without migrating it
+func OpenDB(dbPath string) (*sql.DB, error) {
+	// Foreign keys are off by default in SQLite and must be enabled per connection
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open("sqlite3", dbPath+separator+"_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Create the todos table if it doesn't exist
-	if err := createTodosTable(db); err != nil {
-		return nil, fmt.Errorf("failed to create todos table: %w", err)
+	return db, nil
+}
+
+// migrate applies the pending migrations to db
+func migrate(db *sql.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
 	}
+	return migrator.Up()
+}
 
-	log.Println("Database initialized successfully")
-	return db, nil
+// legacyTodoColumns lists the columns that releases before migrations added
+// to todos, in the order they were added. Those releases only created tables
+// that did not exist, so a todos table may lack any of them.
+var legacyTodoColumns = []struct{ name, definition string }{
+	{"due_at", "DATETIME"},
+	{"due_timezone", "TEXT NOT NULL DEFAULT ''"},
+	{"priority", "INTEGER NOT NULL DEFAULT 0"},
+	{"project_id", "INTEGER REFERENCES projects (id) ON DELETE SET NULL"},
+	{"parent_id", "INTEGER REFERENCES todos (id) ON DELETE CASCADE"},
+	{"recurrence", "TEXT NOT NULL DEFAULT ''"},
+	{"recurrence_start", "DATETIME"},
+	{"series_id", "INTEGER REFERENCES todos (id) ON DELETE SET NULL"},
+	{"deleted_at", "DATETIME"},
+	{"version", "INTEGER NOT NULL DEFAULT 1"},
 }
 
-// createTodosTable creates the todos table with the required schema
-func createTodosTable(db *sql.DB) error {
+// adoptLegacyTodos adds the columns a todos table created by an earlier
+// release lacks, before the first migration indexes them. It does nothing
+// when there is no todos table yet.
+func adoptLegacyTodos(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info('todos')`)
+	if err != nil {
+		return fmt.Errorf("failed to read todos columns: %w", err)
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if len(columns) == 0 {
+		return nil
+	}
+	for _, column := range legacyTodoColumns {
+		if columns[column.name] {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE todos ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+			return fmt.Errorf("failed to add todos.%s: %w", column.name, err)
+		}
+	}
+
+	return nil
+}
+
+// createTodosSearchTable creates the todos_fts FTS5 index and the triggers
+// that keep it in sync with every insert, update and delete on todos.
+// FTS5 is only available when go-sqlite3 is built with the sqlite_fts5 tag;
+// without it the index is skipped and search reports itself unavailable.
+func createTodosSearchTable(db *sql.DB) error {
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'todos_fts'`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up todos_fts: %w", err)
+	}
+
 	query := `
-		CREATE TABLE IF NOT EXISTS todos (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			title TEXT NOT NULL,
-			description TEXT,
-			completed BOOLEAN DEFAULT FALSE,
-			created_at DATETIME NOT NULL,
-			updated_at DATETIME NOT NULL
+		CREATE VIRTUAL TABLE IF NOT EXISTS todos_fts USING fts5(
+			title,
+			description,
+			content = 'todos',
+			content_rowid = 'id',
+			tokenize = 'unicode61 remove_diacritics 2'
 		)
 	`
+	if _, err := db.Exec(query); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Println("SQLite was built without FTS5, full-text search is disabled")
+			return nil
+		}
+		return fmt.Errorf("failed to create todos_fts table: %w", err)
+	}
 
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("failed to create todos table: %w", err)
+	triggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS todos_fts_insert AFTER INSERT ON todos BEGIN
+			INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS todos_fts_delete AFTER DELETE ON todos BEGIN
+			INSERT INTO todos_fts (todos_fts, rowid, title, description)
+			VALUES ('delete', old.id, old.title, old.description);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS todos_fts_update AFTER UPDATE OF title, description ON todos BEGIN
+			INSERT INTO todos_fts (todos_fts, rowid, title, description)
+			VALUES ('delete', old.id, old.title, old.description);
+			INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+		END`,
+	}
+	for _, trigger := range triggers {
+		if _, err := db.Exec(trigger); err != nil {
+			return fmt.Errorf("failed to create todos_fts trigger: %w", err)
+		}
+	}
+
+	// Index todos that were written before the search table existed
+	if exists == 0 {
+		if _, err := db.Exec(`INSERT INTO todos_fts (todos_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to build todos_fts index: %w", err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// APIKeyHandler handles HTTP requests for the logged in user's API keys
+type APIKeyHandler struct {
+	apiKeyModel *models.APIKeyModel
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler instance
+func NewAPIKeyHandler(apiKeyModel *models.APIKeyModel) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyModel: apiKeyModel,
+	}
+}
+
+// GetAPIKeys handles GET /api-keys - lists the user's API keys, newest first
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyModel.List(CurrentPrincipal(c).UserID)
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve API keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey handles POST /api-keys - creates an API key. The response is
+// the only time the key itself is shown.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	key, err := h.apiKeyModel.Create(CurrentPrincipal(c).UserID, req)
+	if err != nil {
+		respondError(c, err, "", "Failed to create API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// RevokeAPIKey handles DELETE /api-keys/:id - revokes an API key
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := h.apiKeyModel.Revoke(CurrentPrincipal(c).UserID, id); err != nil {
+		respondError(c, err, "API key not found", "Failed to revoke API key")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// principalKey is the gin context key holding the *models.Principal a
+// request is made by
+const principalKey = "principal"
+
+// AuthHandler handles HTTP requests for accounts and logging in
+type AuthHandler struct {
+	userModel  *models.UserModel
+	tokens     *models.TokenIssuer
+	sessionTTL time.Duration
+}
+
+// NewAuthHandler creates a new AuthHandler instance. Access tokens are
+// issued by tokens; refresh tokens last for sessionTTL.
+func NewAuthHandler(userModel *models.UserModel, tokens *models.TokenIssuer, sessionTTL time.Duration) *AuthHandler {
+	return &AuthHandler{
+		userModel:  userModel,
+		tokens:     tokens,
+		sessionTTL: sessionTTL,
+	}
+}
+
+// APIKeyHeader is the header API keys can be sent in, instead of Authorization
+const APIKeyHeader = "X-API-Key"
+
+// Authenticate returns middleware that finds who a request is made by and
+// makes them available to the handlers after it. It accepts a JWT access
+// token in the Authorization header ("Bearer <token>"), or an API key there
+// or in X-API-Key. Requests without either go ahead anonymously; requests
+// with an invalid or expired one fail with 401.
+func Authenticate(tokens *models.TokenIssuer, apiKeyModel *models.APIKeyModel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			token, ok = key, true
+		}
+		if !ok {
+			c.Next()
+			return
+		}
+
+		var principal *models.Principal
+		var err error
+		if models.IsAPIKey(token) {
+			principal, err = apiKeyModel.Authenticate(token)
+		} else {
+			principal, err = tokens.Verify(token)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrInvalidAPIKey):
+				respondUnauthorized(c, "invalid_token", "Invalid, revoked or expired API key")
+			case errors.Is(err, models.ErrInvalidToken):
+				respondUnauthorized(c, "invalid_token", "Invalid or expired access token")
+			default:
+				respondError(c, err, "", "Failed to check API key")
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireUser returns middleware that fails with 401 unless Authenticate
+// found a principal
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CurrentPrincipal(c) == nil {
+			respondUnauthorized(c, "", "Authentication required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope returns middleware that fails with 403 unless the principal
+// may use a resource: "<resource>:read" for GET and HEAD requests, and
+// "<resource>:write" for the rest. Anonymous requests are left to
+// RequireUser.
+func RequireScope(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := resource + ":write"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = resource + ":read"
+		}
+
+		if principal := CurrentPrincipal(c); principal != nil && !principal.HasScope(scope) {
+			respondProblem(c, http.StatusForbidden, "API key lacks the "+scope+" scope")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireLogin returns middleware that fails with 403 for requests made with
+// an API key, for routes only a logged in user may use. Anonymous requests
+// are left to RequireUser.
+func RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal := CurrentPrincipal(c); principal != nil && principal.APIKeyID != 0 {
+			respondProblem(c, http.StatusForbidden, "API keys cannot be used here; log in instead")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentPrincipal returns who the request is authenticated as, or nil
+func CurrentPrincipal(c *gin.Context) *models.Principal {
+	if principal, ok := c.Get(principalKey); ok {
+		return principal.(*models.Principal)
+	}
+	return nil
+}
+
+// bearerToken reads the token of an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// respondUnauthorized writes a 401 response asking for a bearer token, with
+// the RFC 6750 error code if one is given
+func respondUnauthorized(c *gin.Context, code, detail string) {
+	challenge := `Bearer realm="todo-api"`
+	if code != "" {
+		challenge += `, error="` + code + `"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	respondProblem(c, http.StatusUnauthorized, detail)
+}
+
+// Register handles POST /auth/register - creates an account
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req models.RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	user, err := h.userModel.Register(req)
+	if err != nil {
+		respondError(c, err, "", "Failed to create account")
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// Login handles POST /auth/login - checks an email and password and returns
+// an access token and a refresh token
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	session, err := h.userModel.Login(req.Email, req.Password, h.sessionTTL)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			respondUnauthorized(c, "", "Invalid email or password")
+		} else {
+			respondError(c, err, "", "Failed to log in")
+		}
+		return
+	}
+
+	h.respondTokens(c, session)
+}
+
+// Refresh handles POST /auth/refresh - exchanges a refresh token for a new
+// access token and refresh token. The old refresh token stops working.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	session, err := h.userModel.Refresh(req.RefreshToken, h.sessionTTL)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidSession) {
+			respondUnauthorized(c, "invalid_token", "Invalid or expired refresh token")
+		} else {
+			respondError(c, err, "", "Failed to refresh tokens")
+		}
+		return
+	}
+
+	h.respondTokens(c, session)
+}
+
+// respondTokens issues an access token for a session and writes it with the
+// session's refresh token
+func (h *AuthHandler) respondTokens(c *gin.Context, session *models.Session) {
+	accessToken, expiresAt, err := h.tokens.Issue(session.User)
+	if err != nil {
+		respondError(c, err, "", "Failed to issue access token")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TokenPair{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(time.Until(expiresAt).Round(time.Second).Seconds()),
+		RefreshToken:     session.Token,
+		RefreshExpiresAt: session.ExpiresAt,
+		User:             session.User,
+	})
+}
+
+// Logout handles POST /auth/logout - revokes a refresh token. Access tokens
+// issued from it stay valid until they expire.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if err := h.userModel.Logout(req.RefreshToken); err != nil {
+		respondError(c, err, "", "Failed to log out")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// Me handles GET /auth/me - returns the authenticated user
+func (h *AuthHandler) Me(c *gin.Context) {
+	user, err := h.userModel.GetByID(CurrentPrincipal(c).UserID)
+	if err != nil {
+		respondError(c, err, "User not found", "Failed to retrieve user")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
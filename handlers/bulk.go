@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/umair/go-todo-api/models"
+)
+
+// Bulk modes
+const (
+	// BulkAtomic rolls every operation back when one fails
+	BulkAtomic = "atomic"
+	// BulkBestEffort skips failed operations and keeps the rest
+	BulkBestEffort = "best_effort"
+)
+
+// BulkRequest represents the request body for POST /todos/bulk
+type BulkRequest struct {
+	// Mode is atomic (the default) or best_effort
+	Mode       string                 `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Operations []BulkOperationRequest `json:"operations" binding:"required,min=1,max=1000"`
+}
+
+// BulkOperationRequest is one operation of a bulk request. Todo is the body
+// of a create or update, and is validated like the body of POST or PUT /todos.
+type BulkOperationRequest struct {
+	Op models.BulkOp `json:"op"`
+	// ID is the todo written by every operation except create
+	ID int `json:"id"`
+	// Version, when set, must match the todo's current version
+	Version int             `json:"version"`
+	Todo    json.RawMessage `json:"todo"`
+}
+
+// BulkResult is the outcome of one operation, in the same position as the operation
+type BulkResult struct {
+	Status int          `json:"status"`
+	Todo   *models.Todo `json:"todo,omitempty"`
+	Error  *Problem     `json:"error,omitempty"`
+}
+
+// BulkResponse represents the response body of POST /todos/bulk
+type BulkResponse struct {
+	// Committed is false when an atomic request failed and nothing was written
+	Committed bool         `json:"committed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// BulkTodos handles POST /todos/bulk - runs create, update, delete and complete
+// operations in one transaction. In atomic mode the first failing operation
+// rolls back the whole request; in best_effort mode only that operation is
+// skipped. Each operation gets its own status, in the order they were sent.
+func (h *TodoHandler) BulkTodos(c *gin.Context) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	atomic := req.Mode != BulkBestEffort
+
+	results := make([]BulkResult, len(req.Operations))
+	var ops []models.BulkOperation
+	// positions maps each operation sent to the model back to its place in the request
+	var positions []int
+	invalid := -1
+	for i, opReq := range req.Operations {
+		op, problem := parseBulkOperation(c, opReq)
+		if problem != nil {
+			results[i] = BulkResult{Status: problem.Status, Error: problem}
+			if invalid < 0 {
+				invalid = i
+			}
+			continue
+		}
+		ops = append(ops, op)
+		positions = append(positions, i)
+	}
+
+	// An atomic request with an invalid operation is rejected without running any
+	if atomic && invalid >= 0 {
+		detail := fmt.Sprintf("Not attempted because operation %d is invalid", invalid)
+		for _, i := range positions {
+			results[i] = failedDependency(c, detail)
+		}
+		c.JSON(http.StatusOK, BulkResponse{Committed: false, Results: results})
+		return
+	}
+
+	outcomes, committed, err := todosFor(c, h.todoModel).Bulk(ops, atomic)
+	if err != nil {
+		respondError(c, err, "", "Failed to run bulk operations")
+		return
+	}
+
+	failed := -1
+	for j, outcome := range outcomes {
+		if outcome.Err != nil && !committed {
+			failed = positions[j]
+		}
+	}
+
+	for j, outcome := range outcomes {
+		i := positions[j]
+		switch {
+		case outcome.Err != nil:
+			problem := errorProblem(c, outcome.Err, "Todo not found", "Failed to run operation")
+			results[i] = BulkResult{Status: problem.Status, Error: &problem}
+		case !committed && i < failed:
+			results[i] = failedDependency(c, fmt.Sprintf("Rolled back because operation %d failed", failed))
+		case !committed:
+			results[i] = failedDependency(c, fmt.Sprintf("Not attempted because operation %d failed", failed))
+		case ops[j].Op == models.BulkCreate:
+			results[i] = BulkResult{Status: http.StatusCreated, Todo: outcome.Todo}
+		default:
+			results[i] = BulkResult{Status: http.StatusOK, Todo: outcome.Todo}
+		}
+	}
+
+	c.JSON(http.StatusOK, BulkResponse{Committed: committed, Results: results})
+}
+
+// parseBulkOperation checks one operation of a bulk request and decodes its
+// todo body. It returns a 400 problem if the operation is invalid.
+func parseBulkOperation(c *gin.Context, req BulkOperationRequest) (models.BulkOperation, *Problem) {
+	op := models.BulkOperation{Op: req.Op, ID: req.ID, Version: req.Version}
+
+	invalid := func(name, reason string) (models.BulkOperation, *Problem) {
+		problem := newProblem(c, http.StatusBadRequest, "Invalid operation", InvalidParam{Name: name, Reason: reason})
+		return op, &problem
+	}
+
+	switch req.Op {
+	case models.BulkCreate, models.BulkUpdate, models.BulkDelete, models.BulkComplete:
+	case "":
+		return invalid("op", "is required")
+	default:
+		return invalid("op", "must be one of create, update, delete, complete")
+	}
+
+	if req.Op != models.BulkCreate && req.ID <= 0 {
+		return invalid("id", "is required")
+	}
+
+	var body interface{}
+	switch req.Op {
+	case models.BulkCreate:
+		body = &op.Create
+	case models.BulkUpdate:
+		body = &op.Update
+	default:
+		return op, nil
+	}
+
+	if len(bytes.TrimSpace(req.Todo)) == 0 {
+		return invalid("todo", "is required")
+	}
+	err := json.Unmarshal(req.Todo, body)
+	if err == nil {
+		err = binding.Validator.ValidateStruct(body)
+	}
+	if err != nil {
+		problem := bindErrorProblem(c, err)
+		// Name the fields as they appear in the bulk request
+		for i := range problem.InvalidParams {
+			problem.InvalidParams[i].Name = "todo." + problem.InvalidParams[i].Name
+		}
+		return op, &problem
+	}
+
+	return op, nil
+}
+
+// failedDependency is the result of an operation that was rolled back or not
+// attempted because another operation of an atomic request failed
+func failedDependency(c *gin.Context, detail string) BulkResult {
+	problem := newProblem(c, http.StatusFailedDependency, detail)
+	return BulkResult{Status: problem.Status, Error: &problem}
+}
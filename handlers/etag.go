@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// todoETag returns the entity tag of a todo, which is its version
+func todoETag(todo *models.Todo) string {
+	return `"` + strconv.Itoa(todo.Version) + `"`
+}
+
+// respondTodo writes a todo along with its ETag
+func respondTodo(c *gin.Context, status int, todo *models.Todo) {
+	c.Header("ETag", todoETag(todo))
+	c.JSON(status, todo)
+}
+
+// etagListMatches reports whether an If-Match or If-None-Match header lists
+// the entity tag. Weak tags only count when weak comparison is allowed.
+func etagListMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header of a write against the current
+// todo. It returns the version the write must still find in place, or 0 when
+// the request has no If-Match header. It writes a 404 or 412 response and
+// returns false when the write must not go ahead.
+func checkIfMatch(c *gin.Context, todoModel models.TodoRepository, id int) (int, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	todo, err := todoModel.GetByID(id)
+	if err != nil {
+		respondError(c, err, "Todo not found", "Failed to retrieve todo")
+		return 0, false
+	}
+	if !etagListMatches(header, todoETag(todo), false) {
+		respondStaleVersion(c)
+		return 0, false
+	}
+
+	return todo.Version, true
+}
+
+// staleVersionDetail explains a 412 response to a write whose If-Match is out of date
+const staleVersionDetail = "Todo has been modified since it was read"
+
+// respondStaleVersion writes the 412 response for a write whose If-Match is out of date
+func respondStaleVersion(c *gin.Context) {
+	respondProblem(c, http.StatusPreconditionFailed, staleVersionDetail)
+}
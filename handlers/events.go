@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+const (
+	// eventBatchSize is how many events are read from the log at a time when catching up
+	eventBatchSize = 100
+	// eventBuffer is how far a stream may fall behind the live feed before it
+	// has to catch up from the log
+	eventBuffer = 256
+	// eventHeartbeat is how often an idle stream sends a comment to keep the connection open
+	eventHeartbeat = 15 * time.Second
+)
+
+// StreamTodoEvents handles GET /todos/events - streams changes to todos as
+// Server-Sent Events. Each event's id is its position in the event log, so a
+// client that reconnects with Last-Event-ID (or ?last_event_id=) gets every
+// event it missed. Without one the stream starts with the next change.
+func (h *TodoHandler) StreamTodoEvents(c *gin.Context) {
+	lastID, ok := parseLastEventID(c)
+	if !ok {
+		return
+	}
+	if lastID < 0 {
+		id, err := todosFor(c, h.todoModel).LastEventID()
+		if err != nil {
+			respondError(c, err, "", "Failed to read the event log")
+			return
+		}
+		lastID = id
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop proxies such as nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		// Subscribe before reading the log, so no event falls between the two
+		live, unsubscribe := todosFor(c, h.todoModel).Subscribe(eventBuffer)
+		caughtUp, err := h.catchUp(c, &lastID)
+		if err != nil {
+			log.Printf("Failed to read the event log: %v", err)
+		}
+		if err != nil || !caughtUp {
+			unsubscribe()
+			return
+		}
+
+		for open := true; open; {
+			select {
+			case <-c.Request.Context().Done():
+				unsubscribe()
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+					unsubscribe()
+					return
+				}
+				c.Writer.Flush()
+			case event, ok := <-live:
+				if !ok {
+					// The stream fell behind the live feed; catch up from the log
+					open = false
+					continue
+				}
+				if event.ID <= lastID {
+					continue
+				}
+				if err := writeEvent(c, event); err != nil {
+					unsubscribe()
+					return
+				}
+				lastID = event.ID
+			}
+		}
+	}
+}
+
+// parseLastEventID reads the ID of the last event the client saw, or -1 if
+// it did not send one. It writes a 400 response and returns false if the ID
+// is invalid.
+func parseLastEventID(c *gin.Context) (int64, bool) {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	if value == "" {
+		return -1, true
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		respondProblem(c, http.StatusBadRequest, "Invalid Last-Event-ID", InvalidParam{
+			Name:   "Last-Event-ID",
+			Reason: "must be a non-negative integer",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// catchUp writes every logged event after lastID and moves lastID along. It
+// returns false if the client went away.
+func (h *TodoHandler) catchUp(c *gin.Context, lastID *int64) (bool, error) {
+	for {
+		events, err := todosFor(c, h.todoModel).EventsSince(*lastID, eventBatchSize)
+		if err != nil {
+			return false, err
+		}
+		for _, event := range events {
+			if err := writeEvent(c, event); err != nil {
+				return false, nil
+			}
+			*lastID = event.ID
+		}
+		if len(events) < eventBatchSize {
+			return c.Request.Context().Err() == nil, nil
+		}
+	}
+}
+
+// writeEvent writes one todo event to the stream and flushes it to the client
+func writeEvent(c *gin.Context, event models.TodoEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
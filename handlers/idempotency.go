@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// IdempotencyKeyHeader is the request header that makes a write safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key accepted
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with an idempotent response
+var replayedHeaders = []string{"Content-Type", "ETag", "Link", "Location"}
+
+// Idempotency returns middleware that honors the Idempotency-Key header on
+// POST, PUT, PATCH and DELETE requests. The first request with a key runs as
+// usual and its response is kept for ttl; a retry with the same key and body
+// gets that response again without running, marked with Idempotent-Replayed.
+// Reusing a key for a different request fails with 422. Responses with a 5xx
+// status are not kept, so the request can be retried. Behind Authenticate,
+// every user has keys of their own.
+func Idempotency(idempotencyModel *models.IdempotencyModel, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			respondProblem(c, http.StatusBadRequest, "Invalid Idempotency-Key", InvalidParam{
+				Name:   IdempotencyKeyHeader,
+				Reason: "must be at most 255 characters",
+			})
+			c.Abort()
+			return
+		}
+
+		// A header cannot hold a newline, so no client chosen key looks like another user's
+		if principal := CurrentPrincipal(c); principal != nil {
+			key = strconv.Itoa(principal.UserID) + "\n" + key
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, "Failed to read request body")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := idempotencyModel.Begin(key, requestFingerprint(c.Request, body), ttl)
+		if err != nil {
+			if errors.Is(err, models.ErrIdempotencyKeyReused) {
+				respondProblem(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			} else {
+				respondError(c, err, "", "Failed to check Idempotency-Key")
+			}
+			c.Abort()
+			return
+		}
+		if stored != nil {
+			for name, value := range stored.Header {
+				c.Header(name, value)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.Header["Content-Type"], stored.Body)
+			c.Abort()
+			return
+		}
+
+		// Release the key unless a response is stored, including when a handler panics
+		defer func() {
+			if stored == nil {
+				if err := idempotencyModel.Release(key); err != nil {
+					log.Printf("Failed to release idempotency key: %v", err)
+				}
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		header := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		stored = &models.StoredResponse{
+			Status: recorder.Status(),
+			Header: header,
+			Body:   recorder.body.Bytes(),
+		}
+		if err := idempotencyModel.Finish(key, *stored); err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+			stored = nil
+		}
+	}
+}
+
+// requestFingerprint identifies a request by its method, URL and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body as it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes to the response and keeps a copy
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes to the response and keeps a copy
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/umair/go-todo-api/models"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object, used for every error response
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance string `json:"instance,omitempty"`
+	// InvalidParams lists the request fields that failed validation
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+}
+
+// InvalidParam describes why one request field failed validation
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func init() {
+	// Report validation failures under the names clients send, not the Go field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, key := range []string{"json", "form"} {
+				name := strings.Split(field.Tag.Get(key), ",")[0]
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return field.Name
+		})
+	}
+}
+
+// newProblem builds a problem details object for the current request
+func newProblem(c *gin.Context, status int, detail string, params ...InvalidParam) Problem {
+	return Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      c.Request.URL.Path,
+		InvalidParams: params,
+	}
+}
+
+// writeProblem writes a problem details response
+func writeProblem(c *gin.Context, problem Problem) {
+	// gin keeps a Content-Type that is already set when rendering JSON
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(problem.Status, problem)
+}
+
+// respondProblem writes a problem details response
+func respondProblem(c *gin.Context, status int, detail string, params ...InvalidParam) {
+	writeProblem(c, newProblem(c, status, detail, params...))
+}
+
+// respondError maps an error returned by the models onto a problem response.
+// notFound is the detail used for ErrNotFound and fallback the one used for
+// unexpected errors, which are logged and answered with 500.
+func respondError(c *gin.Context, err error, notFound, fallback string) {
+	writeProblem(c, errorProblem(c, err, notFound, fallback))
+}
+
+// errorProblem builds the problem respondError writes for an error
+func errorProblem(c *gin.Context, err error, notFound, fallback string) Problem {
+	var kindErr *models.KindError
+	var params []InvalidParam
+	if errors.As(err, &kindErr) && kindErr.Field != "" {
+		params = []InvalidParam{{Name: kindErr.Field, Reason: err.Error()}}
+	}
+
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return newProblem(c, http.StatusNotFound, notFound)
+	case errors.Is(err, models.ErrVersionMismatch):
+		return newProblem(c, http.StatusPreconditionFailed, staleVersionDetail)
+	case errors.Is(err, models.ErrValidation):
+		return newProblem(c, http.StatusBadRequest, err.Error(), params...)
+	case errors.Is(err, models.ErrConflict):
+		return newProblem(c, http.StatusConflict, err.Error(), params...)
+	case errors.Is(err, models.ErrForbidden):
+		return newProblem(c, http.StatusForbidden, err.Error())
+	}
+
+	log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	return newProblem(c, http.StatusInternalServerError, fallback)
+}
+
+// respondBindError writes the 400 response for a request body or query
+// string that could not be bound, listing the fields at fault
+func respondBindError(c *gin.Context, err error) {
+	writeProblem(c, bindErrorProblem(c, err))
+}
+
+// bindErrorProblem builds the problem respondBindError writes for an error
+func bindErrorProblem(c *gin.Context, err error) Problem {
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &validationErrs):
+		params := make([]InvalidParam, len(validationErrs))
+		for i, fe := range validationErrs {
+			params[i] = InvalidParam{Name: fieldPath(fe), Reason: validationReason(fe)}
+		}
+		return newProblem(c, http.StatusBadRequest, "Invalid request data", params...)
+	case errors.As(err, &typeErr):
+		return newProblem(c, http.StatusBadRequest, "Invalid request data", InvalidParam{
+			Name:   typeErr.Field,
+			Reason: "must be of type " + typeErr.Type.String(),
+		})
+	case errors.As(err, &syntaxErr):
+		return newProblem(c, http.StatusBadRequest, "Request body is not valid JSON")
+	}
+
+	return newProblem(c, http.StatusBadRequest, "Invalid request data: "+err.Error())
+}
+
+// fieldPath returns the client-facing path of a failed field, such as "tags[2]"
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fe.Field()
+}
+
+// validationReason explains a failed validation rule in words
+func validationReason(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be at least %s characters", fe.Param())
+		case reflect.Slice, reflect.Map:
+			return fmt.Sprintf("must have at least %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be at most %s characters", fe.Param())
+		case reflect.Slice, reflect.Map:
+			return fmt.Sprintf("must have at most %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "email":
+		return "must be an email address"
+	case "timezone":
+		return "must be an IANA time zone name"
+	case "hexcolor":
+		return "must be a hex color such as #ff8800"
+	}
+	return "failed the " + fe.Tag() + " rule"
+}
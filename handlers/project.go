@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// ProjectHandler handles HTTP requests for projects and the todos nested under them
+type ProjectHandler struct {
+	projectModel *models.ProjectModel
+	todoModel    models.TodoRepository
+}
+
+// NewProjectHandler creates a new ProjectHandler instance
+func NewProjectHandler(projectModel *models.ProjectModel, todoModel models.TodoRepository) *ProjectHandler {
+	return &ProjectHandler{
+		projectModel: projectModel,
+		todoModel:    todoModel,
+	}
+}
+
+// GetProjects handles GET /projects - retrieves projects in display order.
+// Archived projects are only included with ?include_archived=true.
+func (h *ProjectHandler) GetProjects(c *gin.Context) {
+	includeArchived, _ := strconv.ParseBool(c.Query("include_archived"))
+
+	projects, err := projectsFor(c, h.projectModel).GetAll(includeArchived)
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve projects")
+		return
+	}
+
+	c.JSON(http.StatusOK, projects)
+}
+
+// GetProject handles GET /projects/:id - retrieves a specific project
+func (h *ProjectHandler) GetProject(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	project, err := projectsFor(c, h.projectModel).GetByID(id)
+	if err != nil {
+		respondError(c, err, "Project not found", "Failed to retrieve project")
+		return
+	}
+
+	c.JSON(http.StatusOK, project)
+}
+
+// CreateProject handles POST /projects - creates a new project
+func (h *ProjectHandler) CreateProject(c *gin.Context) {
+	var req models.ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	project, err := projectsFor(c, h.projectModel).Create(req)
+	if err != nil {
+		respondError(c, err, "", "Failed to create project")
+		return
+	}
+
+	c.JSON(http.StatusCreated, project)
+}
+
+// UpdateProject handles PUT /projects/:id - updates an existing project
+func (h *ProjectHandler) UpdateProject(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	project, err := projectsFor(c, h.projectModel).Update(id, req)
+	if err != nil {
+		respondError(c, err, "Project not found", "Failed to update project")
+		return
+	}
+
+	c.JSON(http.StatusOK, project)
+}
+
+// DeleteProject handles DELETE /projects/:id - deletes a project.
+// ?todos=inbox (the default) keeps its todos without a project,
+// ?todos=cascade deletes them too.
+func (h *ProjectHandler) DeleteProject(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	mode := models.ProjectDeleteMode(c.DefaultQuery("todos", string(models.ProjectDeleteInbox)))
+	if mode != models.ProjectDeleteInbox && mode != models.ProjectDeleteCascade {
+		respondProblem(c, http.StatusBadRequest, "todos must be inbox or cascade")
+		return
+	}
+
+	if err := projectsFor(c, h.projectModel).Delete(id, mode); err != nil {
+		respondError(c, err, "Project not found", "Failed to delete project")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
+}
+
+// GetProjectTodos handles GET /projects/:id/todos - lists the todos in a project.
+// It accepts the same query parameters as GET /todos.
+func (h *ProjectHandler) GetProjectTodos(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	if _, err := projectsFor(c, h.projectModel).GetByID(id); err != nil {
+		respondError(c, err, "Project not found", "Failed to retrieve project")
+		return
+	}
+
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+	params.Filter.ProjectID = &id
+	params.Filter.Inbox = false
+
+	respondTodoPage(c, todosFor(c, h.todoModel), params)
+}
+
+// CreateProjectTodo handles POST /projects/:id/todos - creates a todo in a project
+func (h *ProjectHandler) CreateProjectTodo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	var req models.CreateTodoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	req.ProjectID = &id
+
+	todo, err := todosFor(c, h.todoModel).Create(req)
+	if err != nil {
+		// The project comes from the URL, so a missing one means the URL is wrong
+		if errors.Is(err, models.ErrUnknownProject) {
+			respondProblem(c, http.StatusNotFound, "Project not found")
+			return
+		}
+		respondError(c, err, "", "Failed to create todo")
+		return
+	}
+
+	respondTodo(c, http.StatusCreated, todo)
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/umair/go-todo-api/models"
+)
+
+const (
+	// socketPingInterval is how often the server pings a connection
+	socketPingInterval = 30 * time.Second
+	// socketPongWait is how long a connection may go without answering a ping
+	socketPongWait = 2 * socketPingInterval
+	// socketWriteWait is how long a single write may take
+	socketWriteWait = 10 * time.Second
+	// socketMaxMessage is the largest message a client may send, in bytes
+	socketMaxMessage = 64 * 1024
+	// socketSendBuffer is how many messages may wait to be sent to a connection.
+	// A client that falls further behind is disconnected.
+	socketSendBuffer = 256
+)
+
+// Socket message types
+const (
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+	SocketAck         = "ack"
+	SocketEvent       = "event"
+)
+
+// SocketMessage is a message sent by a client over the socket. Its type is
+// subscribe, unsubscribe, or one of the bulk operations: create, update,
+// delete or complete.
+type SocketMessage struct {
+	// ID is chosen by the client and echoed in the acknowledgement
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// TodoIDs and ProjectIDs pick the todos a subscribe or unsubscribe is about
+	TodoIDs    []int `json:"todo_ids"`
+	ProjectIDs []int `json:"project_ids"`
+	// All subscribes to every todo
+	All bool `json:"all"`
+	// TodoID, Version and Todo describe a mutation, as in POST /todos/bulk
+	TodoID  int             `json:"todo_id"`
+	Version int             `json:"version"`
+	Todo    json.RawMessage `json:"todo"`
+}
+
+// SocketReply is a message sent to a client: an acknowledgement of one of its
+// messages, or an event for a todo it subscribed to
+type SocketReply struct {
+	Type   string            `json:"type"`
+	ID     string            `json:"id,omitempty"`
+	Status int               `json:"status,omitempty"`
+	Todo   *models.Todo      `json:"todo,omitempty"`
+	Error  *Problem          `json:"error,omitempty"`
+	Event  *models.TodoEvent `json:"event,omitempty"`
+}
+
+// SocketHandler handles WebSocket connections for live, collaborative updates
+type SocketHandler struct {
+	todoModel models.TodoRepository
+	upgrader  websocket.Upgrader
+}
+
+// NewSocketHandler creates a new SocketHandler instance
+func NewSocketHandler(todoModel models.TodoRepository) *SocketHandler {
+	return &SocketHandler{
+		todoModel: todoModel,
+		upgrader: websocket.Upgrader{
+			// The API allows every origin, as the CORS headers do
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// subscription is the set of todos a connection receives events for
+type subscription struct {
+	mu       sync.Mutex
+	all      bool
+	todos    map[int]bool
+	projects map[int]bool
+}
+
+// update adds or removes todos and projects from the subscription
+func (s *subscription) update(msg SocketMessage, subscribe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.All {
+		s.all = subscribe
+	}
+	for _, id := range msg.TodoIDs {
+		s.todos[id] = subscribe
+	}
+	for _, id := range msg.ProjectIDs {
+		s.projects[id] = subscribe
+	}
+}
+
+// matches reports whether an event is about a todo in the subscription
+func (s *subscription) matches(event models.TodoEvent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.all || s.todos[event.TodoID] {
+		return true
+	}
+	return event.Todo != nil && event.Todo.ProjectID != nil && s.projects[*event.Todo.ProjectID]
+}
+
+// socketConn is one client connection. Every write goes through send, so
+// only the writer goroutine touches the socket.
+type socketConn struct {
+	ws   *websocket.Conn
+	send chan SocketReply
+	// done is closed when the connection is shutting down
+	done      chan struct{}
+	closeOnce sync.Once
+	// closeCode is sent to the client in the close frame
+	closeCode   int
+	closeReason string
+}
+
+// enqueue queues a reply without blocking. A client that is too far behind
+// to take it is disconnected, so it cannot hold up the rest of the server.
+func (conn *socketConn) enqueue(reply SocketReply) {
+	select {
+	case conn.send <- reply:
+	case <-conn.done:
+	default:
+		conn.close(websocket.CloseTryAgainLater, "client is not keeping up")
+	}
+}
+
+// close shuts the connection down, telling the client why
+func (conn *socketConn) close(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeCode = code
+		conn.closeReason = reason
+		close(conn.done)
+	})
+}
+
+// Connect handles GET /ws - upgrades to a WebSocket. Clients subscribe to
+// todos or projects to receive their change events, and may send the same
+// create, update, delete and complete operations as POST /todos/bulk, each of
+// which is acknowledged with its status.
+func (h *SocketHandler) Connect(c *gin.Context) {
+	ws, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written an error response
+		return
+	}
+
+	conn := &socketConn{
+		ws:        ws,
+		send:      make(chan SocketReply, socketSendBuffer),
+		done:      make(chan struct{}),
+		closeCode: websocket.CloseNormalClosure,
+	}
+	sub := &subscription{todos: map[int]bool{}, projects: map[int]bool{}}
+
+	events, unsubscribe := todosFor(c, h.todoModel).Subscribe(socketSendBuffer)
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn.writeLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		pumpEvents(conn, sub, events)
+	}()
+
+	h.readLoop(c, conn, sub)
+	conn.close(websocket.CloseNormalClosure, "")
+	wg.Wait()
+	ws.Close()
+}
+
+// readLoop handles the client's messages one at a time until the connection closes
+func (h *SocketHandler) readLoop(c *gin.Context, conn *socketConn, sub *subscription) {
+	conn.ws.SetReadLimit(socketMaxMessage)
+	conn.ws.SetReadDeadline(time.Now().Add(socketPongWait))
+	conn.ws.SetPongHandler(func(string) error {
+		return conn.ws.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		_, data, err := conn.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read failed: %v", err)
+			}
+			return
+		}
+
+		select {
+		case <-conn.done:
+			return
+		default:
+		}
+
+		var msg SocketMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			problem := bindErrorProblem(c, err)
+			conn.enqueue(SocketReply{Type: SocketAck, Status: problem.Status, Error: &problem})
+			continue
+		}
+		conn.enqueue(h.handleMessage(c, sub, msg))
+	}
+}
+
+// handleMessage applies one client message and returns its acknowledgement
+func (h *SocketHandler) handleMessage(c *gin.Context, sub *subscription, msg SocketMessage) SocketReply {
+	ack := SocketReply{Type: SocketAck, ID: msg.ID}
+
+	switch msg.Type {
+	case SocketSubscribe, SocketUnsubscribe:
+		sub.update(msg, msg.Type == SocketSubscribe)
+		ack.Status = http.StatusOK
+		return ack
+	}
+
+	if principal := CurrentPrincipal(c); principal != nil && !principal.HasScope(models.ScopeTodosWrite) {
+		ack.Status = http.StatusForbidden
+		problem := newProblem(c, http.StatusForbidden, "API key lacks the todos:write scope")
+		ack.Error = &problem
+		return ack
+	}
+
+	// Mutations are validated and run exactly like an operation of a bulk request
+	op, problem := parseBulkOperation(c, BulkOperationRequest{
+		Op:      models.BulkOp(msg.Type),
+		ID:      msg.TodoID,
+		Version: msg.Version,
+		Todo:    msg.Todo,
+	})
+	if problem == nil {
+		results, _, err := todosFor(c, h.todoModel).Bulk([]models.BulkOperation{op}, true)
+		if err == nil {
+			err = results[0].Err
+		}
+		if err == nil {
+			ack.Status = http.StatusOK
+			if op.Op == models.BulkCreate {
+				ack.Status = http.StatusCreated
+			}
+			ack.Todo = results[0].Todo
+			return ack
+		}
+		p := errorProblem(c, err, "Todo not found", "Failed to run operation")
+		problem = &p
+	}
+
+	// The message type is named "type" on the socket, not "op"
+	for i := range problem.InvalidParams {
+		if problem.InvalidParams[i].Name == "op" {
+			problem.InvalidParams[i] = InvalidParam{
+				Name:   "type",
+				Reason: "must be one of subscribe, unsubscribe, create, update, delete, complete",
+			}
+		} else if problem.InvalidParams[i].Name == "id" {
+			problem.InvalidParams[i].Name = "todo_id"
+		}
+	}
+	ack.Status = problem.Status
+	ack.Error = problem
+	return ack
+}
+
+// pumpEvents queues the events the connection subscribed to
+func pumpEvents(conn *socketConn, sub *subscription, events <-chan models.TodoEvent) {
+	for {
+		select {
+		case <-conn.done:
+			return
+		case event, ok := <-events:
+			if !ok {
+				// The broker dropped the connection for falling behind
+				conn.close(websocket.CloseTryAgainLater, "client is not keeping up")
+				return
+			}
+			if sub.matches(event) {
+				event := event
+				conn.enqueue(SocketReply{Type: SocketEvent, Event: &event})
+			}
+		}
+	}
+}
+
+// writeLoop sends queued replies and heartbeat pings until the connection
+// closes, then sends the close frame
+func (conn *socketConn) writeLoop() {
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	// Unblock the read loop, which may be waiting on the client
+	defer conn.ws.SetReadDeadline(time.Now())
+
+	for {
+		select {
+		case reply := <-conn.send:
+			conn.ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := conn.ws.WriteJSON(reply); err != nil {
+				conn.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(socketWriteWait)
+			if err := conn.ws.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				conn.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-conn.done:
+			message := websocket.FormatCloseMessage(conn.closeCode, conn.closeReason)
+			conn.ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteWait))
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TagHandler handles HTTP requests for tag operations
+type TagHandler struct {
+	tagModel *models.TagModel
+}
+
+// NewTagHandler creates a new TagHandler instance
+func NewTagHandler(tagModel *models.TagModel) *TagHandler {
+	return &TagHandler{
+		tagModel: tagModel,
+	}
+}
+
+// GetTags handles GET /tags - retrieves all tags
+func (h *TagHandler) GetTags(c *gin.Context) {
+	tags, err := tagsFor(c, h.tagModel).GetAll()
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+// GetTag handles GET /tags/:id - retrieves a specific tag
+func (h *TagHandler) GetTag(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid tag ID")
+		return
+	}
+
+	tag, err := tagsFor(c, h.tagModel).GetByID(id)
+	if err != nil {
+		respondError(c, err, "Tag not found", "Failed to retrieve tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// CreateTag handles POST /tags - creates a new tag
+func (h *TagHandler) CreateTag(c *gin.Context) {
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	tag, err := tagsFor(c, h.tagModel).Create(req)
+	if err != nil {
+		respondError(c, err, "Tag not found", "Failed to create tag")
+		return
+	}
+
+	c.JSON(http.StatusCreated, tag)
+}
+
+// RenameTag handles PUT /tags/:id - renames a tag
+func (h *TagHandler) RenameTag(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid tag ID")
+		return
+	}
+
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	tag, err := tagsFor(c, h.tagModel).Rename(id, req)
+	if err != nil {
+		respondError(c, err, "Tag not found", "Failed to rename tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
+
+// DeleteTag handles DELETE /tags/:id - deletes a tag and removes it from every todo
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid tag ID")
+		return
+	}
+
+	if err := tagsFor(c, h.tagModel).Delete(id); err != nil {
+		respondError(c, err, "Tag not found", "Failed to delete tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
+// MergeTag handles POST /tags/:id/merge - moves every todo onto the target tag
+// and deletes this one
+func (h *TagHandler) MergeTag(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid tag ID")
+		return
+	}
+
+	var req models.MergeTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	tag, err := tagsFor(c, h.tagModel).Merge(id, req.TargetID)
+	if err != nil {
+		respondError(c, err, "Tag not found", "Failed to merge tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, tag)
+}
@@ -1,147 +1,560 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/umair/go-todo-api/models"
 )
 
 // TodoHandler handles HTTP requests for todo operations
 type TodoHandler struct {
-	todoModel *models.TodoModel
+	todoModel models.TodoRepository
 }
 
 // NewTodoHandler creates a new TodoHandler instance
-func NewTodoHandler(todoModel *models.TodoModel) *TodoHandler {
+func NewTodoHandler(todoModel models.TodoRepository) *TodoHandler {
 	return &TodoHandler{
 		todoModel: todoModel,
 	}
 }
 
-// GetTodos handles GET /todos - retrieves all todos
+// ListTodosQuery represents the query parameters accepted by GET /todos
+type ListTodosQuery struct {
+	Limit         int       `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor        string    `form:"cursor"`
+	Count         bool      `form:"count,default=true"`
+	Completed     *bool     `form:"completed"`
+	CreatedAfter  time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	UpdatedAfter  time.Time `form:"updated_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	UpdatedBefore time.Time `form:"updated_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	DueAfter      time.Time `form:"due_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	DueBefore     time.Time `form:"due_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Tag           []string  `form:"tag"`
+	TagMode       string    `form:"tag_mode" binding:"omitempty,oneof=any all"`
+	ProjectID     string    `form:"project_id"`
+	ParentID      string    `form:"parent_id"`
+	Q             string    `form:"q"`
+	Sort          string    `form:"sort"`
+}
+
+// GetTodos handles GET /todos - retrieves a filtered, sorted page of todos
 func (h *TodoHandler) GetTodos(c *gin.Context) {
-	todos, err := h.todoModel.GetAll()
+	params, ok := bindListParams(c)
+	if !ok {
+		return
+	}
+
+	respondTodoPage(c, todosFor(c, h.todoModel), params)
+}
+
+// bindListParams reads the list query parameters shared by every todo list
+// endpoint. It writes a 400 response and returns false if they are invalid.
+func bindListParams(c *gin.Context) (models.ListParams, bool) {
+	unknown := unknownQueryParam(c,
+		"limit", "cursor", "count", "completed", "created_after", "created_before",
+		"updated_after", "updated_before", "due_after", "due_before", "tag", "tag_mode",
+		"project_id", "parent_id", "q", "sort",
+	)
+	if unknown != "" {
+		respondProblem(c, http.StatusBadRequest, "Unknown query parameter: "+unknown, InvalidParam{
+			Name:   unknown,
+			Reason: "is not a known query parameter",
+		})
+		return models.ListParams{}, false
+	}
+
+	var query ListTodosQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindError(c, err)
+		return models.ListParams{}, false
+	}
+
+	sort, err := models.ParseSort(query.Sort)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve todos"})
+		respondProblem(c, http.StatusBadRequest, err.Error())
+		return models.ListParams{}, false
+	}
+
+	filter := models.TodoFilter{
+		Completed:     query.Completed,
+		CreatedAfter:  query.CreatedAfter,
+		CreatedBefore: query.CreatedBefore,
+		UpdatedAfter:  query.UpdatedAfter,
+		UpdatedBefore: query.UpdatedBefore,
+		DueAfter:      query.DueAfter,
+		DueBefore:     query.DueBefore,
+		Tags:          splitCommaList(query.Tag),
+		TagMode:       models.TagMode(query.TagMode),
+		Search:        query.Q,
+	}
+
+	// project_id is either a project ID or "none" for todos in the inbox
+	switch query.ProjectID {
+	case "":
+	case "none":
+		filter.Inbox = true
+	default:
+		projectID, err := strconv.Atoi(query.ProjectID)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, "Invalid project ID")
+			return models.ListParams{}, false
+		}
+		filter.ProjectID = &projectID
+	}
+
+	// parent_id is either a todo ID or "none" for todos that are not subtasks
+	switch query.ParentID {
+	case "":
+	case "none":
+		filter.TopLevel = true
+	default:
+		parentID, err := strconv.Atoi(query.ParentID)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, "Invalid parent ID")
+			return models.ListParams{}, false
+		}
+		filter.ParentID = &parentID
+	}
+
+	return models.ListParams{
+		Limit:        query.Limit,
+		Cursor:       query.Cursor,
+		IncludeTotal: query.Count,
+		Sort:         sort,
+		Filter:       filter,
+	}, true
+}
+
+// respondTodoPage lists a page of todos and writes it, with a Link header
+// pointing at the next page when there is one
+func respondTodoPage(c *gin.Context, todoModel models.TodoRepository, params models.ListParams) {
+	page, err := todoModel.List(params)
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve todos")
 		return
 	}
 
-	c.JSON(http.StatusOK, todos)
+	if page.NextCursor != "" {
+		c.Header("Link", `<`+nextPageURL(c.Request.URL, page.NextCursor)+`>; rel="next"`)
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// splitCommaList flattens repeated and comma separated query values into one list
+func splitCommaList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// unknownQueryParam returns the first query parameter not in allowed, or ""
+func unknownQueryParam(c *gin.Context, allowed ...string) string {
+	for name := range c.Request.URL.Query() {
+		known := false
+		for _, a := range allowed {
+			if name == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return name
+		}
+	}
+	return ""
 }
 
-// GetTodo handles GET /todos/:id - retrieves a specific todo
+// DueViewQuery represents the query parameters accepted by the due date views
+type DueViewQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string `form:"cursor"`
+	// TZ is the caller's IANA timezone, used to work out where days begin
+	TZ string `form:"tz" binding:"omitempty,timezone"`
+	// Days is only used by the upcoming view
+	Days int `form:"days,default=7" binding:"min=1,max=365"`
+}
+
+// GetOverdueTodos handles GET /todos/overdue - open todos whose due date has passed
+func (h *TodoHandler) GetOverdueTodos(c *gin.Context) {
+	h.listDueWindow(c, func(now, _ time.Time, _ int) (time.Time, time.Time) {
+		return time.Time{}, now
+	})
+}
+
+// GetTodayTodos handles GET /todos/today - open todos due today in the caller's timezone
+func (h *TodoHandler) GetTodayTodos(c *gin.Context) {
+	h.listDueWindow(c, func(_, today time.Time, _ int) (time.Time, time.Time) {
+		return today, today.AddDate(0, 0, 1)
+	})
+}
+
+// GetUpcomingTodos handles GET /todos/upcoming?days=N - open todos due from now
+// until the end of the Nth day after today in the caller's timezone
+func (h *TodoHandler) GetUpcomingTodos(c *gin.Context) {
+	h.listDueWindow(c, func(now, today time.Time, days int) (time.Time, time.Time) {
+		return now, today.AddDate(0, 0, days+1)
+	})
+}
+
+// listDueWindow lists open todos due within the window computed by the given
+// function from the current time and the start of today in the caller's timezone
+func (h *TodoHandler) listDueWindow(c *gin.Context, window func(now, today time.Time, days int) (time.Time, time.Time)) {
+	var query DueViewQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	loc := time.UTC
+	if query.TZ != "" {
+		loc, _ = time.LoadLocation(query.TZ)
+	}
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from, to := window(now, today, query.Days)
+
+	completed := false
+	respondTodoPage(c, todosFor(c, h.todoModel), models.ListParams{
+		Limit:        query.Limit,
+		Cursor:       query.Cursor,
+		IncludeTotal: true,
+		Sort:         []models.SortField{{Field: "due_at"}},
+		Filter: models.TodoFilter{
+			Completed: &completed,
+			DueAfter:  from,
+			DueBefore: to,
+		},
+	})
+}
+
+// nextPageURL returns the request URL with its cursor replaced by the given one
+func nextPageURL(current *url.URL, cursor string) string {
+	next := *current
+	values := next.Query()
+	values.Set("cursor", cursor)
+	next.RawQuery = values.Encode()
+	return next.RequestURI()
+}
+
+// SearchTodosQuery represents the query parameters accepted by GET /todos/search
+type SearchTodosQuery struct {
+	Q     string `form:"q" binding:"required"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// SearchTodos handles GET /todos/search - full-text search ranked by relevance
+func (h *TodoHandler) SearchTodos(c *gin.Context) {
+	var query SearchTodosQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	results, err := todosFor(c, h.todoModel).Search(query.Q, query.Limit)
+	if err != nil {
+		if errors.Is(err, models.ErrSearchUnavailable) {
+			respondProblem(c, http.StatusNotImplemented, "Full-text search is not available")
+			return
+		}
+		respondError(c, err, "", "Failed to search todos")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// GetTodo handles GET /todos/:id - retrieves a specific todo. A matching
+// If-None-Match header gets a 304 Not Modified without a body.
 func (h *TodoHandler) GetTodo(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
 		return
 	}
 
-	todo, err := h.todoModel.GetByID(id)
+	todo, err := todosFor(c, h.todoModel).GetByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		respondError(c, err, "Todo not found", "Failed to retrieve todo")
 		return
 	}
 
-	c.JSON(http.StatusOK, todo)
+	if header := c.GetHeader("If-None-Match"); header != "" && etagListMatches(header, todoETag(todo), true) {
+		c.Header("ETag", todoETag(todo))
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	respondTodo(c, http.StatusOK, todo)
+}
+
+// GetTodoChildren handles GET /todos/:id/children - retrieves a todo's subtasks.
+// With ?nested=true the whole subtree is returned, nested through "children".
+func (h *TodoHandler) GetTodoChildren(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	if _, err := todosFor(c, h.todoModel).GetByID(id); err != nil {
+		respondError(c, err, "Todo not found", "Failed to retrieve todo")
+		return
+	}
+
+	var children []*models.Todo
+	if nested, _ := strconv.ParseBool(c.Query("nested")); nested {
+		children, err = todosFor(c, h.todoModel).GetTree(id)
+	} else {
+		children, err = todosFor(c, h.todoModel).GetChildren(id)
+	}
+	if err != nil {
+		respondError(c, err, "Todo not found", "Failed to retrieve subtasks")
+		return
+	}
+
+	c.JSON(http.StatusOK, children)
+}
+
+// OccurrencesQuery represents the query parameters accepted by GetTodoOccurrences
+type OccurrencesQuery struct {
+	Count int `form:"count,default=5" binding:"min=1,max=100"`
+}
+
+// GetTodoOccurrences handles GET /todos/:id/occurrences - previews the due dates
+// of a recurring todo, starting with its current one
+func (h *TodoHandler) GetTodoOccurrences(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	var query OccurrencesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	occurrences, err := todosFor(c, h.todoModel).PreviewOccurrences(id, query.Count)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRecurrence) {
+			respondProblem(c, http.StatusBadRequest, "Todo does not recur")
+			return
+		}
+		respondError(c, err, "Todo not found", "Failed to preview occurrences")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"occurrences": occurrences})
 }
 
 // CreateTodo handles POST /todos - creates a new todo
 func (h *TodoHandler) CreateTodo(c *gin.Context) {
 	var req models.CreateTodoRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		respondBindError(c, err)
 		return
 	}
 
-	todo, err := h.todoModel.Create(req)
+	todo, err := todosFor(c, h.todoModel).Create(req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create todo"})
+		respondError(c, err, "", "Failed to create todo")
 		return
 	}
 
-	c.JSON(http.StatusCreated, todo)
+	respondTodo(c, http.StatusCreated, todo)
 }
 
-// UpdateTodo handles PUT /todos/:id - updates an existing todo
+// UpdateTodo handles PUT /todos/:id - updates an existing todo.
+// An If-Match header that no longer matches fails with 412.
 func (h *TodoHandler) UpdateTodo(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
 		return
 	}
 
 	var req models.UpdateTodoRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		respondBindError(c, err)
+		return
+	}
+
+	version, ok := checkIfMatch(c, todosFor(c, h.todoModel), id)
+	if !ok {
 		return
 	}
 
-	todo, err := h.todoModel.Update(id, req)
+	todo, err := todosFor(c, h.todoModel).Update(id, req, version)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		respondError(c, err, "Todo not found", "Failed to update todo")
 		return
 	}
 
-	c.JSON(http.StatusOK, todo)
+	respondTodo(c, http.StatusOK, todo)
 }
 
-// DeleteTodo handles DELETE /todos/:id - deletes a todo
-func (h *TodoHandler) DeleteTodo(c *gin.Context) {
+// PatchTodo handles PATCH /todos/:id - partially updates a todo with an
+// application/merge-patch+json or application/json-patch+json body. The
+// patch is applied to the todo as a whole, so a failed patch changes nothing.
+// An If-Match header that no longer matches fails with 412.
+func (h *TodoHandler) PatchTodo(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	patchType := c.ContentType()
+	if patchType != models.MergePatchType && patchType != models.JSONPatchType {
+		respondProblem(c, http.StatusUnsupportedMediaType,
+			"Content-Type must be "+models.MergePatchType+" or "+models.JSONPatchType)
+		return
+	}
+
+	patch, err := c.GetRawData()
+	if err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	current, err := todosFor(c, h.todoModel).GetByID(id)
+	if err != nil {
+		respondError(c, err, "Todo not found", "Failed to retrieve todo")
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch != "" && !etagListMatches(ifMatch, todoETag(current), false) {
+		respondStaleVersion(c)
+		return
+	}
+
+	req, err := models.ApplyPatch(current, patchType, patch)
+	if err != nil {
+		respondError(c, err, "", "Failed to apply patch")
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		respondBindError(c, err)
 		return
 	}
 
-	// Check if todo exists before deleting
-	_, err = h.todoModel.GetByID(id)
+	// The patch was applied to the version just read, so it must still be current
+	todo, err := todosFor(c, h.todoModel).Update(id, req, current.Version)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		if errors.Is(err, models.ErrVersionMismatch) && ifMatch == "" {
+			respondProblem(c, http.StatusConflict, "Todo was modified while it was being patched")
+			return
+		}
+		respondError(c, err, "Todo not found", "Failed to update todo")
 		return
 	}
 
-	err = h.todoModel.Delete(id)
+	respondTodo(c, http.StatusOK, todo)
+}
+
+// DeleteTodo handles DELETE /todos/:id - deletes a todo.
+// An If-Match header that no longer matches fails with 412.
+func (h *TodoHandler) DeleteTodo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete todo"})
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	version, ok := checkIfMatch(c, todosFor(c, h.todoModel), id)
+	if !ok {
+		return
+	}
+
+	if err := todosFor(c, h.todoModel).Delete(id, version); err != nil {
+		respondError(c, err, "Todo not found", "Failed to delete todo")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Todo deleted successfully"})
 }
 
-// CompleteTodo handles PATCH /todos/:id/complete - marks a todo as completed
+// CompleteTodo handles PATCH /todos/:id/complete - marks a todo as completed.
+// ?children=cascade also completes its subtasks, ?children=refuse fails while
+// any subtask is open, and ?children=ignore (the default) leaves them alone.
 func (h *TodoHandler) CompleteTodo(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
 		return
 	}
 
-	todo, err := h.todoModel.ToggleComplete(id, true)
+	policy := models.ChildPolicy(c.DefaultQuery("children", string(models.ChildPolicyIgnore)))
+	switch policy {
+	case models.ChildPolicyIgnore, models.ChildPolicyCascade, models.ChildPolicyRefuse:
+	default:
+		respondProblem(c, http.StatusBadRequest, "children must be ignore, cascade or refuse")
+		return
+	}
+
+	todo, err := todosFor(c, h.todoModel).ToggleComplete(id, true, policy)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		respondError(c, err, "Todo not found", "Failed to complete todo")
 		return
 	}
 
-	c.JSON(http.StatusOK, todo)
+	respondTodo(c, http.StatusOK, todo)
 }
 
 // UncompleteTodo handles PATCH /todos/:id/uncomplete - marks a todo as incomplete
 func (h *TodoHandler) UncompleteTodo(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid todo ID"})
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	todo, err := todosFor(c, h.todoModel).ToggleComplete(id, false, models.ChildPolicyIgnore)
+	if err != nil {
+		respondError(c, err, "Todo not found", "Failed to uncomplete todo")
+		return
+	}
+
+	respondTodo(c, http.StatusOK, todo)
+}
+
+// SetTodoPriority handles PATCH /todos/:id/priority - changes a todo's priority
+func (h *TodoHandler) SetTodoPriority(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	var req models.SetPriorityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
 		return
 	}
 
-	todo, err := h.todoModel.ToggleComplete(id, false)
+	todo, err := todosFor(c, h.todoModel).SetPriority(id, *req.Priority)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		respondError(c, err, "Todo not found", "Failed to set priority")
 		return
 	}
 
-	c.JSON(http.StatusOK, todo)
+	respondTodo(c, http.StatusOK, todo)
 }
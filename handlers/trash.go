@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TrashHandler handles HTTP requests for trashed todos
+type TrashHandler struct {
+	todoModel models.TodoRepository
+	retention time.Duration
+}
+
+// NewTrashHandler creates a new TrashHandler instance. Trashed todos older
+// than retention are removed when the trash is purged.
+func NewTrashHandler(todoModel models.TodoRepository, retention time.Duration) *TrashHandler {
+	return &TrashHandler{
+		todoModel: todoModel,
+		retention: retention,
+	}
+}
+
+// TrashQuery represents the query parameters accepted by GET /trash
+type TrashQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string `form:"cursor"`
+}
+
+// GetTrash handles GET /trash - lists trashed todos, most recently deleted first
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	var query TrashQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	sort, _ := models.ParseSort("-deleted_at")
+	respondTodoPage(c, todosFor(c, h.todoModel), models.ListParams{
+		Limit:        query.Limit,
+		Cursor:       query.Cursor,
+		IncludeTotal: true,
+		Sort:         sort,
+		Filter:       models.TodoFilter{Trashed: true},
+	})
+}
+
+// PurgeTrash handles DELETE /trash - permanently deletes todos that have been
+// in the trash for longer than the retention period, or every trashed todo
+// with ?all=true
+func (h *TrashHandler) PurgeTrash(c *gin.Context) {
+	before := time.Now().Add(-h.retention)
+	if all, _ := strconv.ParseBool(c.Query("all")); all {
+		before = time.Now()
+	}
+
+	purged, err := todosFor(c, h.todoModel).Purge(before)
+	if err != nil {
+		respondError(c, err, "", "Failed to purge trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// RestoreTodo handles POST /todos/:id/restore - takes a todo out of the trash
+func (h *TrashHandler) RestoreTodo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid todo ID")
+		return
+	}
+
+	todo, err := todosFor(c, h.todoModel).Restore(id)
+	if err != nil {
+		respondError(c, err, "Todo not found in trash", "Failed to restore todo")
+		return
+	}
+
+	respondTodo(c, http.StatusOK, todo)
+}
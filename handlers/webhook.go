@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// WebhookHandler handles HTTP requests for webhooks and their deliveries
+type WebhookHandler struct {
+	webhookModel *models.WebhookModel
+}
+
+// NewWebhookHandler creates a new WebhookHandler instance
+func NewWebhookHandler(webhookModel *models.WebhookModel) *WebhookHandler {
+	return &WebhookHandler{
+		webhookModel: webhookModel,
+	}
+}
+
+// DeliveriesQuery represents the query parameters accepted by GET /webhooks/:id/deliveries
+type DeliveriesQuery struct {
+	Status models.DeliveryStatus `form:"status" binding:"omitempty,oneof=pending succeeded failed"`
+	Limit  int                   `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// GetWebhooks handles GET /webhooks - retrieves all webhooks
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	webhooks, err := webhooksFor(c, h.webhookModel).GetAll()
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve webhooks")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// GetWebhook handles GET /webhooks/:id - retrieves a specific webhook
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	webhook, err := webhooksFor(c, h.webhookModel).GetByID(id)
+	if err != nil {
+		respondError(c, err, "Webhook not found", "Failed to retrieve webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// CreateWebhook handles POST /webhooks - creates a new webhook. The response
+// is the only one that includes the webhook's secret.
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	webhook, err := webhooksFor(c, h.webhookModel).Create(req)
+	if err != nil {
+		respondError(c, err, "", "Failed to create webhook")
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// UpdateWebhook handles PUT /webhooks/:id - updates an existing webhook
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	webhook, err := webhooksFor(c, h.webhookModel).Update(id, req)
+	if err != nil {
+		respondError(c, err, "Webhook not found", "Failed to update webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook handles DELETE /webhooks/:id - deletes a webhook and its deliveries
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := webhooksFor(c, h.webhookModel).Delete(id); err != nil {
+		respondError(c, err, "Webhook not found", "Failed to delete webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// GetDeliveries handles GET /webhooks/:id/deliveries - lists a webhook's
+// deliveries, newest first, optionally filtered by ?status=
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var query DeliveriesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	deliveries, err := webhooksFor(c, h.webhookModel).Deliveries(id, query.Status, query.Limit)
+	if err != nil {
+		respondError(c, err, "Webhook not found", "Failed to retrieve deliveries")
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// GetDelivery handles GET /webhooks/:id/deliveries/:delivery_id - retrieves a specific delivery
+func (h *WebhookHandler) GetDelivery(c *gin.Context) {
+	id, deliveryID, ok := parseDeliveryParams(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := webhooksFor(c, h.webhookModel).GetDelivery(id, deliveryID)
+	if err != nil {
+		respondError(c, err, "Delivery not found", "Failed to retrieve delivery")
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// ReplayDelivery handles POST /webhooks/:id/deliveries/:delivery_id/replay -
+// queues the delivery's event to be sent again. The original delivery is left
+// as it was and a new one is returned.
+func (h *WebhookHandler) ReplayDelivery(c *gin.Context) {
+	id, deliveryID, ok := parseDeliveryParams(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := webhooksFor(c, h.webhookModel).Replay(id, deliveryID)
+	if err != nil {
+		respondError(c, err, "Delivery not found", "Failed to replay delivery")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// parseDeliveryParams reads the webhook and delivery IDs from the path. It
+// writes a 400 response and returns false if either is invalid.
+func parseDeliveryParams(c *gin.Context) (int, int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid webhook ID")
+		return 0, 0, false
+	}
+	deliveryID, err := strconv.Atoi(c.Param("delivery_id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid delivery ID")
+		return 0, 0, false
+	}
+	return id, deliveryID, true
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umair/go-todo-api/models"
+)
+
+// workspaceKey is the gin context key holding the *models.Workspace a
+// request works in, along with the user's role in it
+const workspaceKey = "workspace"
+
+// WorkspaceHandler handles HTTP requests for workspaces and their members
+type WorkspaceHandler struct {
+	workspaceModel *models.WorkspaceModel
+}
+
+// NewWorkspaceHandler creates a new WorkspaceHandler instance
+func NewWorkspaceHandler(workspaceModel *models.WorkspaceModel) *WorkspaceHandler {
+	return &WorkspaceHandler{
+		workspaceModel: workspaceModel,
+	}
+}
+
+// Workspace returns middleware that finds the workspace a request works in:
+// the one in the :workspace_id path parameter, or else the user's personal
+// workspace. Workspaces the user is not a member of are not found.
+// Anonymous requests are left to RequireUser.
+func Workspace(workspaceModel *models.WorkspaceModel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := CurrentPrincipal(c)
+		if principal == nil {
+			c.Next()
+			return
+		}
+
+		var workspace *models.Workspace
+		var err error
+		if param := c.Param("workspace_id"); param != "" {
+			id, convErr := strconv.Atoi(param)
+			if convErr != nil {
+				respondProblem(c, http.StatusBadRequest, "Invalid workspace ID")
+				c.Abort()
+				return
+			}
+			workspace, err = workspaceModel.Membership(principal.UserID, id)
+		} else {
+			workspace, err = workspaceModel.Personal(principal.UserID)
+		}
+		if err != nil {
+			respondError(c, err, "Workspace not found", "Failed to retrieve workspace")
+			c.Abort()
+			return
+		}
+
+		c.Set(workspaceKey, workspace)
+		c.Next()
+	}
+}
+
+// RequireRole returns middleware that fails with 403 unless the user's role
+// in the workspace is at least read for GET and HEAD requests, and at least
+// write for the rest. Requests outside a workspace are left alone.
+func RequireRole(read, write models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		min := write
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			min = read
+		}
+
+		if workspace := CurrentWorkspace(c); workspace != nil && !workspace.Role.AtLeast(min) {
+			respondError(c, models.ErrInsufficientRole, "", "")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentWorkspace returns the workspace the request works in, or nil
+func CurrentWorkspace(c *gin.Context) *models.Workspace {
+	if workspace, ok := c.Get(workspaceKey); ok {
+		return workspace.(*models.Workspace)
+	}
+	return nil
+}
+
+// todosFor returns the todos the request may see: those of its workspace,
+// guarded by the user's role there, or none when the route is not behind
+// Workspace
+func todosFor(c *gin.Context, todoModel models.TodoRepository) models.TodoRepository {
+	if workspace := CurrentWorkspace(c); workspace != nil {
+		return models.NewRoleGuard(todoModel.ForWorkspace(workspace.ID), workspace.Role)
+	}
+	return todoModel.ForWorkspace(0)
+}
+
+// projectsFor returns the projects the request may see, as todosFor does
+func projectsFor(c *gin.Context, projectModel *models.ProjectModel) *models.ProjectModel {
+	if workspace := CurrentWorkspace(c); workspace != nil {
+		return projectModel.ForWorkspace(workspace.ID)
+	}
+	return projectModel.ForWorkspace(0)
+}
+
+// tagsFor returns the tags the request may see, as todosFor does
+func tagsFor(c *gin.Context, tagModel *models.TagModel) *models.TagModel {
+	if workspace := CurrentWorkspace(c); workspace != nil {
+		return tagModel.ForWorkspace(workspace.ID)
+	}
+	return tagModel.ForWorkspace(0)
+}
+
+// webhooksFor returns the webhooks the request may see, as todosFor does
+func webhooksFor(c *gin.Context, webhookModel *models.WebhookModel) *models.WebhookModel {
+	if workspace := CurrentWorkspace(c); workspace != nil {
+		return webhookModel.ForWorkspace(workspace.ID)
+	}
+	return webhookModel.ForWorkspace(0)
+}
+
+// GetWorkspaces handles GET /workspaces - lists the user's workspaces, with
+// their role in each
+func (h *WorkspaceHandler) GetWorkspaces(c *gin.Context) {
+	workspaces, err := h.workspaceModel.ListForUser(CurrentPrincipal(c).UserID)
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve workspaces")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+// CreateWorkspace handles POST /workspaces - creates a shared workspace owned
+// by the user
+func (h *WorkspaceHandler) CreateWorkspace(c *gin.Context) {
+	var req models.WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	workspace, err := h.workspaceModel.Create(CurrentPrincipal(c).UserID, req)
+	if err != nil {
+		respondError(c, err, "", "Failed to create workspace")
+		return
+	}
+
+	c.JSON(http.StatusCreated, workspace)
+}
+
+// GetWorkspace handles GET /workspaces/:workspace_id - retrieves a workspace
+func (h *WorkspaceHandler) GetWorkspace(c *gin.Context) {
+	c.JSON(http.StatusOK, CurrentWorkspace(c))
+}
+
+// RenameWorkspace handles PUT /workspaces/:workspace_id - renames a workspace
+func (h *WorkspaceHandler) RenameWorkspace(c *gin.Context) {
+	var req models.WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	workspace, err := h.workspaceModel.Rename(CurrentWorkspace(c), req)
+	if err != nil {
+		respondError(c, err, "Workspace not found", "Failed to rename workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// DeleteWorkspace handles DELETE /workspaces/:workspace_id - deletes a shared
+// workspace along with everything in it
+func (h *WorkspaceHandler) DeleteWorkspace(c *gin.Context) {
+	if err := h.workspaceModel.Delete(CurrentWorkspace(c)); err != nil {
+		respondError(c, err, "Workspace not found", "Failed to delete workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted successfully"})
+}
+
+// GetMembers handles GET /workspaces/:workspace_id/members - lists the
+// members of a workspace
+func (h *WorkspaceHandler) GetMembers(c *gin.Context) {
+	members, err := h.workspaceModel.Members(CurrentWorkspace(c).ID)
+	if err != nil {
+		respondError(c, err, "", "Failed to retrieve members")
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// AddMember handles POST /workspaces/:workspace_id/members - adds a user to a
+// workspace by their email address
+func (h *WorkspaceHandler) AddMember(c *gin.Context) {
+	var req models.AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	member, err := h.workspaceModel.AddMember(CurrentWorkspace(c), req)
+	if err != nil {
+		respondError(c, err, "", "Failed to add member")
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember handles PUT /workspaces/:workspace_id/members/:user_id -
+// changes a member's role
+func (h *WorkspaceHandler) UpdateMember(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req models.MemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if err := h.workspaceModel.UpdateMember(CurrentWorkspace(c), userID, req); err != nil {
+		respondError(c, err, "Member not found", "Failed to update member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+}
+
+// RemoveMember handles DELETE /workspaces/:workspace_id/members/:user_id -
+// takes a user out of a workspace, or lets them leave it
+func (h *WorkspaceHandler) RemoveMember(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		respondProblem(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.workspaceModel.RemoveMember(CurrentWorkspace(c), CurrentPrincipal(c).UserID, userID); err != nil {
+		respondError(c, err, "Member not found", "Failed to remove member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the timezone database so due date timezones resolve on any host
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"github.com/umair/go-todo-api/database"
@@ -11,21 +19,87 @@ import (
 )
 
 func main() {
-	// Initialize database
-	dbPath := "todo.db"
-	if os.Getenv("DB_PATH") != "" {
-		dbPath = os.Getenv("DB_PATH")
+	// "migrate" manages the schema and "claim" adopts rows from before
+	// workspaces, instead of serving the API
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "claim" {
+		runClaim(os.Args[2:])
+		return
 	}
 
-	db, err := database.InitDB(dbPath)
+	db, err := openDatabase(true)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer database.CloseDB(db)
 
+	// TODO_STORAGE picks where todos are kept: "database", the default, or
+	// "memory" for throwaway deployments. Projects, tags and webhooks live in
+	// the database with the todos, so they are only served by the database
+	// backend.
+	storage := os.Getenv("TODO_STORAGE")
+	if storage == "" {
+		storage = "database"
+	}
+
 	// Initialize models and handlers
-	todoModel := models.NewTodoModel(db)
+	var todoModel models.TodoRepository
+	var todoEvents *models.EventBroker
+	switch storage {
+	case "database":
+		todos := models.NewTodoModel(db)
+		todoModel, todoEvents = todos, todos.Events
+	case "memory":
+		todoModel = models.NewMemoryTodoRepository()
+	default:
+		log.Fatal("Invalid TODO_STORAGE: ", storage)
+	}
 	todoHandler := handlers.NewTodoHandler(todoModel)
+	tagModel := models.NewTagModel(db, todoEvents)
+	tagHandler := handlers.NewTagHandler(tagModel)
+	projectModel := models.NewProjectModel(db, todoEvents)
+	projectHandler := handlers.NewProjectHandler(projectModel, todoModel)
+
+	// Trashed todos are kept for TRASH_RETENTION_DAYS before being purged
+	retention := models.DefaultTrashRetention
+	if os.Getenv("TRASH_RETENTION_DAYS") != "" {
+		days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+		if err != nil || days < 0 {
+			log.Fatal("Invalid TRASH_RETENTION_DAYS: ", os.Getenv("TRASH_RETENTION_DAYS"))
+		}
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+	trashHandler := handlers.NewTrashHandler(todoModel, retention)
+	socketHandler := handlers.NewSocketHandler(todoModel)
+	go purgeTrash(todoModel, retention)
+
+	// Users get short lived access tokens, and refresh tokens lasting
+	// DefaultSessionTTL; their todos are kept in workspaces by todoModel
+	tokenIssuer, err := loadTokenIssuer()
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+	userModel := models.NewUserModel(db)
+	authHandler := handlers.NewAuthHandler(userModel, tokenIssuer, models.DefaultSessionTTL)
+	apiKeyModel := models.NewAPIKeyModel(db)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyModel)
+	workspaceModel := models.NewWorkspaceModel(db)
+	workspaceHandler := handlers.NewWorkspaceHandler(workspaceModel)
+	go purgeSessions(userModel)
+
+	// Responses to requests with an Idempotency-Key are kept for a day
+	idempotencyModel := models.NewIdempotencyModel(db)
+	go purgeIdempotencyKeys(idempotencyModel)
+
+	// Todo events are delivered to webhooks from a queue in the database
+	webhookModel := models.NewWebhookModel(db)
+	webhookHandler := handlers.NewWebhookHandler(webhookModel)
+	if storage == "database" {
+		go deliverWebhooks(webhookModel, todoModel)
+	}
 
 	// Set up Gin router
 	router := gin.Default()
@@ -34,7 +108,8 @@ func main() {
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match, Idempotency-Key, Last-Event-ID, X-API-Key")
+		c.Header("Access-Control-Expose-Headers", "ETag, Link, Idempotent-Replayed")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -44,22 +119,146 @@ func main() {
 		c.Next()
 	})
 
-	// API routes
+	// API routes. Idempotency keys are per user, so they are checked once
+	// the user is known. Idempotent responses are stored as they are, so
+	// routes that return secrets are left without them.
 	api := router.Group("/api/v1")
+	api.Use(handlers.Authenticate(tokenIssuer, apiKeyModel))
+	idempotency := handlers.Idempotency(idempotencyModel, models.DefaultIdempotencyTTL)
+
+	// Auth routes. Logging in and refreshing return tokens, so none of
+	// these are idempotent.
+	auth := api.Group("/auth")
 	{
-		// Todo routes
-		todos := api.Group("/todos")
+		auth.POST("/register", authHandler.Register)
+		auth.POST("/login", authHandler.Login)
+		auth.POST("/refresh", authHandler.Refresh)
+		auth.POST("/logout", authHandler.Logout)
+		auth.GET("/me", handlers.RequireUser(), authHandler.Me)
+	}
+
+	// workspaceRoutes registers the routes of a workspace's todos, projects,
+	// tags and webhooks. Each workspace only sees its own, and the user's role
+	// there decides what they may change.
+	workspaceRoutes := func(group *gin.RouterGroup) {
+		// Todo routes. Todos are guarded by role in todosFor, so editing
+		// them takes an editor and emptying the trash an admin.
+		todos := group.Group("/todos", handlers.RequireScope("todos"))
 		{
 			todos.GET("", todoHandler.GetTodos)
+			todos.GET("/search", todoHandler.SearchTodos)
+			todos.GET("/overdue", todoHandler.GetOverdueTodos)
+			todos.GET("/today", todoHandler.GetTodayTodos)
+			todos.GET("/upcoming", todoHandler.GetUpcomingTodos)
+			todos.GET("/events", todoHandler.StreamTodoEvents)
 			todos.GET("/:id", todoHandler.GetTodo)
 			todos.POST("", todoHandler.CreateTodo)
+			todos.POST("/bulk", todoHandler.BulkTodos)
 			todos.PUT("/:id", todoHandler.UpdateTodo)
+			todos.PATCH("/:id", todoHandler.PatchTodo)
 			todos.DELETE("/:id", todoHandler.DeleteTodo)
 			todos.PATCH("/:id/complete", todoHandler.CompleteTodo)
 			todos.PATCH("/:id/uncomplete", todoHandler.UncompleteTodo)
+			todos.PATCH("/:id/priority", todoHandler.SetTodoPriority)
+			todos.GET("/:id/children", todoHandler.GetTodoChildren)
+			todos.GET("/:id/occurrences", todoHandler.GetTodoOccurrences)
+			todos.POST("/:id/restore", trashHandler.RestoreTodo)
+		}
+
+		// WebSocket for live updates and mutations. Mutations also need the
+		// todos:write scope.
+		group.GET("/ws", handlers.RequireScope("todos"), socketHandler.Connect)
+
+		// Trash routes
+		trash := group.Group("/trash", handlers.RequireScope("todos"))
+		{
+			trash.GET("", trashHandler.GetTrash)
+			trash.DELETE("", trashHandler.PurgeTrash)
+		}
+
+		// Projects, tags and webhooks are stored alongside SQLite todos
+		if storage != "database" {
+			return
+		}
+
+		// Tag routes
+		tags := group.Group("/tags", handlers.RequireScope("tags"), handlers.RequireRole(models.RoleViewer, models.RoleEditor))
+		{
+			tags.GET("", tagHandler.GetTags)
+			tags.GET("/:id", tagHandler.GetTag)
+			tags.POST("", tagHandler.CreateTag)
+			tags.PUT("/:id", tagHandler.RenameTag)
+			tags.DELETE("/:id", tagHandler.DeleteTag)
+			tags.POST("/:id/merge", tagHandler.MergeTag)
+		}
+
+		// Webhook routes. Webhooks send every change to a URL of their
+		// choosing, so only admins may see or change them.
+		webhooks := group.Group("/webhooks", handlers.RequireScope("webhooks"), handlers.RequireRole(models.RoleAdmin, models.RoleAdmin))
+		{
+			webhooks.GET("", webhookHandler.GetWebhooks)
+			webhooks.GET("/:id", webhookHandler.GetWebhook)
+			webhooks.POST("", webhookHandler.CreateWebhook)
+			webhooks.PUT("/:id", webhookHandler.UpdateWebhook)
+			webhooks.DELETE("/:id", webhookHandler.DeleteWebhook)
+			webhooks.GET("/:id/deliveries", webhookHandler.GetDeliveries)
+			webhooks.GET("/:id/deliveries/:delivery_id", webhookHandler.GetDelivery)
+			webhooks.POST("/:id/deliveries/:delivery_id/replay", webhookHandler.ReplayDelivery)
+		}
+
+		// Project routes
+		projects := group.Group("/projects", handlers.RequireScope("projects"), handlers.RequireRole(models.RoleViewer, models.RoleEditor))
+		{
+			projects.GET("", projectHandler.GetProjects)
+			projects.GET("/:id", projectHandler.GetProject)
+			projects.POST("", projectHandler.CreateProject)
+			projects.PUT("/:id", projectHandler.UpdateProject)
+			projects.DELETE("/:id", projectHandler.DeleteProject)
+			projects.GET("/:id/todos", handlers.RequireScope("todos"), projectHandler.GetProjectTodos)
+			projects.POST("/:id/todos", handlers.RequireScope("todos"), projectHandler.CreateProjectTodo)
 		}
 	}
 
+	// Every other route needs a logged in user. Requests made with an API
+	// key also need the route's scope.
+	private := api.Group("", handlers.RequireUser())
+	{
+		// API key routes. Keys cannot manage keys, so a leaked key cannot
+		// mint more. New keys are only shown once, so creating one cannot be
+		// replayed.
+		apiKeys := private.Group("/api-keys", handlers.RequireLogin())
+		{
+			apiKeys.GET("", apiKeyHandler.GetAPIKeys)
+			apiKeys.POST("", apiKeyHandler.CreateAPIKey)
+			apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey)
+		}
+
+		// Workspace routes. Like API keys, workspaces and their members are
+		// only managed by logged in users.
+		workspaces := private.Group("/workspaces", idempotency)
+		{
+			workspaces.GET("", handlers.RequireLogin(), workspaceHandler.GetWorkspaces)
+			workspaces.POST("", handlers.RequireLogin(), workspaceHandler.CreateWorkspace)
+		}
+		workspace := workspaces.Group("/:workspace_id", handlers.Workspace(workspaceModel))
+		{
+			manage := workspace.Group("", handlers.RequireLogin())
+			manage.GET("", workspaceHandler.GetWorkspace)
+			manage.PUT("", workspaceHandler.RenameWorkspace)
+			manage.DELETE("", workspaceHandler.DeleteWorkspace)
+			manage.GET("/members", workspaceHandler.GetMembers)
+			manage.POST("/members", workspaceHandler.AddMember)
+			manage.PUT("/members/:user_id", workspaceHandler.UpdateMember)
+			manage.DELETE("/members/:user_id", workspaceHandler.RemoveMember)
+		}
+
+		// The routes below are served for each workspace under
+		// /workspaces/:workspace_id, and for the user's personal workspace
+		// at the top level
+		workspaceRoutes(workspace)
+		workspaceRoutes(private.Group("", idempotency, handlers.Workspace(workspaceModel)))
+	}
+
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -69,14 +268,25 @@ func main() {
 	})
 
 	// Root endpoint
+	endpoints := gin.H{
+		"health":     "/health",
+		"auth":       "/api/v1/auth",
+		"api_keys":   "/api/v1/api-keys",
+		"workspaces": "/api/v1/workspaces",
+		"todos":      "/api/v1/todos",
+		"trash":      "/api/v1/trash",
+		"ws":         "/api/v1/ws",
+	}
+	if storage == "database" {
+		endpoints["tags"] = "/api/v1/tags"
+		endpoints["projects"] = "/api/v1/projects"
+		endpoints["webhooks"] = "/api/v1/webhooks"
+	}
 	router.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
-			"message": "Welcome to Go Todo API",
-			"version": "1.0.0",
-			"endpoints": gin.H{
-				"health": "/health",
-				"todos":  "/api/v1/todos",
-			},
+			"message":   "Welcome to Go Todo API",
+			"version":   "1.0.0",
+			"endpoints": endpoints,
 		})
 	})
 
@@ -94,3 +304,260 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// purgeTrash permanently deletes todos past the trash retention, and events
+// past the event log retention, once at startup and then every hour
+func purgeTrash(todoModel models.TodoRepository, retention time.Duration) {
+	for {
+		purged, err := todoModel.Purge(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("Failed to purge trash:", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d todos from the trash", purged)
+		}
+		if _, err := todoModel.PurgeEvents(time.Now().Add(-models.DefaultEventRetention)); err != nil {
+			log.Println("Failed to purge todo events:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// purgeSessions deletes expired refresh tokens, once at startup and then
+// every hour
+func purgeSessions(userModel *models.UserModel) {
+	for {
+		if _, err := userModel.PurgeSessions(time.Now()); err != nil {
+			log.Println("Failed to purge refresh tokens:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys, once at startup and
+// then every hour
+func purgeIdempotencyKeys(idempotencyModel *models.IdempotencyModel) {
+	for {
+		if _, err := idempotencyModel.Purge(time.Now()); err != nil {
+			log.Println("Failed to purge idempotency keys:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// deliverWebhooks sends due webhook deliveries every few seconds, and as soon
+// as a todo changes. Finished deliveries past their retention are purged once
+// an hour.
+func deliverWebhooks(webhookModel *models.WebhookModel, todoModel models.TodoRepository) {
+	poll := time.NewTicker(5 * time.Second)
+	defer poll.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	events, unsubscribe := todoModel.Subscribe(1)
+	defer func() { unsubscribe() }()
+
+	for {
+		if _, err := webhookModel.DeliverDue(time.Now()); err != nil {
+			log.Println("Failed to deliver webhooks:", err)
+		}
+
+		select {
+		case <-poll.C:
+		case _, ok := <-events:
+			if !ok {
+				// Dropped for falling behind, which only means there is work to do
+				events, unsubscribe = todoModel.Subscribe(1)
+			}
+		case <-purge.C:
+			if _, err := webhookModel.PurgeDeliveries(time.Now().Add(-models.DefaultDeliveryRetention)); err != nil {
+				log.Println("Failed to purge webhook deliveries:", err)
+			}
+		}
+	}
+}
+
+// loadTokenIssuer loads the keys access tokens are signed with. Tokens are
+// signed with the PEM encoded RSA (RS256) or Ed25519 (EdDSA) private key in
+// JWT_PRIVATE_KEY_FILE, or else with the HS256 secret in JWT_SECRET. Tokens
+// signed with retired keys are still accepted until they expire: list their
+// PEM files in JWT_PREVIOUS_KEY_FILES and their secrets in
+// JWT_PREVIOUS_SECRETS, both comma separated. Without any key, a random
+// secret is used and tokens stop working when the server restarts.
+func loadTokenIssuer() (*models.TokenIssuer, error) {
+	var current *models.SigningKey
+	var err error
+	switch {
+	case os.Getenv("JWT_PRIVATE_KEY_FILE") != "":
+		current, err = readKeyFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+	case os.Getenv("JWT_SECRET") != "":
+		current, err = models.NewHMACKey([]byte(os.Getenv("JWT_SECRET")))
+	default:
+		log.Println("JWT_SECRET is not set; access tokens will not survive a restart")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		current, err = models.NewHMACKey(secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []*models.SigningKey
+	for _, path := range splitList(os.Getenv("JWT_PREVIOUS_KEY_FILES")) {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	for _, secret := range splitList(os.Getenv("JWT_PREVIOUS_SECRETS")) {
+		key, err := models.NewHMACKey([]byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return models.NewTokenIssuer(current, previous, models.DefaultAccessTokenTTL)
+}
+
+// readKeyFile reads a PEM encoded key
+func readKeyFile(path string) (*models.SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := models.ParseKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// openDatabase connects to the database picked by DB_DRIVER: "sqlite", the
+// default, at DB_PATH, or "postgres" at DATABASE_URL. With migrate set, the
+// schema is brought up to date as well.
+func openDatabase(migrate bool) (*sql.DB, error) {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite"
+	}
+
+	switch driver {
+	case "sqlite":
+		dbPath := "todo.db"
+		if os.Getenv("DB_PATH") != "" {
+			dbPath = os.Getenv("DB_PATH")
+		}
+		if migrate {
+			return database.InitDB(dbPath)
+		}
+		return database.OpenDB(dbPath)
+	case "postgres":
+		if os.Getenv("DATABASE_URL") == "" {
+			log.Fatal("DATABASE_URL is required with DB_DRIVER=postgres")
+		}
+		if migrate {
+			return database.InitPostgres(os.Getenv("DATABASE_URL"))
+		}
+		return database.OpenPostgres(os.Getenv("DATABASE_URL"))
+	}
+
+	log.Fatal("Invalid DB_DRIVER: ", driver)
+	return nil, nil
+}
+
+// runMigrate implements "migrate status|up|down|to N" against the database
+// picked by DB_DRIVER
+func runMigrate(args []string) {
+	const usage = "Usage: todo-api migrate status|up|down|to N"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	db, err := openDatabase(false)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	defer database.CloseDB(db)
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s  %s\n", status.Version, status.Name, applied)
+		}
+		return
+	case args[0] == "up" && len(args) == 1:
+		err = migrator.Up()
+	case args[0] == "down" && len(args) == 1:
+		err = migrator.Down()
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			log.Fatal("Invalid version: ", args[1])
+		}
+		err = migrator.To(version)
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Database is at version %d\n", version)
+}
+
+// runClaim implements "claim WORKSPACE_ID", which moves the todos, projects,
+// tags and webhooks that belong to no workspace into a workspace
+func runClaim(args []string) {
+	const usage = "Usage: todo-api claim WORKSPACE_ID"
+	if len(args) != 1 {
+		log.Fatal(usage)
+	}
+	workspaceID, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Fatal("Invalid workspace ID: ", args[0])
+	}
+
+	db, err := openDatabase(true)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	defer database.CloseDB(db)
+
+	result, err := models.NewWorkspaceModel(db).ClaimUnowned(workspaceID)
+	if err != nil {
+		log.Fatalf("Failed to claim rows for workspace %d: %v", workspaceID, err)
+	}
+	fmt.Printf("Claimed %d todos, %d projects, %d tags and %d webhooks\n",
+		result.Todos, result.Projects, result.Tags, result.Webhooks)
+}
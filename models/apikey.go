@@ -0,0 +1,212 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The scopes an API key can be granted. Each resource has a read scope for
+// GET requests and a write scope for everything else.
+const (
+	ScopeTodosRead     = "todos:read"
+	ScopeTodosWrite    = "todos:write"
+	ScopeProjectsRead  = "projects:read"
+	ScopeProjectsWrite = "projects:write"
+	ScopeTagsRead      = "tags:read"
+	ScopeTagsWrite     = "tags:write"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+)
+
+// apiKeyPrefix starts every API key, so keys are easy to spot in scripts
+// and secret scanners
+const apiKeyPrefix = "tda_"
+
+// apiKeyDisplayLength is how much of a key is kept in the clear to tell
+// keys apart
+const apiKeyDisplayLength = 12
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is unknown, revoked or has expired
+	ErrInvalidAPIKey = errors.New("invalid, revoked or expired API key")
+	// ErrExpiryInPast is returned when creating an API key that has already expired
+	ErrExpiryInPast = newError(ErrValidation, "expires_at", "expires_at must be in the future")
+)
+
+// APIKey is a key a user created for scripts and integrations. The key
+// itself is only known when it is created.
+type APIKey struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is nil for keys that last until they are revoked
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewAPIKey is an API key just created, along with the key itself
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=todos:read todos:write projects:read projects:write tags:read tags:write webhooks:read webhooks:write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyModel handles database operations for API keys
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// NewAPIKeyModel creates a new APIKeyModel instance
+func NewAPIKeyModel(db *sql.DB) *APIKeyModel {
+	return &APIKeyModel{DB: db}
+}
+
+// apiKeyColumns is the column list scanned by scanAPIKey
+const apiKeyColumns = `id, name, prefix, scopes, expires_at, last_used_at, created_at`
+
+// scanAPIKey reads an API key selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &expiresAt, &lastUsedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
+
+// Create creates an API key for a user
+func (m *APIKeyModel) Create(userID int, req CreateAPIKeyRequest) (*NewAPIKey, error) {
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, ErrExpiryInPast
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &NewAPIKey{
+		APIKey: APIKey{
+			Name:      strings.TrimSpace(req.Name),
+			Scopes:    uniqueScopes(req.Scopes),
+			CreatedAt: now,
+		},
+		Key: apiKeyPrefix + token,
+	}
+	key.Prefix = key.Key[:apiKeyDisplayLength]
+	if req.ExpiresAt != nil {
+		expiresAt := req.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+
+	err = m.DB.QueryRow(`
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, userID, key.Name, key.Prefix, hashToken(key.Key), strings.Join(key.Scopes, " "), now, key.ExpiresAt).Scan(&key.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// List retrieves a user's API keys, newest first, including expired ones
+func (m *APIKeyModel) List(userID int) ([]*APIKey, error) {
+	rows, err := m.DB.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Revoke deletes one of a user's API keys, or fails with ErrNotFound
+func (m *APIKeyModel) Revoke(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// Authenticate returns the principal an API key acts as, or fails with
+// ErrInvalidAPIKey
+func (m *APIKeyModel) Authenticate(key string) (*Principal, error) {
+	if !IsAPIKey(key) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	principal := &Principal{}
+	var scopes string
+	err := m.DB.QueryRow(`
+		SELECT api_keys.id, api_keys.scopes, users.id, users.email
+		FROM api_keys JOIN users ON users.id = api_keys.user_id
+		WHERE api_keys.key_hash = ? AND (api_keys.expires_at IS NULL OR api_keys.expires_at > ?)
+	`, hashToken(key), now).Scan(&principal.APIKeyID, &scopes, &principal.UserID, &principal.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	principal.Scopes = strings.Fields(scopes)
+
+	// Recording every use would write on every request, so a minute will do
+	_, err = m.DB.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now, principal.APIKeyID, now.Add(-time.Minute))
+	if err != nil {
+		return nil, err
+	}
+
+	return principal, nil
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than an access token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// uniqueScopes drops repeated scopes, keeping their order
+func uniqueScopes(scopes []string) []string {
+	unique := []string{}
+	for _, scope := range scopes {
+		if !slices.Contains(unique, scope) {
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+)
+
+// BulkOp is the kind of write a bulk operation performs
+type BulkOp string
+
+const (
+	// BulkCreate creates a todo from Create
+	BulkCreate BulkOp = "create"
+	// BulkUpdate replaces the todo ID with Update
+	BulkUpdate BulkOp = "update"
+	// BulkDelete moves the todo ID to the trash
+	BulkDelete BulkOp = "delete"
+	// BulkComplete marks the todo ID as completed
+	BulkComplete BulkOp = "complete"
+)
+
+// BulkOperation is one write of a bulk request
+type BulkOperation struct {
+	Op BulkOp
+	// ID is the todo written by every operation except create
+	ID int
+	// Version, when non-zero, must match the todo's current version
+	Version int
+	Create  CreateTodoRequest
+	Update  UpdateTodoRequest
+}
+
+// BulkResult is the outcome of one bulk operation
+type BulkResult struct {
+	// Todo is the todo as the operation left it; it is nil for deletes and failures
+	Todo *Todo
+	Err  error
+}
+
+// Bulk runs the operations in order in a single transaction. When atomic is
+// true the first failure rolls every operation back and only its result is
+// filled in. Otherwise each failed operation is rolled back on its own and
+// the rest still go ahead. It reports whether the transaction was committed;
+// the error is only set when the transaction itself fails.
+func (m *TodoModel) Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	events := &eventLog{}
+	results := make([]BulkResult, len(ops))
+	for i, op := range ops {
+		// A savepoint lets a failed operation be undone without losing the others
+		if _, err := tx.Exec(`SAVEPOINT bulk_op`); err != nil {
+			return nil, false, err
+		}
+
+		recorded := len(events.events)
+		todo, err := runBulkOp(tx, m.WorkspaceID, op, now, events)
+		if err != nil {
+			if atomic {
+				failed := make([]BulkResult, len(ops))
+				failed[i].Err = err
+				return failed, false, nil
+			}
+			results[i].Err = err
+			if _, err := tx.Exec(`ROLLBACK TO bulk_op`); err != nil {
+				return nil, false, err
+			}
+			events.events = events.events[:recorded]
+		}
+		results[i].Todo = todo
+
+		if _, err := tx.Exec(`RELEASE bulk_op`); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	m.publish(events)
+
+	return results, true, nil
+}
+
+// runBulkOp performs one bulk operation on the todos of a workspace inside the
+// bulk transaction
+func runBulkOp(db dbtx, workspace int, op BulkOperation, now time.Time, events *eventLog) (*Todo, error) {
+	switch op.Op {
+	case BulkCreate:
+		return createTodo(db, workspace, op.Create, now, events)
+	case BulkUpdate:
+		if err := updateTodo(db, workspace, op.ID, op.Update, op.Version, now, events); err != nil {
+			return nil, err
+		}
+		return getTodo(db, op.ID)
+	case BulkDelete:
+		return nil, deleteTodo(db, workspace, op.ID, op.Version, now, events)
+	case BulkComplete:
+		if err := checkWorkspace(db, workspace, op.ID); err != nil {
+			return nil, err
+		}
+		if err := checkVersion(db, op.ID, op.Version); err != nil {
+			return nil, err
+		}
+		nextID, err := toggleComplete(db, op.ID, true, ChildPolicyIgnore, now, events)
+		if err != nil {
+			return nil, err
+		}
+		return getCompletedTodo(db, op.ID, nextID)
+	}
+
+	return nil, newError(ErrValidation, "op", "unknown bulk operation "+string(op.Op))
+}
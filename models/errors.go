@@ -0,0 +1,64 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// The kinds of error the models return. The more specific sentinel errors,
+// such as ErrTagExists, are KindErrors of one of these kinds, so callers can
+// test errors.Is(err, ErrConflict) without knowing every specific error.
+var (
+	// ErrNotFound is returned when the record being read or written does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write clashes with the current state of a record
+	ErrConflict = errors.New("conflict")
+	// ErrValidation is returned when a request is well formed but its values are not acceptable
+	ErrValidation = errors.New("validation failed")
+	// ErrForbidden is returned when the user's role does not allow a change
+	ErrForbidden = errors.New("forbidden")
+)
+
+// KindError is a sentinel error of one of the kinds above
+type KindError struct {
+	// Kind is ErrNotFound, ErrConflict, ErrValidation or ErrForbidden
+	Kind error
+	// Field is the request field at fault, if the error is about a single field
+	Field   string
+	Message string
+}
+
+// Error returns the error message
+func (e *KindError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind of the error, so errors.Is matches it
+func (e *KindError) Unwrap() error {
+	return e.Kind
+}
+
+// newError creates a sentinel error of the given kind
+func newError(kind error, field, message string) *KindError {
+	return &KindError{Kind: kind, Field: field, Message: message}
+}
+
+// notFound translates sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// checkAffected returns ErrNotFound if a write did not touch any row
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
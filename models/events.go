@@ -0,0 +1,233 @@
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change a todo event records
+type EventType string
+
+// Event types
+const (
+	EventCreated   EventType = "created"
+	EventUpdated   EventType = "updated"
+	EventDeleted   EventType = "deleted"
+	EventCompleted EventType = "completed"
+	EventRestored  EventType = "restored"
+)
+
+// DefaultEventRetention is how long events are kept in the event log
+const DefaultEventRetention = 7 * 24 * time.Hour
+
+// TodoEvent is a change to a todo, as recorded in the event log
+type TodoEvent struct {
+	// ID increases with every event, so it orders the log and resumes a feed
+	ID     int64     `json:"id"`
+	Type   EventType `json:"type"`
+	TodoID int       `json:"todo_id"`
+	// Todo is the todo as the change left it
+	Todo      *Todo     `json:"todo"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventBroker fans todo events out to the subscribers in this process
+type EventBroker struct {
+	mu sync.Mutex
+	// subscribers maps each subscriber to the filter its events must pass
+	subscribers map[chan TodoEvent]func(TodoEvent) bool
+}
+
+// NewEventBroker creates a new EventBroker instance
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan TodoEvent]func(TodoEvent) bool)}
+}
+
+// Subscribe returns a channel that receives every event published from now
+// on, and a function that ends the subscription. A subscriber that falls more
+// than buffer events behind has its channel closed, and should catch up from
+// the event log.
+func (b *EventBroker) Subscribe(buffer int) (<-chan TodoEvent, func()) {
+	return b.SubscribeMatching(buffer, nil)
+}
+
+// SubscribeMatching is Subscribe for only the events accepted by match. A
+// nil match accepts every event.
+func (b *EventBroker) SubscribeMatching(buffer int, match func(TodoEvent) bool) (<-chan TodoEvent, func()) {
+	ch := make(chan TodoEvent, buffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = match
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Publish sends events to every subscriber without waiting for slow ones
+func (b *EventBroker) Publish(events ...TodoEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch, match := range b.subscribers {
+		for _, event := range events {
+			if match != nil && !match(event) {
+				continue
+			}
+			select {
+			case ch <- event:
+				continue
+			default:
+			}
+			// Dropping a subscriber that fell behind tells it to catch up from the log
+			delete(b.subscribers, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// eventLog collects the events written by one transaction, so they can be
+// published once it commits
+type eventLog struct {
+	events []TodoEvent
+}
+
+// record writes an event for each of the todos to the event log and queues
+// it for the webhooks that subscribe to it
+func (l *eventLog) record(db dbtx, eventType EventType, now time.Time, ids ...int) error {
+	for _, id := range ids {
+		todo, err := scanTodo(db.QueryRow(`SELECT `+todoColumns+` FROM todos WHERE id = ?`, id))
+		if err != nil {
+			return err
+		}
+		if err := loadTags(db, []*Todo{todo}); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(todo)
+		if err != nil {
+			return err
+		}
+		var eventID int64
+		err = db.QueryRow(`INSERT INTO todo_events (type, todo_id, workspace_id, data, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id`,
+			eventType, id, todo.WorkspaceID, string(data), now).Scan(&eventID)
+		if err != nil {
+			return err
+		}
+
+		event := TodoEvent{ID: eventID, Type: eventType, TodoID: id, Todo: todo, CreatedAt: now}
+		// Webhook deliveries are queued in the same transaction, so they are
+		// only sent for changes that commit
+		if err := enqueueDeliveries(db, event); err != nil {
+			return err
+		}
+		l.events = append(l.events, event)
+	}
+
+	return nil
+}
+
+// publishTo sends the events of a committed transaction to broker, if there is one
+func (l *eventLog) publishTo(broker *EventBroker) {
+	if broker != nil && len(l.events) > 0 {
+		broker.Publish(l.events...)
+	}
+}
+
+// publish sends the events of a committed transaction to the broker
+func (m *TodoModel) publish(log *eventLog) {
+	log.publishTo(m.Events)
+}
+
+// Subscribe returns a channel that receives every event published from now on
+func (m *TodoModel) Subscribe(buffer int) (<-chan TodoEvent, func()) {
+	return m.Events.SubscribeMatching(buffer, workspaceMatch(m.WorkspaceID))
+}
+
+// workspaceMatch returns the event filter of a subscriber limited to the todos
+// of a workspace, or nil for AllWorkspaces
+func workspaceMatch(workspace int) func(TodoEvent) bool {
+	if workspace == AllWorkspaces {
+		return nil
+	}
+	return func(event TodoEvent) bool {
+		return event.Todo != nil && event.Todo.WorkspaceID != nil && *event.Todo.WorkspaceID == workspace
+	}
+}
+
+// EventsSince returns up to limit events logged after the given event ID, oldest first
+func (m *TodoModel) EventsSince(after int64, limit int) ([]TodoEvent, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	rows, err := m.DB.Query(`
+		SELECT id, type, todo_id, data, created_at FROM todo_events
+		WHERE id > ? AND `+workspace+` ORDER BY id LIMIT ?
+	`, append(append([]interface{}{after}, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TodoEvent{}
+	for rows.Next() {
+		var event TodoEvent
+		var data string
+		if err := rows.Scan(&event.ID, &event.Type, &event.TodoID, &data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &event.Todo); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// LastEventID returns the ID of the newest event in the log, or 0 if it is empty
+func (m *TodoModel) LastEventID() (int64, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	var id int64
+	err := m.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM todo_events WHERE `+workspace, args...).Scan(&id)
+	return id, err
+}
+
+// PurgeEvents deletes the events logged before the given time and returns how many were removed
+func (m *TodoModel) PurgeEvents(before time.Time) (int64, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	result, err := m.DB.Exec(`DELETE FROM todo_events WHERE created_at < ? AND `+workspace,
+		append([]interface{}{before.UTC()}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// queryIDs runs a statement that returns a column of todo IDs, such as an
+// UPDATE ... RETURNING id
+func queryIDs(db dbtx, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,325 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFilter is returned when a filter or sort specification is malformed
+var ErrInvalidFilter = newError(ErrValidation, "", "invalid filter")
+
+// TagMode selects how a todo must match a list of tags
+type TagMode string
+
+const (
+	// TagModeAny matches todos carrying at least one of the tags
+	TagModeAny TagMode = "any"
+	// TagModeAll matches todos carrying every one of the tags
+	TagModeAll TagMode = "all"
+)
+
+// DefaultSort is the order used when the caller does not ask for one
+const DefaultSort = "-created_at"
+
+// TodoFilter narrows down the todos returned by List
+type TodoFilter struct {
+	Completed     *bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	DueAfter      time.Time
+	DueBefore     time.Time
+	ProjectID     *int
+	// Inbox matches todos that belong to no project
+	Inbox    bool
+	ParentID *int
+	// TopLevel matches todos that are not a subtask of another todo
+	TopLevel bool
+	Tags     []string
+	// TagMode defaults to TagModeAny
+	TagMode TagMode
+	// Search matches todos whose title or description contains the text
+	Search string
+	// Trashed matches todos in the trash instead of the ones outside it
+	Trashed bool
+	// workspaceID limits the filter to the todos of one workspace, unless it is AllWorkspaces.
+	// The model being listed sets it.
+	workspaceID int
+}
+
+// SortField is a single key of a multi-key sort
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// sortColumn describes a todo column that lists may be sorted by
+type sortColumn struct {
+	column string
+	// value extracts the sort key from a todo so it can be stored in a cursor
+	value func(*Todo) interface{}
+	// decode converts a sort key read back from a cursor into a query argument
+	decode func(interface{}) (interface{}, bool)
+}
+
+// lookupSortColumn returns the column definition for a sortable field name
+func lookupSortColumn(field string) (sortColumn, bool) {
+	switch field {
+	case "id":
+		return sortColumn{"id", func(t *Todo) interface{} { return t.ID }, decodeInt}, true
+	case "title":
+		return sortColumn{"title", func(t *Todo) interface{} { return t.Title }, decodeString}, true
+	case "completed":
+		return sortColumn{"completed", func(t *Todo) interface{} { return t.Completed }, decodeBool}, true
+	case "priority":
+		return sortColumn{"priority", func(t *Todo) interface{} { return int(t.Priority) }, decodeInt}, true
+	case "created_at":
+		return sortColumn{"created_at", func(t *Todo) interface{} { return t.CreatedAt }, decodeTime}, true
+	case "updated_at":
+		return sortColumn{"updated_at", func(t *Todo) interface{} { return t.UpdatedAt }, decodeTime}, true
+	case "due_at":
+		// Todos without a due date sort after every dated todo
+		return sortColumn{"COALESCE(due_at, '9999-12-31 00:00:00+00:00')", dueAtSortKey, decodeTime}, true
+	case "deleted_at":
+		// Todos outside the trash sort before every trashed todo
+		return sortColumn{"COALESCE(deleted_at, '0001-01-01 00:00:00+00:00')", deletedAtSortKey, decodeTime}, true
+	}
+	return sortColumn{}, false
+}
+
+// ParseSort parses a comma separated sort specification such as "-updated_at,title".
+// A leading "-" sorts that key in descending order.
+func ParseSort(spec string) ([]SortField, error) {
+	if spec == "" {
+		spec = DefaultSort
+	}
+
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		field := SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+
+		if _, ok := lookupSortColumn(field.Field); !ok {
+			return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidFilter, field.Field)
+		}
+		if seen[field.Field] {
+			return nil, fmt.Errorf("%w: duplicate sort field %q", ErrInvalidFilter, field.Field)
+		}
+		seen[field.Field] = true
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// sortSpec renders sort fields back into their canonical string form
+func sortSpec(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.Field
+		if f.Desc {
+			parts[i] = "-" + f.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// withTiebreaker appends id to the sort keys so every row has a unique position
+func withTiebreaker(fields []SortField) []SortField {
+	for _, f := range fields {
+		if f.Field == "id" {
+			return fields
+		}
+	}
+	last := fields[len(fields)-1]
+	return append(append([]SortField{}, fields...), SortField{Field: "id", Desc: last.Desc})
+}
+
+// whereClause compiles the filter into a parameterized SQL condition list
+func (f TodoFilter) whereClause() ([]string, []interface{}) {
+	conds := []string{"deleted_at IS NULL"}
+	var args []interface{}
+
+	if f.Trashed {
+		conds[0] = "deleted_at IS NOT NULL"
+	}
+	if f.workspaceID != AllWorkspaces {
+		conds = append(conds, "workspace_id = ?")
+		args = append(args, f.workspaceID)
+	}
+	if f.Completed != nil {
+		conds = append(conds, "completed = ?")
+		args = append(args, *f.Completed)
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.CreatedAfter.UTC())
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.CreatedBefore.UTC())
+	}
+	if !f.UpdatedAfter.IsZero() {
+		conds = append(conds, "updated_at >= ?")
+		args = append(args, f.UpdatedAfter.UTC())
+	}
+	if !f.UpdatedBefore.IsZero() {
+		conds = append(conds, "updated_at < ?")
+		args = append(args, f.UpdatedBefore.UTC())
+	}
+	if !f.DueAfter.IsZero() {
+		conds = append(conds, "due_at >= ?")
+		args = append(args, f.DueAfter.UTC())
+	}
+	if !f.DueBefore.IsZero() {
+		conds = append(conds, "due_at < ?")
+		args = append(args, f.DueBefore.UTC())
+	}
+	if f.ProjectID != nil {
+		conds = append(conds, "project_id = ?")
+		args = append(args, *f.ProjectID)
+	}
+	if f.Inbox {
+		conds = append(conds, "project_id IS NULL")
+	}
+	if f.ParentID != nil {
+		conds = append(conds, "parent_id = ?")
+		args = append(args, *f.ParentID)
+	}
+	if f.TopLevel {
+		conds = append(conds, "parent_id IS NULL")
+	}
+	if len(f.Tags) > 0 {
+		cond, tagArgs := f.tagClause()
+		conds = append(conds, cond)
+		args = append(args, tagArgs...)
+	}
+	if f.Search != "" {
+		pattern := "%" + escapeLike(f.Search) + "%"
+		// LIKE ignores case in SQLite but not in Postgres
+		conds = append(conds, `(lower(title) LIKE lower(?) ESCAPE '\' OR lower(description) LIKE lower(?) ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	return conds, args
+}
+
+// tagClause matches todos carrying any, or all, of the filter's tags
+func (f TodoFilter) tagClause() (string, []interface{}) {
+	var placeholders []string
+	var args []interface{}
+	seen := map[string]bool{}
+	for _, name := range f.Tags {
+		name = normalizeTagName(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		placeholders = append(placeholders, "?")
+		args = append(args, name)
+	}
+	if len(placeholders) == 0 {
+		return "1 = 1", nil
+	}
+
+	query := `id IN (
+		SELECT todo_tags.todo_id FROM todo_tags
+		JOIN tags ON tags.id = todo_tags.tag_id
+		WHERE tags.name IN (` + strings.Join(placeholders, ", ") + `)`
+	if f.TagMode == TagModeAll {
+		query += ` GROUP BY todo_tags.todo_id HAVING COUNT(*) = ?`
+		args = append(args, len(placeholders))
+	}
+
+	return query + `)`, args
+}
+
+// keysetClause builds the condition selecting rows that sort after the cursor
+// position. SQLite compares times as text, which holds as every time is
+// stored in UTC (see migration 5).
+func keysetClause(fields []SortField, values []interface{}) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+
+	for i, f := range fields {
+		col, _ := lookupSortColumn(f.Field)
+		op := ">"
+		if f.Desc {
+			op = "<"
+		}
+
+		var ands []string
+		for j := 0; j < i; j++ {
+			prev, _ := lookupSortColumn(fields[j].Field)
+			ands = append(ands, prev.column+" = ?")
+			args = append(args, values[j])
+		}
+		ands = append(ands, col.column+" "+op+" ?")
+		args = append(args, values[i])
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// orderByClause renders the ORDER BY list for the given sort fields
+func orderByClause(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		col, _ := lookupSortColumn(f.Field)
+		parts[i] = col.column + " ASC"
+		if f.Desc {
+			parts[i] = col.column + " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// dueAtSortKey matches the COALESCE used to sort todos without a due date last
+func dueAtSortKey(t *Todo) interface{} {
+	if t.DueAt == nil {
+		return time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	return t.DueAt.UTC()
+}
+
+// deletedAtSortKey matches the COALESCE used to sort todos outside the trash first
+func deletedAtSortKey(t *Todo) interface{} {
+	if t.DeletedAt == nil {
+		return time.Time{}
+	}
+	return t.DeletedAt.UTC()
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied search string
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func decodeInt(v interface{}) (interface{}, bool) {
+	n, ok := v.(float64)
+	return int(n), ok
+}
+
+func decodeString(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	return s, ok
+}
+
+func decodeBool(v interface{}) (interface{}, bool) {
+	b, ok := v.(bool)
+	return b, ok
+}
+
+func decodeTime(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+// RoleGuard is the permission check between the handlers and the todos of a
+// workspace. Every member may read, changing todos takes an editor, and
+// purging the trash or the event log takes an admin. Methods it does not
+// override only read, so they go straight to the repository.
+type RoleGuard struct {
+	TodoRepository
+	Role Role
+}
+
+// NewRoleGuard limits a workspace's todos to what a member with the given
+// role may do
+func NewRoleGuard(todos TodoRepository, role Role) *RoleGuard {
+	return &RoleGuard{TodoRepository: todos, Role: role}
+}
+
+// require fails with ErrInsufficientRole unless the guard's role is at least min
+func (g *RoleGuard) require(min Role) error {
+	if !g.Role.AtLeast(min) {
+		return ErrInsufficientRole
+	}
+	return nil
+}
+
+// ForWorkspace returns the todos of another workspace, guarded by the same role
+func (g *RoleGuard) ForWorkspace(workspaceID int) TodoRepository {
+	return NewRoleGuard(g.TodoRepository.ForWorkspace(workspaceID), g.Role)
+}
+
+// Create inserts a new todo
+func (g *RoleGuard) Create(req CreateTodoRequest) (*Todo, error) {
+	if err := g.require(RoleEditor); err != nil {
+		return nil, err
+	}
+	return g.TodoRepository.Create(req)
+}
+
+// Update replaces a todo; a non-zero version must be its current one
+func (g *RoleGuard) Update(id int, req UpdateTodoRequest, version int) (*Todo, error) {
+	if err := g.require(RoleEditor); err != nil {
+		return nil, err
+	}
+	return g.TodoRepository.Update(id, req, version)
+}
+
+// SetPriority changes the priority of a todo
+func (g *RoleGuard) SetPriority(id int, priority Priority) (*Todo, error) {
+	if err := g.require(RoleEditor); err != nil {
+		return nil, err
+	}
+	return g.TodoRepository.SetPriority(id, priority)
+}
+
+// ToggleComplete completes or reopens a todo
+func (g *RoleGuard) ToggleComplete(id int, completed bool, policy ChildPolicy) (*Todo, error) {
+	if err := g.require(RoleEditor); err != nil {
+		return nil, err
+	}
+	return g.TodoRepository.ToggleComplete(id, completed, policy)
+}
+
+// Delete moves a todo and its subtasks to the trash
+func (g *RoleGuard) Delete(id int, version int) error {
+	if err := g.require(RoleEditor); err != nil {
+		return err
+	}
+	return g.TodoRepository.Delete(id, version)
+}
+
+// Restore takes a todo and the subtasks trashed with it out of the trash
+func (g *RoleGuard) Restore(id int) (*Todo, error) {
+	if err := g.require(RoleEditor); err != nil {
+		return nil, err
+	}
+	return g.TodoRepository.Restore(id)
+}
+
+// Purge permanently deletes todos trashed before the given time
+func (g *RoleGuard) Purge(before time.Time) (int64, error) {
+	if err := g.require(RoleAdmin); err != nil {
+		return 0, err
+	}
+	return g.TodoRepository.Purge(before)
+}
+
+// Bulk runs several writes in one transaction
+func (g *RoleGuard) Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, bool, error) {
+	if err := g.require(RoleEditor); err != nil {
+		return nil, false, err
+	}
+	return g.TodoRepository.Bulk(ops, atomic)
+}
+
+// PurgeEvents deletes the events logged before the given time
+func (g *RoleGuard) PurgeEvents(before time.Time) (int64, error) {
+	if err := g.require(RoleAdmin); err != nil {
+		return 0, err
+	}
+	return g.TodoRepository.PurgeEvents(before)
+}
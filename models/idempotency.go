@@ -0,0 +1,119 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long the response to an idempotent request is kept
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with a different request
+	ErrIdempotencyKeyReused = newError(ErrValidation, "Idempotency-Key", "idempotency key was already used for a different request")
+	// ErrIdempotencyKeyInUse is returned while the first request with a key is still being handled
+	ErrIdempotencyKeyInUse = newError(ErrConflict, "Idempotency-Key", "a request with this idempotency key is still being handled")
+)
+
+// StoredResponse is the response recorded for an idempotent request
+type StoredResponse struct {
+	Status int
+	// Header holds the response headers worth replaying, such as Content-Type and ETag
+	Header map[string]string
+	Body   []byte
+}
+
+// IdempotencyModel handles database operations for idempotency keys
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// NewIdempotencyModel creates a new IdempotencyModel instance
+func NewIdempotencyModel(db *sql.DB) *IdempotencyModel {
+	return &IdempotencyModel{DB: db}
+}
+
+// Begin claims a key for a request identified by fingerprint. It returns nil
+// if the request should go ahead, or the stored response if the key was
+// already used for the same request. It fails with ErrIdempotencyKeyReused if
+// the key was used for a different request, and ErrIdempotencyKeyInUse if that
+// request has not finished yet. Keys older than ttl are claimed afresh.
+func (m *IdempotencyModel) Begin(key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE key = ? AND expires_at <= ?`, key, now); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO idempotency_keys (key, fingerprint, created_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO NOTHING
+	`, key, fingerprint, now, now.Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if claimed == 1 {
+		return nil, tx.Commit()
+	}
+
+	var stored StoredResponse
+	var storedFingerprint, header string
+	err = tx.QueryRow(`SELECT fingerprint, status, headers, body FROM idempotency_keys WHERE key = ?`, key).
+		Scan(&storedFingerprint, &stored.Status, &header, &stored.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case storedFingerprint != fingerprint:
+		return nil, ErrIdempotencyKeyReused
+	case stored.Status == 0:
+		return nil, ErrIdempotencyKeyInUse
+	}
+	if err := json.Unmarshal([]byte(header), &stored.Header); err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// Finish records the response to the request that claimed a key
+func (m *IdempotencyModel) Finish(key string, resp StoredResponse) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	result, err := m.DB.Exec(`UPDATE idempotency_keys SET status = ?, headers = ?, body = ? WHERE key = ?`,
+		resp.Status, string(header), resp.Body, key)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// Release forgets a key whose request failed, so a retry runs it again
+func (m *IdempotencyModel) Release(key string) error {
+	_, err := m.DB.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, key)
+	return err
+}
+
+// Purge deletes the keys that expired before the given time and returns how many were removed
+func (m *IdempotencyModel) Purge(before time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,1190 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryTodoRepository keeps todos in memory, for tests and ephemeral
+// deployments. It behaves like TodoModel, but everything is lost when the
+// process exits.
+type MemoryTodoRepository struct {
+	*memoryTodoStore
+	// workspaceID limits the repository to the todos of one workspace unless it
+	// is AllWorkspaces
+	workspaceID int
+}
+
+// memoryTodoStore holds the todos of a MemoryTodoRepository, shared with the
+// repositories ForWorkspace returns
+type memoryTodoStore struct {
+	// ProjectExists reports whether a project exists in a workspace, or in
+	// any workspace for AllWorkspaces. Without it, todos cannot be put in
+	// a project.
+	ProjectExists func(workspaceID, id int) (bool, error)
+	// Events receives an event for every change to a todo
+	Events *EventBroker
+
+	mu sync.Mutex
+	// todos are never changed in place; a write stores a changed copy, so a
+	// journal can put the old one back
+	todos  map[int]*Todo
+	lastID int
+	// tagNames maps the lower case form of every tag name to the name as first used
+	tagNames    map[string]string
+	events      []TodoEvent
+	lastEventID int64
+	// journal, while set, records what the writes of a bulk operation replace
+	journal *memoryJournal
+}
+
+// memoryJournal records the state a bulk operation changed, so it can be undone
+type memoryJournal struct {
+	// todos holds the todos replaced or added, as they were before; nil for added todos
+	todos       map[int]*Todo
+	lastID      int
+	tagNames    []string
+	events      int
+	lastEventID int64
+}
+
+// NewMemoryTodoRepository creates a new, empty MemoryTodoRepository instance
+func NewMemoryTodoRepository() *MemoryTodoRepository {
+	return &MemoryTodoRepository{memoryTodoStore: &memoryTodoStore{
+		Events:   NewEventBroker(),
+		todos:    make(map[int]*Todo),
+		tagNames: make(map[string]string),
+	}, workspaceID: AllWorkspaces}
+}
+
+// ForWorkspace returns a repository limited to the todos of one workspace,
+// sharing this repository's todos and event broker
+func (m *MemoryTodoRepository) ForWorkspace(workspaceID int) TodoRepository {
+	return &MemoryTodoRepository{memoryTodoStore: m.memoryTodoStore, workspaceID: workspaceID}
+}
+
+// inWorkspace reports whether a stored todo is visible to the repository
+func (m *MemoryTodoRepository) inWorkspace(todo *Todo) bool {
+	return m.workspaceID == AllWorkspaces || (todo.WorkspaceID != nil && *todo.WorkspaceID == m.workspaceID)
+}
+
+// checkWorkspace fails with ErrNotFound unless the todo, in the trash or not,
+// belongs to the repository's workspace
+func (m *MemoryTodoRepository) checkWorkspace(id int) error {
+	if m.workspaceID == AllWorkspaces {
+		return nil
+	}
+	if todo := m.todos[id]; todo == nil || !m.inWorkspace(todo) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// cloneTodo copies a todo, so the copy can be handed out or changed without
+// touching the original
+func cloneTodo(todo *Todo) *Todo {
+	c := *todo
+	c.WorkspaceID = cloneInt(todo.WorkspaceID)
+	c.ProjectID = cloneInt(todo.ProjectID)
+	c.ParentID = cloneInt(todo.ParentID)
+	c.SeriesID = cloneInt(todo.SeriesID)
+	c.DueAt = cloneTime(todo.DueAt)
+	c.RecurrenceStart = cloneTime(todo.RecurrenceStart)
+	c.DeletedAt = cloneTime(todo.DeletedAt)
+	c.Tags = append([]string{}, todo.Tags...)
+	c.NextOccurrence = nil
+	c.Children = nil
+	return &c
+}
+
+func cloneInt(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func cloneTime(p *time.Time) *time.Time {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// render returns a copy of a stored todo with its due dates in its timezone,
+// as TodoModel reads them
+func (m *MemoryTodoRepository) render(todo *Todo) *Todo {
+	c := cloneTodo(todo)
+	if c.DueAt != nil {
+		c.DueAt = localDueAt(*c.DueAt, c.DueTimezone)
+	}
+	if c.RecurrenceStart != nil {
+		c.RecurrenceStart = localDueAt(*c.RecurrenceStart, c.DueTimezone)
+	}
+	return c
+}
+
+// live returns the stored todo with the given ID if it is outside the trash
+// and belongs to the repository's workspace
+func (m *MemoryTodoRepository) live(id int) *Todo {
+	todo := m.todos[id]
+	if todo == nil || todo.DeletedAt != nil || !m.inWorkspace(todo) {
+		return nil
+	}
+	return todo
+}
+
+// put stores a todo, recording the one it replaces in the journal
+func (m *MemoryTodoRepository) put(todo *Todo) {
+	if m.journal != nil {
+		if _, ok := m.journal.todos[todo.ID]; !ok {
+			m.journal.todos[todo.ID] = m.todos[todo.ID]
+		}
+	}
+	m.todos[todo.ID] = todo
+}
+
+// begin starts recording writes so they can be rolled back
+func (m *MemoryTodoRepository) begin() {
+	m.journal = &memoryJournal{
+		todos:       make(map[int]*Todo),
+		lastID:      m.lastID,
+		events:      len(m.events),
+		lastEventID: m.lastEventID,
+	}
+}
+
+// rollback undoes every write since begin
+func (m *MemoryTodoRepository) rollback() {
+	j := m.journal
+	m.journal = nil
+	for id, todo := range j.todos {
+		if todo == nil {
+			delete(m.todos, id)
+		} else {
+			m.todos[id] = todo
+		}
+	}
+	for _, key := range j.tagNames {
+		delete(m.tagNames, key)
+	}
+	m.lastID = j.lastID
+	m.events = m.events[:j.events]
+	m.lastEventID = j.lastEventID
+}
+
+// record logs an event for each of the todos
+func (m *MemoryTodoRepository) record(eventType EventType, now time.Time, ids ...int) {
+	for _, id := range ids {
+		m.lastEventID++
+		m.events = append(m.events, TodoEvent{
+			ID:        m.lastEventID,
+			Type:      eventType,
+			TodoID:    id,
+			Todo:      m.render(m.todos[id]),
+			CreatedAt: now,
+		})
+	}
+}
+
+// publish sends the events logged since the given position to the broker
+func (m *MemoryTodoRepository) publish(from int) {
+	if m.Events != nil && len(m.events) > from {
+		m.Events.Publish(m.events[from:]...)
+	}
+}
+
+// storeTags returns tag names as TodoModel stores them: normalized, without
+// duplicates, in the case they were first used in, and sorted
+func (m *MemoryTodoRepository) storeTags(names []string) []string {
+	stored := make([]string, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = normalizeTagName(name)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if existing, ok := m.tagNames[key]; ok {
+			name = existing
+		} else {
+			m.tagNames[key] = name
+			if m.journal != nil {
+				m.journal.tagNames = append(m.journal.tagNames, key)
+			}
+		}
+		stored = append(stored, name)
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return strings.ToLower(stored[i]) < strings.ToLower(stored[j])
+	})
+	return stored
+}
+
+// checkProject returns ErrUnknownProject unless projectID is nil or names a
+// project of the repository's workspace
+func (m *MemoryTodoRepository) checkProject(projectID *int) error {
+	if projectID == nil {
+		return nil
+	}
+	if m.ProjectExists == nil {
+		return ErrUnknownProject
+	}
+	exists, err := m.ProjectExists(m.workspaceID, *projectID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownProject
+	}
+	return nil
+}
+
+// checkParent returns an error unless parentID is nil, names a todo of the
+// same workspace outside the trash, and is not the todo itself or one of its
+// descendants
+func (m *MemoryTodoRepository) checkParent(id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	if m.live(*parentID) == nil {
+		return ErrUnknownParent
+	}
+	if id == 0 {
+		return nil
+	}
+	if *parentID == id {
+		return ErrCycle
+	}
+	for _, descendant := range m.descendants(id, nil) {
+		if descendant == *parentID {
+			return ErrCycle
+		}
+	}
+	return nil
+}
+
+// descendants returns the IDs of the todos below a todo, parents before their
+// children. Only todos accepted by include are followed; by default those
+// outside the trash.
+func (m *MemoryTodoRepository) descendants(id int, include func(*Todo) bool) []int {
+	if include == nil {
+		include = func(todo *Todo) bool { return todo.DeletedAt == nil }
+	}
+
+	children := make(map[int][]int)
+	for _, todo := range m.todos {
+		if todo.ParentID != nil && include(todo) {
+			children[*todo.ParentID] = append(children[*todo.ParentID], todo.ID)
+		}
+	}
+
+	var ids []int
+	queue := []int{id}
+	for len(queue) > 0 {
+		next := children[queue[0]]
+		sort.Ints(next)
+		queue = append(queue[1:], next...)
+		ids = append(ids, next...)
+	}
+	return ids
+}
+
+// sortedTodos returns copies of the stored todos accepted by keep, in the given order
+func (m *MemoryTodoRepository) sortedTodos(keep func(*Todo) bool, less func(a, b *Todo) bool) []*Todo {
+	todos := make([]*Todo, 0)
+	for _, todo := range m.todos {
+		if keep(todo) {
+			todos = append(todos, m.render(todo))
+		}
+	}
+	sort.Slice(todos, func(i, j int) bool { return less(todos[i], todos[j]) })
+	return todos
+}
+
+// oldestFirst orders todos by creation, as subtasks are listed
+func oldestFirst(a, b *Todo) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// Create inserts a new todo
+func (m *MemoryTodoRepository) Create(req CreateTodoRequest) (*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := len(m.events)
+	todo, err := m.create(req, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	m.publish(from)
+
+	return todo, nil
+}
+
+// create inserts a new todo while the lock is held
+func (m *MemoryTodoRepository) create(req CreateTodoRequest, now time.Time) (*Todo, error) {
+	owner, err := ownerWorkspace(m.workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	recurrence, err := normalizeRecurrence(req.Recurrence, req.DueAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkProject(req.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := m.checkParent(0, req.ParentID); err != nil {
+		return nil, err
+	}
+
+	m.lastID++
+	todo := &Todo{
+		ID:          m.lastID,
+		WorkspaceID: owner,
+		Title:       req.Title,
+		Description: req.Description,
+		Priority:    req.Priority,
+		ProjectID:   cloneInt(req.ProjectID),
+		ParentID:    cloneInt(req.ParentID),
+		DueTimezone: req.DueTimezone,
+		Recurrence:  recurrence,
+		Tags:        m.storeTags(req.Tags),
+		Version:     1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if req.DueAt != nil {
+		dueAt := req.DueAt.UTC()
+		todo.DueAt = &dueAt
+		if recurrence != "" {
+			todo.RecurrenceStart = cloneTime(&dueAt)
+		}
+	}
+	m.put(todo)
+	m.record(EventCreated, now, todo.ID)
+
+	return m.render(todo), nil
+}
+
+// GetByID retrieves a todo by its ID. Todos in the trash fail with ErrNotFound.
+func (m *MemoryTodoRepository) GetByID(id int) (*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+// get reads a todo outside the trash while the lock is held
+func (m *MemoryTodoRepository) get(id int) (*Todo, error) {
+	todo := m.live(id)
+	if todo == nil {
+		return nil, ErrNotFound
+	}
+	return m.render(todo), nil
+}
+
+// GetAll retrieves all todos that are not in the trash, newest first
+func (m *MemoryTodoRepository) GetAll() ([]*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	todos := m.sortedTodos(func(todo *Todo) bool { return todo.DeletedAt == nil && m.inWorkspace(todo) }, func(a, b *Todo) bool {
+		return oldestFirst(b, a)
+	})
+	if len(todos) == 0 {
+		return nil, nil
+	}
+	return todos, nil
+}
+
+// List retrieves a filtered, sorted page of todos
+func (m *MemoryTodoRepository) List(params ListParams) (*TodoPage, error) {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	params.Filter.workspaceID = m.workspaceID
+	fields := params.Sort
+	if len(fields) == 0 {
+		fields, _ = ParseSort(DefaultSort)
+	}
+	fields = withTiebreaker(fields)
+
+	var after []interface{}
+	if params.Cursor != "" {
+		values, err := decodeCursor(params.Cursor, fields)
+		if err != nil {
+			return nil, err
+		}
+		after = values
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matching := m.sortedTodos(func(todo *Todo) bool { return params.Filter.matches(todo) }, func(a, b *Todo) bool {
+		return compareSortKeys(fields, sortKeys(fields, a), sortKeys(fields, b)) < 0
+	})
+
+	todos := make([]*Todo, 0, limit)
+	for _, todo := range matching {
+		if after != nil && compareSortKeys(fields, sortKeys(fields, todo), after) <= 0 {
+			continue
+		}
+		todos = append(todos, todo)
+	}
+
+	page := &TodoPage{Todos: todos}
+	if len(todos) > limit {
+		page.Todos = todos[:limit]
+		page.NextCursor = encodeCursor(fields, page.Todos[limit-1])
+	}
+	if params.IncludeTotal {
+		total := len(matching)
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// Count returns the number of todos matching the filter
+func (m *MemoryTodoRepository) Count(filter TodoFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filter.workspaceID = m.workspaceID
+	count := 0
+	for _, todo := range m.todos {
+		if filter.matches(todo) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// matches reports whether a todo passes the filter, as whereClause does in SQL
+func (f TodoFilter) matches(todo *Todo) bool {
+	if (todo.DeletedAt != nil) != f.Trashed {
+		return false
+	}
+	if f.workspaceID != AllWorkspaces && (todo.WorkspaceID == nil || *todo.WorkspaceID != f.workspaceID) {
+		return false
+	}
+	if f.Completed != nil && todo.Completed != *f.Completed {
+		return false
+	}
+	if !inTimeRange(&todo.CreatedAt, f.CreatedAfter, f.CreatedBefore) ||
+		!inTimeRange(&todo.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) {
+		return false
+	}
+	if (!f.DueAfter.IsZero() || !f.DueBefore.IsZero()) && !inTimeRange(todo.DueAt, f.DueAfter, f.DueBefore) {
+		return false
+	}
+	if f.ProjectID != nil && (todo.ProjectID == nil || *todo.ProjectID != *f.ProjectID) {
+		return false
+	}
+	if f.Inbox && todo.ProjectID != nil {
+		return false
+	}
+	if f.ParentID != nil && (todo.ParentID == nil || *todo.ParentID != *f.ParentID) {
+		return false
+	}
+	if f.TopLevel && todo.ParentID != nil {
+		return false
+	}
+	if len(f.Tags) > 0 && !f.matchesTags(todo.Tags) {
+		return false
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(todo.Title), search) &&
+			!strings.Contains(strings.ToLower(todo.Description), search) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesTags reports whether a todo's tags carry any, or all, of the filter's tags
+func (f TodoFilter) matchesTags(tags []string) bool {
+	wanted := map[string]bool{}
+	for _, name := range f.Tags {
+		if name = normalizeTagName(name); name != "" {
+			wanted[strings.ToLower(name)] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return true
+	}
+
+	found := 0
+	for _, tag := range tags {
+		if wanted[strings.ToLower(tag)] {
+			found++
+		}
+	}
+	if f.TagMode == TagModeAll {
+		return found == len(wanted)
+	}
+	return found > 0
+}
+
+// inTimeRange reports whether t is at or after from and before to. Zero
+// bounds are left out, and a missing time is outside any range.
+func inTimeRange(t *time.Time, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// sortKeys returns a todo's values for the given sort fields
+func sortKeys(fields []SortField, todo *Todo) []interface{} {
+	keys := make([]interface{}, len(fields))
+	for i, f := range fields {
+		col, _ := lookupSortColumn(f.Field)
+		keys[i] = col.value(todo)
+	}
+	return keys
+}
+
+// compareSortKeys compares two lists of sort keys in the order the fields
+// ask for, returning a negative number if a sorts first
+func compareSortKeys(fields []SortField, a, b []interface{}) int {
+	for i, f := range fields {
+		c := compareSortKey(a[i], b[i])
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareSortKey compares two values of one sort key
+func compareSortKey(a, b interface{}) int {
+	switch a := a.(type) {
+	case int:
+		return a - b.(int)
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		if a == b.(bool) {
+			return 0
+		} else if a {
+			return 1
+		}
+		return -1
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("unsupported sort key %T", a))
+}
+
+// Search finds todos whose title or description matches the query, best match
+// first, with the same query syntax as TodoModel. Scores count matches, with
+// title matches weighing ten times as much as description matches.
+func (m *MemoryTodoRepository) Search(q string, limit int) ([]*SearchResult, error) {
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	terms, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]*SearchResult, 0)
+	for _, todo := range m.todos {
+		if todo.DeletedAt == nil && m.inWorkspace(todo) {
+			if result := searchTodo(todo, terms); result != nil {
+				result.Todo = m.render(todo)
+				results = append(results, result)
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// GetChildren retrieves the direct subtasks of a todo, oldest first
+func (m *MemoryTodoRepository) GetChildren(id int) ([]*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkWorkspace(id); err != nil {
+		return nil, err
+	}
+	return m.sortedTodos(func(todo *Todo) bool {
+		return todo.DeletedAt == nil && todo.ParentID != nil && *todo.ParentID == id
+	}, oldestFirst), nil
+}
+
+// GetTree retrieves every subtask below a todo, nested under its parent through
+// the Children field. The direct subtasks are returned, oldest first.
+func (m *MemoryTodoRepository) GetTree(id int) ([]*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkWorkspace(id); err != nil {
+		return nil, err
+	}
+	below := map[int]bool{}
+	for _, descendant := range m.descendants(id, nil) {
+		below[descendant] = true
+	}
+	todos := m.sortedTodos(func(todo *Todo) bool { return below[todo.ID] }, oldestFirst)
+
+	byID := make(map[int]*Todo, len(todos))
+	for _, todo := range todos {
+		todo.Children = []*Todo{}
+		byID[todo.ID] = todo
+	}
+
+	roots := make([]*Todo, 0)
+	for _, todo := range todos {
+		if *todo.ParentID == id {
+			roots = append(roots, todo)
+		} else {
+			parent := byID[*todo.ParentID]
+			parent.Children = append(parent.Children, todo)
+		}
+	}
+
+	return roots, nil
+}
+
+// PreviewOccurrences returns up to count due dates of a recurring todo,
+// starting with its current due date
+func (m *MemoryTodoRepository) PreviewOccurrences(id int, count int) ([]time.Time, error) {
+	todo, err := m.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return previewOccurrences(todo, count)
+}
+
+// Update modifies an existing todo, or fails with ErrNotFound. A non-zero version
+// must match the todo's current version, or ErrVersionMismatch is returned and
+// nothing changes.
+func (m *MemoryTodoRepository) Update(id int, req UpdateTodoRequest, version int) (*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := len(m.events)
+	if err := m.update(id, req, version, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	m.publish(from)
+
+	return m.get(id)
+}
+
+// update modifies an existing todo while the lock is held
+func (m *MemoryTodoRepository) update(id int, req UpdateTodoRequest, version int, now time.Time) error {
+	if err := m.checkWorkspace(id); err != nil {
+		return err
+	}
+
+	recurrence, err := normalizeRecurrence(req.Recurrence, req.DueAt)
+	if err != nil {
+		return err
+	}
+	if err := m.checkProject(req.ProjectID); err != nil {
+		return err
+	}
+	if err := m.checkParent(id, req.ParentID); err != nil {
+		return err
+	}
+
+	current := m.live(id)
+	if current == nil {
+		return ErrNotFound
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionMismatch
+	}
+
+	todo := cloneTodo(current)
+	todo.Title = req.Title
+	todo.Description = req.Description
+	todo.Priority = req.Priority
+	todo.ProjectID = cloneInt(req.ProjectID)
+	todo.ParentID = cloneInt(req.ParentID)
+	todo.DueAt = nil
+	if req.DueAt != nil {
+		dueAt := req.DueAt.UTC()
+		todo.DueAt = &dueAt
+	}
+	todo.DueTimezone = req.DueTimezone
+	todo.Recurrence = recurrence
+
+	// The series keeps its start unless the rule or the due date changes,
+	// so rules with a COUNT or UNTIL stay anchored to the first occurrence
+	todo.RecurrenceStart = nil
+	if recurrence != "" {
+		todo.RecurrenceStart = cloneTime(todo.DueAt)
+		if recurrence == current.Recurrence && current.RecurrenceStart != nil &&
+			current.DueAt != nil && current.DueAt.Equal(*req.DueAt) {
+			todo.RecurrenceStart = cloneTime(current.RecurrenceStart)
+		}
+	}
+
+	todo.Tags = m.storeTags(req.Tags)
+	todo.Version++
+	todo.UpdatedAt = now
+	m.put(todo)
+	m.record(EventUpdated, now, id)
+
+	return nil
+}
+
+// SetPriority changes the priority of a todo, or fails with ErrNotFound
+func (m *MemoryTodoRepository) SetPriority(id int, priority Priority) (*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.live(id)
+	if current == nil {
+		return nil, ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	from := len(m.events)
+	todo := cloneTodo(current)
+	todo.Priority = priority
+	todo.Version++
+	todo.UpdatedAt = now
+	m.put(todo)
+	m.record(EventUpdated, now, id)
+	m.publish(from)
+
+	return m.render(todo), nil
+}
+
+// ToggleComplete toggles the completed status of a todo. When completing,
+// policy decides what happens to its subtasks; it is ignored when reopening.
+// Completing a recurring todo also creates its next occurrence, which is
+// returned in the NextOccurrence field.
+func (m *MemoryTodoRepository) ToggleComplete(id int, completed bool, policy ChildPolicy) (*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := len(m.events)
+	m.begin()
+	nextID, err := m.toggleComplete(id, completed, policy, time.Now().UTC())
+	if err != nil {
+		m.rollback()
+		return nil, err
+	}
+	m.journal = nil
+	m.publish(from)
+
+	return m.getCompleted(id, nextID)
+}
+
+// toggleComplete sets the completed status of a todo while the lock is held.
+// It returns the ID of the next occurrence when completing a recurring todo
+// created one.
+func (m *MemoryTodoRepository) toggleComplete(id int, completed bool, policy ChildPolicy, now time.Time) (*int, error) {
+	if m.live(id) == nil {
+		return nil, ErrNotFound
+	}
+
+	var nextID *int
+	eventType := EventUpdated
+	if completed {
+		eventType = EventCompleted
+		children, err := m.completeChildren(id, policy, now)
+		if err != nil {
+			return nil, err
+		}
+		m.record(EventCompleted, now, children...)
+
+		if todo := m.todos[id]; todo.Recurrence != "" && !todo.Completed {
+			if nextID, err = m.spawnNextOccurrence(todo, now); err != nil {
+				return nil, err
+			}
+			if nextID != nil {
+				m.record(EventCreated, now, *nextID)
+			}
+		}
+	}
+
+	todo := cloneTodo(m.todos[id])
+	todo.Completed = completed
+	todo.Version++
+	todo.UpdatedAt = now
+	m.put(todo)
+	m.record(eventType, now, id)
+
+	return nextID, nil
+}
+
+// completeChildren applies a ChildPolicy to the subtasks of a todo being
+// completed, and returns the IDs of the subtasks it completed
+func (m *MemoryTodoRepository) completeChildren(id int, policy ChildPolicy, now time.Time) ([]int, error) {
+	switch policy {
+	case ChildPolicyRefuse:
+		for _, child := range m.descendants(id, nil) {
+			if !m.todos[child].Completed {
+				return nil, ErrOpenChildren
+			}
+		}
+	case ChildPolicyCascade:
+		var completed []int
+		for _, child := range m.descendants(id, nil) {
+			if todo := m.todos[child]; !todo.Completed {
+				todo = cloneTodo(todo)
+				todo.Completed = true
+				todo.Version++
+				todo.UpdatedAt = now
+				m.put(todo)
+				completed = append(completed, child)
+			}
+		}
+		return completed, nil
+	case ChildPolicyIgnore, "":
+	default:
+		return nil, fmt.Errorf("unknown child policy %q", policy)
+	}
+
+	return nil, nil
+}
+
+// spawnNextOccurrence creates the todo for the occurrence after the given
+// recurring todo, as TodoModel does. It returns nil when the series has ended.
+func (m *MemoryTodoRepository) spawnNextOccurrence(todo *Todo, now time.Time) (*int, error) {
+	next, seriesID, start, err := occurrenceAfter(m.render(todo))
+	if err != nil {
+		return nil, err
+	}
+
+	finished := cloneTodo(todo)
+	finished.Recurrence = ""
+	finished.SeriesID = &seriesID
+	m.put(finished)
+	if next.IsZero() {
+		return nil, nil
+	}
+
+	m.lastID++
+	dueAt := next.UTC()
+	m.put(&Todo{
+		ID:              m.lastID,
+		WorkspaceID:     cloneInt(todo.WorkspaceID),
+		Title:           todo.Title,
+		Description:     todo.Description,
+		Priority:        todo.Priority,
+		ProjectID:       cloneInt(todo.ProjectID),
+		ParentID:        cloneInt(todo.ParentID),
+		DueAt:           &dueAt,
+		DueTimezone:     todo.DueTimezone,
+		Recurrence:      todo.Recurrence,
+		RecurrenceStart: &start,
+		SeriesID:        &seriesID,
+		Tags:            m.storeTags(todo.Tags),
+		Version:         1,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+
+	nextID := m.lastID
+	return &nextID, nil
+}
+
+// getCompleted reads a todo after toggleComplete, along with the next
+// occurrence it created, if any
+func (m *MemoryTodoRepository) getCompleted(id int, nextID *int) (*Todo, error) {
+	todo, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if nextID != nil {
+		if todo.NextOccurrence, err = m.get(*nextID); err != nil {
+			return nil, err
+		}
+	}
+	return todo, nil
+}
+
+// checkVersion fails with ErrNotFound if the todo is missing or in the trash,
+// and with ErrVersionMismatch if a non-zero version is not its current one
+func (m *MemoryTodoRepository) checkVersion(id int, version int) error {
+	todo := m.live(id)
+	if todo == nil {
+		return ErrNotFound
+	}
+	if version != 0 && todo.Version != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// Delete moves a todo and its subtasks to the trash, or fails with ErrNotFound.
+// A non-zero version must match the todo's current version, or
+// ErrVersionMismatch is returned.
+func (m *MemoryTodoRepository) Delete(id int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := len(m.events)
+	if err := m.delete(id, version, time.Now().UTC()); err != nil {
+		return err
+	}
+	m.publish(from)
+
+	return nil
+}
+
+// delete moves a todo and its subtasks to the trash while the lock is held.
+// They all share one DeletedAt so they can be restored together.
+func (m *MemoryTodoRepository) delete(id int, version int, now time.Time) error {
+	if err := m.checkVersion(id, version); err != nil {
+		return err
+	}
+
+	ids := append([]int{id}, m.descendants(id, nil)...)
+	for _, trashed := range ids {
+		todo := cloneTodo(m.todos[trashed])
+		todo.DeletedAt = cloneTime(&now)
+		todo.Version++
+		m.put(todo)
+	}
+	m.record(EventDeleted, now, ids...)
+
+	return nil
+}
+
+// Restore takes a todo out of the trash, along with the subtasks that were
+// trashed with it. It fails with ErrNotFound if the todo is not in the trash.
+func (m *MemoryTodoRepository) Restore(id int) (*Todo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	trashed := m.todos[id]
+	if trashed == nil || trashed.DeletedAt == nil || !m.inWorkspace(trashed) {
+		return nil, ErrNotFound
+	}
+	if trashed.ParentID != nil {
+		if parent := m.todos[*trashed.ParentID]; parent != nil && parent.DeletedAt != nil {
+			return nil, ErrParentInTrash
+		}
+	}
+
+	deletedAt := *trashed.DeletedAt
+	ids := append([]int{id}, m.descendants(id, func(todo *Todo) bool {
+		return todo.DeletedAt != nil && todo.DeletedAt.Equal(deletedAt)
+	})...)
+
+	now := time.Now().UTC()
+	from := len(m.events)
+	for _, restored := range ids {
+		todo := cloneTodo(m.todos[restored])
+		todo.DeletedAt = nil
+		todo.Version++
+		m.put(todo)
+	}
+	m.record(EventRestored, now, ids...)
+	m.publish(from)
+
+	return m.get(id)
+}
+
+// Purge permanently deletes todos that were moved to the trash before the
+// given time, and returns how many were deleted. Their subtasks go with them
+// and, as in TodoModel, are not counted.
+func (m *MemoryTodoRepository) Purge(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := map[int]bool{}
+	for id, todo := range m.todos {
+		if todo.DeletedAt != nil && todo.DeletedAt.Before(before) && m.inWorkspace(todo) {
+			expired[id] = true
+		}
+	}
+
+	var purged int64
+	for id := range expired {
+		if todo := m.todos[id]; todo.ParentID == nil || !expired[*todo.ParentID] {
+			purged++
+		}
+		delete(m.todos, id)
+	}
+
+	// Remove subtasks whose parent is gone, and forget removed series
+	for removed := true; removed; {
+		removed = false
+		for id, todo := range m.todos {
+			if todo.ParentID != nil && m.todos[*todo.ParentID] == nil {
+				delete(m.todos, id)
+				removed = true
+			}
+		}
+	}
+	for id, todo := range m.todos {
+		if todo.SeriesID != nil && m.todos[*todo.SeriesID] == nil {
+			todo = cloneTodo(todo)
+			todo.SeriesID = nil
+			m.todos[id] = todo
+		}
+	}
+
+	return purged, nil
+}
+
+// Bulk runs the operations in order as a single unit. When atomic is true the
+// first failure rolls every operation back and only its result is filled in.
+// Otherwise each failed operation is rolled back on its own and the rest
+// still go ahead. It reports whether the operations were committed.
+func (m *MemoryTodoRepository) Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	from := len(m.events)
+	results := make([]BulkResult, len(ops))
+	if atomic {
+		m.begin()
+	}
+	for i, op := range ops {
+		if !atomic {
+			m.begin()
+		}
+
+		todo, err := m.runBulkOp(op, now)
+		if err != nil {
+			m.rollback()
+			if atomic {
+				failed := make([]BulkResult, len(ops))
+				failed[i].Err = err
+				return failed, false, nil
+			}
+			results[i].Err = err
+		} else if !atomic {
+			m.journal = nil
+		}
+		results[i].Todo = todo
+	}
+	m.journal = nil
+	m.publish(from)
+
+	return results, true, nil
+}
+
+// runBulkOp performs one bulk operation while the lock is held
+func (m *MemoryTodoRepository) runBulkOp(op BulkOperation, now time.Time) (*Todo, error) {
+	switch op.Op {
+	case BulkCreate:
+		return m.create(op.Create, now)
+	case BulkUpdate:
+		if err := m.update(op.ID, op.Update, op.Version, now); err != nil {
+			return nil, err
+		}
+		return m.get(op.ID)
+	case BulkDelete:
+		return nil, m.delete(op.ID, op.Version, now)
+	case BulkComplete:
+		if err := m.checkVersion(op.ID, op.Version); err != nil {
+			return nil, err
+		}
+		nextID, err := m.toggleComplete(op.ID, true, ChildPolicyIgnore, now)
+		if err != nil {
+			return nil, err
+		}
+		return m.getCompleted(op.ID, nextID)
+	}
+
+	return nil, newError(ErrValidation, "op", "unknown bulk operation "+string(op.Op))
+}
+
+// Subscribe returns a channel that receives every event published from now on
+func (m *MemoryTodoRepository) Subscribe(buffer int) (<-chan TodoEvent, func()) {
+	return m.Events.SubscribeMatching(buffer, workspaceMatch(m.workspaceID))
+}
+
+// EventsSince returns up to limit events logged after the given event ID, oldest first
+func (m *MemoryTodoRepository) EventsSince(after int64, limit int) ([]TodoEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := sort.Search(len(m.events), func(i int) bool { return m.events[i].ID > after })
+	events := make([]TodoEvent, 0)
+	for ; i < len(m.events) && len(events) < limit; i++ {
+		event := m.events[i]
+		if !m.inWorkspace(event.Todo) {
+			continue
+		}
+		event.Todo = cloneTodo(event.Todo)
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// LastEventID returns the ID of the newest event in the log, or 0 if it is empty
+func (m *MemoryTodoRepository) LastEventID() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.events) - 1; i >= 0; i-- {
+		if m.inWorkspace(m.events[i].Todo) {
+			return m.events[i].ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// PurgeEvents deletes the events logged before the given time and returns how many were removed
+func (m *MemoryTodoRepository) PurgeEvents(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.events[:0]
+	for _, event := range m.events {
+		if !event.CreatedAt.Before(before) || !m.inWorkspace(event.Todo) {
+			kept = append(kept, event)
+		}
+	}
+	purged := int64(len(m.events) - len(kept))
+	m.events = kept
+	return purged, nil
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// DefaultPageLimit is the page size used when the caller does not ask for one
+	DefaultPageLimit = 50
+	// MaxPageLimit is the largest page size a caller may request
+	MaxPageLimit = 100
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = newError(ErrValidation, "cursor", "invalid cursor")
+
+// ListParams controls which page of todos is returned by List
+type ListParams struct {
+	Limit        int
+	Cursor       string
+	IncludeTotal bool
+	Filter       TodoFilter
+	// Sort defaults to DefaultSort when empty
+	Sort []SortField
+}
+
+// TodoPage is a single page of todos plus the cursor for the next one
+type TodoPage struct {
+	Todos      []*Todo `json:"todos"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      *int    `json:"total,omitempty"`
+}
+
+// pageCursor is the decoded form of the opaque cursor handed to clients.
+// It holds the sort key values of the last todo on a page, and the sort
+// they belong to so a cursor cannot be replayed against a different order.
+type pageCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// encodeCursor builds the opaque cursor pointing just after the given todo
+func encodeCursor(fields []SortField, todo *Todo) string {
+	pc := pageCursor{Sort: sortSpec(fields)}
+	for _, f := range fields {
+		col, _ := lookupSortColumn(f.Field)
+		pc.Values = append(pc.Values, col.value(todo))
+	}
+
+	payload, _ := json.Marshal(pc)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// decodeCursor parses an opaque cursor produced by encodeCursor for the same sort
+func decodeCursor(cursor string, fields []SortField) ([]interface{}, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var pc pageCursor
+	if err := json.Unmarshal(payload, &pc); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if pc.Sort != sortSpec(fields) || len(pc.Values) != len(fields) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		col, _ := lookupSortColumn(f.Field)
+		v, ok := col.decode(pc.Values[i])
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// List retrieves a filtered, sorted page of todos.
+// Pages are addressed with keyset pagination so deep pages stay cheap.
+func (m *TodoModel) List(params ListParams) (*TodoPage, error) {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	sort := params.Sort
+	if len(sort) == 0 {
+		sort, _ = ParseSort(DefaultSort)
+	}
+	sort = withTiebreaker(sort)
+
+	params.Filter.workspaceID = m.WorkspaceID
+	conds, args := params.Filter.whereClause()
+	filterConds, filterArgs := conds, args
+
+	if params.Cursor != "" {
+		values, err := decodeCursor(params.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		keyset, keysetArgs := keysetClause(sort, values)
+		conds = append(append([]string{}, conds...), keyset)
+		args = append(append([]interface{}{}, args...), keysetArgs...)
+	}
+
+	query := `
+		SELECT ` + todoColumns + `
+		FROM todos
+	`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+
+	// Fetch one extra row to find out whether another page follows
+	query += ` ORDER BY ` + orderByClause(sort) + ` LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	todos := make([]*Todo, 0, limit)
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &TodoPage{Todos: todos}
+	if len(todos) > limit {
+		page.Todos = todos[:limit]
+		page.NextCursor = encodeCursor(sort, page.Todos[limit-1])
+	}
+
+	if err := loadTags(m.DB, page.Todos); err != nil {
+		return nil, err
+	}
+
+	if params.IncludeTotal {
+		total, err := m.count(filterConds, filterArgs)
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// Count returns the number of todos matching the filter
+func (m *TodoModel) Count(filter TodoFilter) (int, error) {
+	filter.workspaceID = m.WorkspaceID
+	conds, args := filter.whereClause()
+	return m.count(conds, args)
+}
+
+// count runs a COUNT query over todos restricted by the given conditions
+func (m *TodoModel) count(conds []string, args []interface{}) (int, error) {
+	query := `SELECT COUNT(*) FROM todos`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	err := m.DB.QueryRow(query, args...).Scan(&total)
+	return total, err
+}
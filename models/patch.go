@@ -0,0 +1,74 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// ErrInvalidPatch is returned when a patch document is malformed or cannot be applied
+var ErrInvalidPatch = newError(ErrValidation, "", "invalid patch")
+
+// ErrPatchTestFailed is returned when a JSON Patch "test" operation does not match
+var ErrPatchTestFailed = newError(ErrConflict, "", "patch test operation failed")
+
+const (
+	// MergePatchType is the media type of an RFC 7396 JSON Merge Patch
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of an RFC 6902 JSON Patch
+	JSONPatchType = "application/json-patch+json"
+)
+
+// ApplyPatch applies a merge patch or JSON patch, as named by patchType, to
+// the writable fields of a todo, laid out as an UpdateTodoRequest, and returns
+// the patched request. Fields the patch does not touch keep their current values.
+func ApplyPatch(todo *Todo, patchType string, patch []byte) (UpdateTodoRequest, error) {
+	doc, err := json.Marshal(UpdateTodoRequest{
+		Title:       todo.Title,
+		Description: todo.Description,
+		Priority:    todo.Priority,
+		ProjectID:   todo.ProjectID,
+		ParentID:    todo.ParentID,
+		DueAt:       todo.DueAt,
+		DueTimezone: todo.DueTimezone,
+		Recurrence:  todo.Recurrence,
+		Tags:        todo.Tags,
+	})
+	if err != nil {
+		return UpdateTodoRequest{}, err
+	}
+
+	switch patchType {
+	case MergePatchType:
+		if !json.Valid(patch) || !bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")) {
+			return UpdateTodoRequest{}, fmt.Errorf("%w: merge patch must be a JSON object", ErrInvalidPatch)
+		}
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	case JSONPatchType:
+		var ops jsonpatch.Patch
+		if ops, err = jsonpatch.DecodePatch(patch); err == nil {
+			doc, err = ops.Apply(doc)
+		}
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return UpdateTodoRequest{}, ErrPatchTestFailed
+		}
+	default:
+		return UpdateTodoRequest{}, fmt.Errorf("%w: unsupported patch type %q", ErrInvalidPatch, patchType)
+	}
+	if err != nil {
+		return UpdateTodoRequest{}, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	// Anything outside the writable fields, such as id or completed, is refused
+	var patched UpdateTodoRequest
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return UpdateTodoRequest{}, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	return patched, nil
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Priority ranks how urgent a todo is. It is stored as an integer so todos
+// sort naturally, and exchanged in JSON by name.
+type Priority int
+
+// Priority levels, from lowest to highest
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+// priorityNames maps each priority level to its JSON name
+var priorityNames = [...]string{"none", "low", "medium", "high", "urgent"}
+
+// String returns the name of the priority level
+func (p Priority) String() string {
+	if p < PriorityNone || p > PriorityUrgent {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority returns the priority level with the given name
+func ParsePriority(name string) (Priority, error) {
+	for i, n := range priorityNames {
+		if n == name {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNone, fmt.Errorf("unknown priority %q", name)
+}
+
+// MarshalJSON encodes the priority as its name
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON decodes a priority name, rejecting unknown levels
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return errors.New("priority must be one of none, low, medium, high, urgent")
+	}
+
+	parsed, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+
+	*p = parsed
+	return nil
+}
+
+// SetPriorityRequest represents the request body for changing a todo's priority
+type SetPriorityRequest struct {
+	Priority *Priority `json:"priority" binding:"required"`
+}
+
+// SetPriority changes the priority of a todo, or fails with ErrNotFound
+func (m *TodoModel) SetPriority(id int, priority Priority) (*Todo, error) {
+	query := `
+		UPDATE todos 
+		SET priority = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWorkspace(tx, m.WorkspaceID, id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	result, err := tx.Exec(query, priority, now, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	events := &eventLog{}
+	if err := events.record(tx, EventUpdated, now, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.publish(events)
+
+	// Return the updated todo
+	return m.GetByID(id)
+}
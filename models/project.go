@@ -0,0 +1,260 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrUnknownProject is returned when a todo refers to a project that does not exist
+var ErrUnknownProject = newError(ErrValidation, "project_id", "project not found")
+
+// ProjectDeleteMode decides what happens to a project's todos when it is deleted
+type ProjectDeleteMode string
+
+const (
+	// ProjectDeleteInbox moves the project's todos to the inbox (no project)
+	ProjectDeleteInbox ProjectDeleteMode = "inbox"
+	// ProjectDeleteCascade deletes the project's todos along with it
+	ProjectDeleteCascade ProjectDeleteMode = "cascade"
+)
+
+// Project groups related todos into a list
+type Project struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// WorkspaceID is the workspace the project belongs to; projects created
+	// before workspaces existed may have none
+	WorkspaceID *int      `json:"workspace_id"`
+	Color       string    `json:"color"`
+	Archived    bool      `json:"archived"`
+	SortOrder   int       `json:"sort_order"`
+	TodoCount   int       `json:"todo_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProjectRequest represents the request body for creating or updating a project
+type ProjectRequest struct {
+	Name      string `json:"name" binding:"required,max=100"`
+	Color     string `json:"color" binding:"omitempty,hexcolor"`
+	Archived  bool   `json:"archived"`
+	SortOrder int    `json:"sort_order"`
+}
+
+// ProjectModel handles database operations for projects
+type ProjectModel struct {
+	DB *sql.DB
+	// Events receives an event for every todo a change to a project moves
+	// or trashes, once it is committed
+	Events *EventBroker
+	// WorkspaceID limits the model to the projects of one workspace, and
+	// gives new projects to it. AllWorkspaces means every project.
+	WorkspaceID int
+}
+
+// NewProjectModel creates a new ProjectModel instance publishing todo
+// events to events, which is normally the broker of the TodoModel
+func NewProjectModel(db *sql.DB, events *EventBroker) *ProjectModel {
+	return &ProjectModel{DB: db, Events: events, WorkspaceID: AllWorkspaces}
+}
+
+// ForWorkspace returns a ProjectModel limited to the projects of one workspace
+func (m *ProjectModel) ForWorkspace(workspaceID int) *ProjectModel {
+	return &ProjectModel{DB: m.DB, Events: m.Events, WorkspaceID: workspaceID}
+}
+
+// projectColumns selects a project along with the number of todos in it
+const projectColumns = `
+	projects.id, projects.name, projects.workspace_id, projects.color, projects.archived, projects.sort_order,
+	(SELECT COUNT(*) FROM todos WHERE todos.project_id = projects.id AND todos.deleted_at IS NULL),
+	projects.created_at, projects.updated_at
+`
+
+// scanProject reads a project selected with projectColumns
+func scanProject(row rowScanner) (*Project, error) {
+	project := &Project{}
+	var workspaceID sql.NullInt64
+	err := row.Scan(
+		&project.ID,
+		&project.Name,
+		&workspaceID,
+		&project.Color,
+		&project.Archived,
+		&project.SortOrder,
+		&project.TodoCount,
+		&project.CreatedAt,
+		&project.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		project.WorkspaceID = &id
+	}
+	return project, nil
+}
+
+// GetAll retrieves projects in their display order, optionally including archived ones
+func (m *ProjectModel) GetAll(includeArchived bool) ([]*Project, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE ` + workspace
+	if !includeArchived {
+		query += ` AND archived = FALSE`
+	}
+	query += ` ORDER BY sort_order, id`
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := make([]*Project, 0)
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return projects, nil
+}
+
+// GetByID retrieves a project by its ID, or fails with ErrNotFound
+func (m *ProjectModel) GetByID(id int) (*Project, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	project, err := scanProject(m.DB.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE id = ? AND `+workspace, append([]interface{}{id}, args...)...))
+	return project, notFound(err)
+}
+
+// Create inserts a new project
+func (m *ProjectModel) Create(req ProjectRequest) (*Project, error) {
+	query := `
+		INSERT INTO projects (name, workspace_id, color, archived, sort_order, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+
+	owner, err := ownerWorkspace(m.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var id int64
+	err = m.DB.QueryRow(query, req.Name, owner, req.Color, req.Archived, req.SortOrder, now, now).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Project{
+		ID:          int(id),
+		Name:        req.Name,
+		WorkspaceID: owner,
+		Color:       req.Color,
+		Archived:    req.Archived,
+		SortOrder:   req.SortOrder,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// Update modifies an existing project
+func (m *ProjectModel) Update(id int, req ProjectRequest) (*Project, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	query := `
+		UPDATE projects
+		SET name = ?, color = ?, archived = ?, sort_order = ?, updated_at = ?
+		WHERE id = ? AND ` + workspace + `
+	`
+
+	result, err := m.DB.Exec(query, append([]interface{}{req.Name, req.Color, req.Archived, req.SortOrder, time.Now().UTC(), id}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	// Return the updated project
+	return m.GetByID(id)
+}
+
+// Delete removes a project. Its todos are either moved to the inbox or
+// moved to the trash along with it, depending on mode. Trashed todos lose
+// their project, so restoring them puts them in the inbox.
+func (m *ProjectModel) Delete(id int, mode ProjectDeleteMode) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Checked first, so the todos of another workspace's project are left alone
+	if err := checkProjectExists(tx, m.WorkspaceID, &id); err != nil {
+		if errors.Is(err, ErrUnknownProject) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	var ids []int
+	eventType := EventDeleted
+	if mode == ProjectDeleteCascade {
+		ids, err = trashTodos(tx, `project_id = ?`, []interface{}{id}, now)
+	} else {
+		eventType = EventUpdated
+		ids, err = queryIDs(tx, `UPDATE todos SET project_id = NULL, version = version + 1, updated_at = ? WHERE project_id = ? RETURNING id`, now, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM projects WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	// Recorded once the project is gone, so the events show the todos without it
+	events := &eventLog{}
+	if err := events.record(tx, eventType, now, ids...); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	events.publishTo(m.Events)
+
+	return nil
+}
+
+// checkProjectExists returns ErrUnknownProject unless projectID is nil or
+// names a project of the workspace. Every project passes for AllWorkspaces.
+func checkProjectExists(db dbtx, workspace int, projectID *int) error {
+	if projectID == nil {
+		return nil
+	}
+
+	cond, args := workspaceClause(workspace)
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM projects WHERE id = ? AND `+cond, append([]interface{}{*projectID}, args...)...).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrUnknownProject
+	}
+
+	return nil
+}
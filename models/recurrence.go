@@ -0,0 +1,169 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// ErrInvalidRecurrence is returned when a recurrence rule cannot be used
+var ErrInvalidRecurrence = newError(ErrValidation, "recurrence", "invalid recurrence")
+
+// MaxOccurrencePreview is the largest number of occurrences PreviewOccurrences returns
+const MaxOccurrencePreview = 100
+
+// parseRecurrence parses an RFC 5545 RRULE such as "FREQ=WEEKLY;BYDAY=MO,TH".
+// The rule's DTSTART is always the series' first due date, so it may not be
+// given in the rule itself, and rules more frequent than hourly are refused.
+func parseRecurrence(rule string) (*rrule.ROption, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	if strings.Contains(rule, "\n") {
+		return nil, fmt.Errorf("%w: only a single RRULE line is supported", ErrInvalidRecurrence)
+	}
+
+	opt, err := rrule.StrToROption(rule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecurrence, err)
+	}
+	if !opt.Dtstart.IsZero() {
+		return nil, fmt.Errorf("%w: DTSTART is taken from due_at", ErrInvalidRecurrence)
+	}
+	if opt.Freq == rrule.MINUTELY || opt.Freq == rrule.SECONDLY {
+		return nil, fmt.Errorf("%w: FREQ must be HOURLY or less frequent", ErrInvalidRecurrence)
+	}
+
+	return opt, nil
+}
+
+// normalizeRecurrence validates the recurrence of a create or update request
+// and returns the rule in its stored form, without an "RRULE:" prefix
+func normalizeRecurrence(rule string, dueAt *time.Time) (string, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	if rule == "" {
+		return "", nil
+	}
+	if dueAt == nil {
+		return "", fmt.Errorf("%w: recurring todos need a due_at", ErrInvalidRecurrence)
+	}
+	if _, err := parseRecurrence(rule); err != nil {
+		return "", err
+	}
+	return rule, nil
+}
+
+// recurrenceRule builds the rule for a todo's series, anchored at the series
+// start in the todo's timezone so wall-clock times survive DST changes
+func recurrenceRule(todo *Todo) (*rrule.RRule, error) {
+	opt, err := parseRecurrence(todo.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	start := todo.RecurrenceStart
+	if start == nil {
+		start = todo.DueAt
+	}
+	if start == nil {
+		return nil, fmt.Errorf("%w: recurring todos need a due_at", ErrInvalidRecurrence)
+	}
+
+	opt.Dtstart = *localDueAt(*start, todo.DueTimezone)
+	return rrule.NewRRule(*opt)
+}
+
+// PreviewOccurrences returns up to count due dates of a recurring todo,
+// starting with its current due date
+func (m *TodoModel) PreviewOccurrences(id int, count int) ([]time.Time, error) {
+	todo, err := m.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return previewOccurrences(todo, count)
+}
+
+// previewOccurrences lists the due dates of a todo that has already been read
+func previewOccurrences(todo *Todo, count int) ([]time.Time, error) {
+	if count <= 0 || count > MaxOccurrencePreview {
+		count = MaxOccurrencePreview
+	}
+	if todo.Recurrence == "" {
+		return nil, fmt.Errorf("%w: todo does not recur", ErrInvalidRecurrence)
+	}
+
+	rule, err := recurrenceRule(todo)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := make([]time.Time, 0, count)
+	next := rule.After(*todo.DueAt, true)
+	for !next.IsZero() && len(occurrences) < count {
+		occurrences = append(occurrences, next)
+		next = rule.After(next, false)
+	}
+
+	return occurrences, nil
+}
+
+// occurrenceAfter works out the occurrence that follows a recurring todo. It
+// returns the next due date, which is zero when the series has ended, and the
+// series ID and start the next occurrence carries.
+func occurrenceAfter(todo *Todo) (time.Time, int, time.Time, error) {
+	rule, err := recurrenceRule(todo)
+	if err != nil {
+		return time.Time{}, 0, time.Time{}, err
+	}
+
+	seriesID := todo.ID
+	if todo.SeriesID != nil {
+		seriesID = *todo.SeriesID
+	}
+	start := todo.DueAt
+	if todo.RecurrenceStart != nil {
+		start = todo.RecurrenceStart
+	}
+
+	return rule.After(*todo.DueAt, false), seriesID, start.UTC(), nil
+}
+
+// spawnNextOccurrence creates the todo for the occurrence after the given
+// recurring todo, carrying its details, tags and rule forward. The finished
+// todo keeps its place in the series but stops recurring, so completing it
+// again cannot spawn a second copy. It returns nil when the series has ended.
+func spawnNextOccurrence(db dbtx, todo *Todo, now time.Time) (*int, error) {
+	next, seriesID, start, err := occurrenceAfter(todo)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`UPDATE todos SET recurrence = '', series_id = ? WHERE id = ?`, seriesID, todo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if next.IsZero() {
+		return nil, nil
+	}
+
+	query := `
+		INSERT INTO todos (
+			title, description, completed, priority, project_id, parent_id, due_at, due_timezone,
+			recurrence, recurrence_start, series_id, workspace_id, created_at, updated_at
+		)
+		VALUES (?, ?, FALSE, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	var nextID int
+	err = db.QueryRow(query, todo.Title, todo.Description, todo.Priority, todo.ProjectID, todo.ParentID,
+		next.UTC(), todo.DueTimezone, todo.Recurrence, start, seriesID, todo.WorkspaceID, now, now).Scan(&nextID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := setTodoTags(db, nextID, todo.Tags); err != nil {
+		return nil, err
+	}
+
+	return &nextID, nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// TodoRepository stores todos. TodoModel keeps them in SQLite or Postgres and
+// MemoryTodoRepository keeps them in memory; every implementation must pass
+// the conformance suite in tests/repository_test.go.
+type TodoRepository interface {
+	// ForWorkspace returns a repository limited to the todos of one
+	// workspace, which holds every todo created through it. Todos of other
+	// workspaces are not found.
+	ForWorkspace(workspaceID int) TodoRepository
+
+	// Create inserts a new todo
+	Create(req CreateTodoRequest) (*Todo, error)
+	// GetByID retrieves a todo outside the trash, or fails with ErrNotFound
+	GetByID(id int) (*Todo, error)
+	// GetAll retrieves every todo outside the trash, newest first
+	GetAll() ([]*Todo, error)
+	// List retrieves a filtered, sorted page of todos
+	List(params ListParams) (*TodoPage, error)
+	// Count returns the number of todos matching the filter
+	Count(filter TodoFilter) (int, error)
+	// Search finds todos matching a full-text query, best match first. It
+	// fails with ErrSearchUnavailable if the backend cannot search.
+	Search(q string, limit int) ([]*SearchResult, error)
+	// GetChildren retrieves the direct subtasks of a todo, oldest first
+	GetChildren(id int) ([]*Todo, error)
+	// GetTree retrieves every subtask below a todo, nested through Children
+	GetTree(id int) ([]*Todo, error)
+	// PreviewOccurrences returns up to count due dates of a recurring todo
+	PreviewOccurrences(id int, count int) ([]time.Time, error)
+	// Update replaces a todo; a non-zero version must be its current one
+	Update(id int, req UpdateTodoRequest, version int) (*Todo, error)
+	// SetPriority changes the priority of a todo
+	SetPriority(id int, priority Priority) (*Todo, error)
+	// ToggleComplete completes or reopens a todo
+	ToggleComplete(id int, completed bool, policy ChildPolicy) (*Todo, error)
+	// Delete moves a todo and its subtasks to the trash; a non-zero version
+	// must be its current one
+	Delete(id int, version int) error
+	// Restore takes a todo and the subtasks trashed with it out of the trash
+	Restore(id int) (*Todo, error)
+	// Purge permanently deletes todos trashed before the given time
+	Purge(before time.Time) (int64, error)
+	// Bulk runs several writes in one transaction
+	Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, bool, error)
+
+	// Subscribe returns a channel of the events published from now on, as
+	// EventBroker.Subscribe does
+	Subscribe(buffer int) (<-chan TodoEvent, func())
+	// EventsSince returns up to limit events logged after the given event ID
+	EventsSince(after int64, limit int) ([]TodoEvent, error)
+	// LastEventID returns the ID of the newest event, or 0 if there is none
+	LastEventID() (int64, error)
+	// PurgeEvents deletes the events logged before the given time
+	PurgeEvents(before time.Time) (int64, error)
+}
+
+// TodoModel, MemoryTodoRepository and RoleGuard are all TodoRepositories
+var (
+	_ TodoRepository = (*TodoModel)(nil)
+	_ TodoRepository = (*MemoryTodoRepository)(nil)
+	_ TodoRepository = (*RoleGuard)(nil)
+)
@@ -0,0 +1,446 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// ErrSearchUnavailable is returned when the database has no full-text index
+var ErrSearchUnavailable = errors.New("full-text search is not available")
+
+// SearchResult is a todo matched by a full-text query
+type SearchResult struct {
+	*Todo
+	// Score ranks the match: the negated bm25 rank in SQLite and ts_rank in
+	// Postgres. Higher scores are better matches.
+	Score float64 `json:"score"`
+	// TitleHighlight is the HTML-escaped title with matched terms wrapped in
+	// <mark> tags
+	TitleHighlight string `json:"title_highlight"`
+	// Snippet is an HTML-escaped fragment of the description around the
+	// matched terms, marked up the same way
+	Snippet string `json:"snippet"`
+}
+
+// FTS5 wraps matches in these control characters rather than in <mark> tags,
+// so the text can be escaped before the tags are added
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// Search finds todos whose title or description matches the query, best match first.
+//
+// The query is a list of terms that must all match. A term ending in "*" is a
+// prefix query and text wrapped in double quotes is matched as a phrase.
+func (m *TodoModel) Search(q string, limit int) ([]*SearchResult, error) {
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	search := m.searchSQLite
+	if isPostgres(m.DB) {
+		search = m.searchPostgres
+	}
+	results, err := search(q, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	todos := make([]*Todo, len(results))
+	for i, result := range results {
+		todos[i] = result.Todo
+	}
+	if err := loadTags(m.DB, todos); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchSQLite runs a search against the FTS5 index
+func (m *TodoModel) searchSQLite(q string, limit int) ([]*SearchResult, error) {
+	terms, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	match := buildMatchQuery(terms)
+
+	var exists int
+	err = m.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'todos_fts'`).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrSearchUnavailable
+	}
+
+	// Title matches weigh ten times as much as description matches
+	workspace, args := workspaceClause(m.WorkspaceID)
+	query := `
+		SELECT ` + todoColumns + `, fts.score, fts.title_highlight, fts.snippet
+		FROM todos
+		JOIN (
+			SELECT rowid,
+				-bm25(todos_fts, 10.0, 1.0) AS score,
+				highlight(todos_fts, 0, char(2), char(3)) AS title_highlight,
+				snippet(todos_fts, 1, char(2), char(3), '…', 16) AS snippet
+			FROM todos_fts
+			WHERE todos_fts MATCH ?
+		) fts ON fts.rowid = todos.id
+		WHERE todos.deleted_at IS NULL AND ` + workspace + `
+		ORDER BY fts.score DESC, id DESC
+		LIMIT ?
+	`
+
+	args = append(append([]interface{}{match}, args...), limit)
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*SearchResult, 0)
+	for rows.Next() {
+		result := &SearchResult{}
+		todo, err := scanTodo(rows, &result.Score, &result.TitleHighlight, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		result.Todo = todo
+
+		// Text that holds the marker characters itself is highlighted in Go
+		// instead, as its markers cannot be told apart from FTS5's
+		if strings.ContainsAny(todo.Title+todo.Description, markStart+markEnd) {
+			highlightTodo(result, terms)
+		} else {
+			result.TitleHighlight = markMatches(result.TitleHighlight)
+			result.Snippet = markMatches(result.Snippet)
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// markMatches escapes text highlighted by FTS5 and turns its markers into
+// <mark> tags
+func markMatches(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, markStart, "<mark>")
+	return strings.ReplaceAll(text, markEnd, "</mark>")
+}
+
+// highlightTodo fills in a result's highlights the way MemoryTodoRepository
+// does it
+func highlightTodo(result *SearchResult, terms []searchTerm) {
+	// The database tokenizer and searchWords can disagree on odd input, in
+	// which case the todo is returned without highlights
+	if matched := searchTodo(result.Todo, terms); matched != nil {
+		result.TitleHighlight, result.Snippet = matched.TitleHighlight, matched.Snippet
+		return
+	}
+	result.TitleHighlight = html.EscapeString(result.Todo.Title)
+	result.Snippet = searchSnippet(result.Todo.Description, searchWords(result.Todo.Description), nil)
+}
+
+// searchPostgres runs a search against the todos.search tsvector column.
+// Postgres has no equivalent of FTS5's highlight and snippet functions, so
+// matches are highlighted the way MemoryTodoRepository does it.
+func (m *TodoModel) searchPostgres(q string, limit int) ([]*SearchResult, error) {
+	terms, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0)
+	tsquery := buildTSQuery(terms)
+	if tsquery == "" {
+		return results, nil
+	}
+
+	// The title is weighted A and the description D, which ts_rank weighs
+	// ten times apart
+	workspace, args := workspaceClause(m.WorkspaceID)
+	query := `
+		SELECT ` + todoColumns + `, ts_rank(search, query) AS score
+		FROM todos, to_tsquery('simple', ?) AS query
+		WHERE deleted_at IS NULL AND search @@ query AND ` + workspace + `
+		ORDER BY score DESC, id DESC
+		LIMIT ?
+	`
+
+	args = append(append([]interface{}{tsquery}, args...), limit)
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result := &SearchResult{}
+		todo, err := scanTodo(rows, &result.Score)
+		if err != nil {
+			return nil, err
+		}
+		result.Todo = todo
+		highlightTodo(result, terms)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// isPostgres reports whether db is a Postgres database rather than SQLite
+func isPostgres(db *sql.DB) bool {
+	_, ok := db.Driver().(*pq.Driver)
+	return ok
+}
+
+// searchTerm is one term or quoted phrase of a search query
+type searchTerm struct {
+	text string
+	// prefix matches words that start with the term's last word
+	prefix bool
+}
+
+// parseSearchQuery splits user input into the terms that must all match.
+// A term ending in "*" is a prefix query and text wrapped in double quotes
+// is a phrase.
+func parseSearchQuery(q string) ([]searchTerm, error) {
+	var terms []searchTerm
+	rest := strings.TrimSpace(q)
+
+	for rest != "" {
+		var text string
+		if rest[0] == '"' {
+			// A phrase runs to the closing quote, or to the end of the input
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				text, rest = rest[1:], ""
+			} else {
+				text, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(rest, " \t\n\"")
+			if end < 0 {
+				end = len(rest)
+			}
+			text, rest = rest[:end], rest[end:]
+		}
+
+		prefix := false
+		if strings.HasPrefix(rest, "*") {
+			prefix, rest = true, rest[1:]
+		}
+		if strings.HasSuffix(text, "*") {
+			prefix, text = true, strings.TrimRight(text, "*")
+		}
+		rest = strings.TrimSpace(rest)
+
+		text = strings.TrimSpace(strings.ReplaceAll(text, `"`, ""))
+		if text == "" {
+			continue
+		}
+		terms = append(terms, searchTerm{text: text, prefix: prefix})
+	}
+
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%w: empty search query", ErrInvalidFilter)
+	}
+
+	return terms, nil
+}
+
+// buildMatchQuery turns search terms into a safe FTS5 MATCH expression.
+// Every term and phrase is quoted so FTS5 operators and punctuation in the
+// input are treated as text, while a trailing "*" still requests a prefix match.
+func buildMatchQuery(terms []searchTerm) string {
+	tokens := make([]string, len(terms))
+	for i, term := range terms {
+		tokens[i] = `"` + term.text + `"`
+		if term.prefix {
+			tokens[i] += "*"
+		}
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// buildTSQuery turns search terms into a Postgres tsquery. Only the words of
+// each term are kept, so tsquery operators in the input are dropped, and the
+// words of a phrase must follow each other.
+func buildTSQuery(terms []searchTerm) string {
+	var parts []string
+	for _, term := range terms {
+		words := searchWords(term.text)
+		if len(words) == 0 {
+			continue
+		}
+
+		texts := make([]string, len(words))
+		for i, word := range words {
+			texts[i] = word.text
+		}
+		part := strings.Join(texts, " <-> ")
+		if term.prefix {
+			part += ":*"
+		}
+		parts = append(parts, "("+part+")")
+	}
+
+	return strings.Join(parts, " & ")
+}
+
+// searchWord is a word of searched text, lower cased, with its byte offsets
+type searchWord struct {
+	text       string
+	start, end int
+}
+
+// searchWords splits text into words the way the FTS5 unicode61 tokenizer
+// does: runs of letters and digits, compared without case
+func searchWords(text string) []searchWord {
+	var words []searchWord
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			words = append(words, searchWord{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, searchWord{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return words
+}
+
+// matchTerm returns the byte ranges of text where a term matches
+func matchTerm(words []searchWord, term []searchWord, prefix bool) [][2]int {
+	var spans [][2]int
+	for i := 0; i+len(term) <= len(words); i++ {
+		matched := true
+		for j, want := range term {
+			got := words[i+j].text
+			if prefix && j == len(term)-1 {
+				matched = strings.HasPrefix(got, want.text)
+			} else {
+				matched = got == want.text
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			spans = append(spans, [2]int{words[i].start, words[i+len(term)-1].end})
+		}
+	}
+	return spans
+}
+
+// searchTodo matches a todo against every search term, or returns nil if one
+// of them is not found in its title or description
+func searchTodo(todo *Todo, terms []searchTerm) *SearchResult {
+	titleWords := searchWords(todo.Title)
+	descriptionWords := searchWords(todo.Description)
+
+	var titleSpans, descriptionSpans [][2]int
+	searched := false
+	for _, term := range terms {
+		termWords := searchWords(term.text)
+		if len(termWords) == 0 {
+			continue
+		}
+		searched = true
+		inTitle := matchTerm(titleWords, termWords, term.prefix)
+		inDescription := matchTerm(descriptionWords, termWords, term.prefix)
+		if len(inTitle) == 0 && len(inDescription) == 0 {
+			return nil
+		}
+		titleSpans = append(titleSpans, inTitle...)
+		descriptionSpans = append(descriptionSpans, inDescription...)
+	}
+
+	if !searched {
+		return nil
+	}
+
+	return &SearchResult{
+		Score:          float64(10*len(titleSpans) + len(descriptionSpans)),
+		TitleHighlight: highlightSpans(todo.Title, 0, len(todo.Title), titleSpans),
+		Snippet:        searchSnippet(todo.Description, descriptionWords, descriptionSpans),
+	}
+}
+
+// searchSnippet returns up to 16 words of the description, starting near the
+// first match, with matches highlighted and "…" marking cut text
+func searchSnippet(description string, words []searchWord, spans [][2]int) string {
+	const snippetWords = 16
+	if len(words) == 0 {
+		return ""
+	}
+
+	first := 0
+	if len(spans) > 0 {
+		sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+		for first < len(words) && words[first].start < spans[0][0] {
+			first++
+		}
+		if first < snippetWords {
+			first = 0
+		}
+	}
+	last := first + snippetWords
+	if last > len(words) {
+		last = len(words)
+	}
+
+	start, end := 0, len(description)
+	if first > 0 {
+		start = words[first].start
+	}
+	if last < len(words) {
+		end = words[last-1].end
+	}
+
+	snippet := highlightSpans(description, start, end, spans)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(description) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// highlightSpans returns text[start:end], HTML-escaped, with the spans inside
+// it wrapped in <mark> tags
+func highlightSpans(text string, start, end int, spans [][2]int) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	var b strings.Builder
+	pos := start
+	for _, span := range spans {
+		if span[0] < pos || span[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:span[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[span[0]:span[1]]) + "</mark>")
+		pos = span[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	return b.String()
+}
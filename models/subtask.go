@@ -0,0 +1,185 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrUnknownParent is returned when a todo refers to a parent that does not exist
+var ErrUnknownParent = newError(ErrValidation, "parent_id", "parent todo not found")
+
+// ErrCycle is returned when a parent assignment would make a todo its own ancestor
+var ErrCycle = newError(ErrValidation, "parent_id", "parent would create a cycle")
+
+// ErrOpenChildren is returned when completing a todo is refused because of open subtasks
+var ErrOpenChildren = newError(ErrConflict, "", "todo has open subtasks")
+
+// ChildPolicy decides what completing a todo does to its subtasks
+type ChildPolicy string
+
+const (
+	// ChildPolicyIgnore completes the todo and leaves its subtasks alone
+	ChildPolicyIgnore ChildPolicy = "ignore"
+	// ChildPolicyCascade completes the todo and every subtask below it
+	ChildPolicyCascade ChildPolicy = "cascade"
+	// ChildPolicyRefuse refuses to complete the todo while any subtask is open
+	ChildPolicyRefuse ChildPolicy = "refuse"
+)
+
+// descendantsCTE selects the IDs of every todo below the todo bound to its
+// placeholder, leaving out subtasks in the trash
+const descendantsCTE = `
+	WITH RECURSIVE descendants (id) AS (
+		SELECT id FROM todos WHERE parent_id = ? AND deleted_at IS NULL
+		UNION
+		SELECT todos.id FROM todos JOIN descendants ON todos.parent_id = descendants.id
+		WHERE todos.deleted_at IS NULL
+	)
+`
+
+// checkParent returns an error unless parentID is nil, names an existing todo
+// of the same workspace outside the trash, and is not the todo itself or one of
+// its descendants
+func checkParent(db dbtx, workspace int, id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+
+	cond, args := workspaceClause(workspace)
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM todos WHERE id = ? AND deleted_at IS NULL AND `+cond,
+		append([]interface{}{*parentID}, args...)...).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrUnknownParent
+	}
+
+	// New todos have no descendants, so only existing ones can form a cycle
+	if id == 0 {
+		return nil
+	}
+	if *parentID == id {
+		return ErrCycle
+	}
+
+	var cycles int
+	err = db.QueryRow(descendantsCTE+`SELECT COUNT(*) FROM descendants WHERE id = ?`, id, *parentID).Scan(&cycles)
+	if err != nil {
+		return err
+	}
+	if cycles > 0 {
+		return ErrCycle
+	}
+
+	return nil
+}
+
+// GetChildren retrieves the direct subtasks of a todo, oldest first
+func (m *TodoModel) GetChildren(id int) ([]*Todo, error) {
+	if err := checkWorkspace(m.DB, m.WorkspaceID, id); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + todoColumns + `
+		FROM todos WHERE parent_id = ? AND deleted_at IS NULL
+		ORDER BY created_at, id
+	`
+
+	return m.queryTodos(query, id)
+}
+
+// GetTree retrieves every subtask below a todo, nested under its parent through
+// the Children field. The direct subtasks are returned, oldest first.
+func (m *TodoModel) GetTree(id int) ([]*Todo, error) {
+	if err := checkWorkspace(m.DB, m.WorkspaceID, id); err != nil {
+		return nil, err
+	}
+
+	query := descendantsCTE + `
+		SELECT ` + todoColumns + `
+		FROM todos WHERE id IN (SELECT id FROM descendants)
+		ORDER BY created_at, id
+	`
+
+	todos, err := m.queryTodos(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*Todo, len(todos))
+	for _, todo := range todos {
+		todo.Children = []*Todo{}
+		byID[todo.ID] = todo
+	}
+
+	roots := make([]*Todo, 0)
+	for _, todo := range todos {
+		if *todo.ParentID == id {
+			roots = append(roots, todo)
+		} else {
+			parent := byID[*todo.ParentID]
+			parent.Children = append(parent.Children, todo)
+		}
+	}
+
+	return roots, nil
+}
+
+// queryTodos runs a query selecting todoColumns and returns the todos with their tags
+func (m *TodoModel) queryTodos(query string, args ...interface{}) ([]*Todo, error) {
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	todos := make([]*Todo, 0)
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadTags(m.DB, todos); err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// completeChildren applies a ChildPolicy to the subtasks of a todo being
+// completed, and returns the IDs of the subtasks it completed
+func completeChildren(db dbtx, id int, policy ChildPolicy, now time.Time) ([]int, error) {
+	switch policy {
+	case ChildPolicyRefuse:
+		var open int
+		query := descendantsCTE + `SELECT COUNT(*) FROM todos WHERE id IN (SELECT id FROM descendants) AND completed = FALSE`
+		if err := db.QueryRow(query, id).Scan(&open); err != nil {
+			return nil, err
+		}
+		if open > 0 {
+			return nil, ErrOpenChildren
+		}
+	case ChildPolicyCascade:
+		query := descendantsCTE + `
+			UPDATE todos SET completed = TRUE, version = version + 1, updated_at = ?
+			WHERE id IN (SELECT id FROM descendants) AND completed = FALSE
+			RETURNING id
+		`
+		return queryIDs(db, query, id, now)
+	case ChildPolicyIgnore, "":
+	default:
+		return nil, fmt.Errorf("unknown child policy %q", policy)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,419 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrTagExists is returned when a tag name is already taken by another tag of the workspace
+var ErrTagExists = newError(ErrConflict, "name", "tag already exists")
+
+// ErrInvalidTagName is returned when a tag name is blank
+var ErrInvalidTagName = newError(ErrValidation, "name", "tag name must not be blank")
+
+// ErrMergeIntoSelf is returned when a tag is merged into itself
+var ErrMergeIntoSelf = newError(ErrValidation, "target_id", "cannot merge a tag into itself")
+
+// Tag represents a label that can be attached to any number of todos. Tag
+// names are unique within a workspace.
+type Tag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// WorkspaceID is the workspace the tag belongs to; tags of todos outside
+	// every workspace have none
+	WorkspaceID *int      `json:"workspace_id"`
+	TodoCount   int       `json:"todo_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TagRequest represents the request body for creating or renaming a tag
+type TagRequest struct {
+	Name string `json:"name" binding:"required,max=50"`
+}
+
+// MergeTagRequest represents the request body for merging one tag into another
+type MergeTagRequest struct {
+	TargetID int `json:"target_id" binding:"required"`
+}
+
+// TagModel handles database operations for tags
+type TagModel struct {
+	DB *sql.DB
+	// Events receives an event for every todo a change to a tag touches,
+	// once it is committed
+	Events *EventBroker
+	// WorkspaceID limits the model to the tags of one workspace, and gives
+	// new tags to it. AllWorkspaces means every tag.
+	WorkspaceID int
+}
+
+// NewTagModel creates a new TagModel instance publishing todo events to
+// events, which is normally the broker of the TodoModel
+func NewTagModel(db *sql.DB, events *EventBroker) *TagModel {
+	return &TagModel{DB: db, Events: events, WorkspaceID: AllWorkspaces}
+}
+
+// ForWorkspace returns a TagModel limited to the tags of one workspace
+func (m *TagModel) ForWorkspace(workspaceID int) *TagModel {
+	return &TagModel{DB: m.DB, Events: m.Events, WorkspaceID: workspaceID}
+}
+
+// dbtx is implemented by both *sql.DB and *sql.Tx
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// tagColumns selects a tag along with the number of todos carrying it
+const tagColumns = `
+	tags.id, tags.name, tags.workspace_id,
+	(
+		SELECT COUNT(*) FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id
+		WHERE todo_tags.tag_id = tags.id AND todos.deleted_at IS NULL
+	),
+	tags.created_at, tags.updated_at
+`
+
+// scanTag reads a tag selected with tagColumns
+func scanTag(row rowScanner) (*Tag, error) {
+	tag := &Tag{}
+	var workspaceID sql.NullInt64
+	err := row.Scan(&tag.ID, &tag.Name, &workspaceID, &tag.TodoCount, &tag.CreatedAt, &tag.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		tag.WorkspaceID = &id
+	}
+	return tag, nil
+}
+
+// GetAll retrieves all tags ordered by name
+func (m *TagModel) GetAll() ([]*Tag, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	rows, err := m.DB.Query(`SELECT `+tagColumns+` FROM tags WHERE `+workspace+` ORDER BY name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]*Tag, 0)
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetByID retrieves a tag by its ID, or fails with ErrNotFound
+func (m *TagModel) GetByID(id int) (*Tag, error) {
+	return m.getTag(m.DB, id)
+}
+
+// getTag reads a tag of the model's workspace using the given connection
+func (m *TagModel) getTag(db dbtx, id int) (*Tag, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	tag, err := scanTag(db.QueryRow(`SELECT `+tagColumns+` FROM tags WHERE id = ? AND `+workspace, append([]interface{}{id}, args...)...))
+	return tag, notFound(err)
+}
+
+// Create inserts a new tag, failing with ErrTagExists if the name is taken
+func (m *TagModel) Create(req TagRequest) (*Tag, error) {
+	name := normalizeTagName(req.Name)
+	if name == "" {
+		return nil, ErrInvalidTagName
+	}
+
+	workspace, err := ownerWorkspace(m.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	var exists int
+	err = m.DB.QueryRow(`SELECT COUNT(*) FROM tags WHERE workspace_id IS NOT DISTINCT FROM ? AND name = ?`, workspace, name).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, ErrTagExists
+	}
+
+	now := time.Now().UTC()
+	var id int64
+	err = m.DB.QueryRow(`INSERT INTO tags (workspace_id, name, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		workspace, name, now, now).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tag{ID: int(id), Name: name, WorkspaceID: workspace, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+// Rename changes the name of a tag, failing with ErrTagExists if another tag
+// of its workspace has it
+func (m *TagModel) Rename(id int, req TagRequest) (*Tag, error) {
+	name := normalizeTagName(req.Name)
+	if name == "" {
+		return nil, ErrInvalidTagName
+	}
+
+	if _, err := m.GetByID(id); err != nil {
+		return nil, err
+	}
+
+	var exists int
+	err := m.DB.QueryRow(`
+		SELECT COUNT(*) FROM tags
+		WHERE name = ? AND id != ? AND workspace_id IS NOT DISTINCT FROM (SELECT workspace_id FROM tags WHERE id = ?)
+	`, name, id, id).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, ErrTagExists
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`UPDATE tags SET name = ?, updated_at = ? WHERE id = ?`, name, now, id)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := bumpTaggedTodos(tx, id, now)
+	if err != nil {
+		return nil, err
+	}
+	events := &eventLog{}
+	if err := events.record(tx, EventUpdated, now, ids...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	events.publishTo(m.Events)
+
+	return m.GetByID(id)
+}
+
+// Delete removes a tag and detaches it from every todo
+func (m *TagModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := m.getTag(tx, id); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	ids, err := bumpTaggedTodos(tx, id, now)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tags WHERE id = ?`, id); err != nil {
+		return err
+	}
+	events := &eventLog{}
+	if err := events.record(tx, EventUpdated, now, ids...); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	events.publishTo(m.Events)
+
+	return nil
+}
+
+// Merge moves every todo tagged with the source tag onto the target tag of
+// the same workspace, then deletes the source tag. The target tag is
+// returned.
+func (m *TagModel) Merge(sourceID, targetID int) (*Tag, error) {
+	if sourceID == targetID {
+		return nil, ErrMergeIntoSelf
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	source, err := m.getTag(tx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := m.getTag(tx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	// A tag of another workspace is as good as missing
+	if (source.WorkspaceID == nil) != (target.WorkspaceID == nil) ||
+		(source.WorkspaceID != nil && *source.WorkspaceID != *target.WorkspaceID) {
+		return nil, ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	ids, err := bumpTaggedTodos(tx, sourceID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO todo_tags (todo_id, tag_id)
+		SELECT todo_tags.todo_id, tags.id FROM todo_tags, tags
+		WHERE todo_tags.tag_id = ? AND tags.id = ?
+		ON CONFLICT DO NOTHING
+	`, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM tags WHERE id = ?`, sourceID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE tags SET updated_at = ? WHERE id = ?`, now, targetID)
+	if err != nil {
+		return nil, err
+	}
+	events := &eventLog{}
+	if err := events.record(tx, EventUpdated, now, ids...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	events.publishTo(m.Events)
+
+	return m.GetByID(targetID)
+}
+
+// normalizeTagName trims surrounding and repeated whitespace from a tag name
+func normalizeTagName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// bumpTaggedTodos increases the version of every todo carrying a tag, since
+// renaming, merging or deleting the tag changes how those todos read, and
+// returns their IDs so the change can be recorded once it is made
+func bumpTaggedTodos(db dbtx, tagID int, now time.Time) ([]int, error) {
+	return queryIDs(db, `
+		UPDATE todos SET version = version + 1, updated_at = ?
+		WHERE id IN (SELECT todo_id FROM todo_tags WHERE tag_id = ?)
+		RETURNING id
+	`, now, tagID)
+}
+
+// setTodoTags replaces the tags on a todo, creating any tags that do not exist
+// in its workspace yet. It returns the tag names as stored, sorted.
+func setTodoTags(db dbtx, todoID int, names []string) ([]string, error) {
+	if _, err := db.Exec(`DELETE FROM todo_tags WHERE todo_id = ?`, todoID); err != nil {
+		return nil, err
+	}
+
+	var workspace sql.NullInt64
+	if err := db.QueryRow(`SELECT workspace_id FROM todos WHERE id = ?`, todoID).Scan(&workspace); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	stored := make([]string, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = normalizeTagName(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		// A NULL workspace never conflicts, so the tag is looked for first
+		const findTag = `SELECT id, name FROM tags WHERE workspace_id IS NOT DISTINCT FROM ? AND name = ?`
+		var tagID int
+		err := db.QueryRow(findTag, workspace, name).Scan(&tagID, &name)
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = db.Exec(`
+				INSERT INTO tags (workspace_id, name, created_at, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT DO NOTHING
+			`, workspace, name, now, now)
+			if err == nil {
+				err = db.QueryRow(findTag, workspace, name).Scan(&tagID, &name)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := db.Exec(`INSERT INTO todo_tags (todo_id, tag_id) VALUES (?, ?)`, todoID, tagID); err != nil {
+			return nil, err
+		}
+		stored = append(stored, name)
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		return strings.ToLower(stored[i]) < strings.ToLower(stored[j])
+	})
+	return stored, nil
+}
+
+// loadTags fills in the Tags field of each todo with a single query
+func loadTags(db dbtx, todos []*Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Todo, len(todos))
+	placeholders := make([]string, len(todos))
+	args := make([]interface{}, len(todos))
+	for i, todo := range todos {
+		todo.Tags = []string{}
+		byID[todo.ID] = todo
+		placeholders[i] = "?"
+		args[i] = todo.ID
+	}
+
+	rows, err := db.Query(`
+		SELECT todo_tags.todo_id, tags.name
+		FROM todo_tags
+		JOIN tags ON tags.id = todo_tags.tag_id
+		WHERE todo_tags.todo_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY tags.name
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoID int
+		var name string
+		if err := rows.Scan(&todoID, &name); err != nil {
+			return err
+		}
+		byID[todoID].Tags = append(byID[todoID].Tags, name)
+	}
+
+	return rows.Err()
+}
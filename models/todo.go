@@ -5,98 +5,354 @@ import (
 	"time"
 )
 
+// ErrVersionMismatch is returned when a write expects a version of a todo
+// that has since been replaced by another write
+var ErrVersionMismatch = newError(ErrConflict, "", "todo version does not match")
+
 // Todo represents a todo item
 type Todo struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Completed   bool      `json:"completed"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID int `json:"id"`
+	// WorkspaceID is the workspace the todo belongs to; todos created before accounts existed have none
+	WorkspaceID *int       `json:"workspace_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Completed   bool       `json:"completed"`
+	Priority    Priority   `json:"priority"`
+	ProjectID   *int       `json:"project_id"`
+	ParentID    *int       `json:"parent_id"`
+	DueAt       *time.Time `json:"due_at"`
+	// DueTimezone is the IANA zone the due date was set in; DueAt is rendered in it
+	DueTimezone string `json:"due_timezone,omitempty"`
+	// Recurrence is an RFC 5545 RRULE; completing the todo creates the next occurrence
+	Recurrence string `json:"recurrence,omitempty"`
+	// RecurrenceStart is the due date of the series' first occurrence, the rule's DTSTART
+	RecurrenceStart *time.Time `json:"-"`
+	// SeriesID is the ID of the first todo of a recurring series
+	SeriesID *int     `json:"series_id"`
+	Tags     []string `json:"tags"`
+	// Version starts at 1 and increases with every write to the todo
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set while the todo is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// NextOccurrence is only filled in when completing a recurring todo created one
+	NextOccurrence *Todo `json:"next_occurrence,omitempty"`
+	// Children is only filled in when a todo is returned as part of a tree
+	Children []*Todo `json:"children,omitempty"`
 }
 
 // CreateTodoRequest represents the request body for creating a todo
 type CreateTodoRequest struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description"`
+	Title       string     `json:"title" binding:"required"`
+	Description string     `json:"description"`
+	Priority    Priority   `json:"priority"`
+	ProjectID   *int       `json:"project_id"`
+	ParentID    *int       `json:"parent_id"`
+	DueAt       *time.Time `json:"due_at"`
+	DueTimezone string     `json:"due_timezone" binding:"omitempty,timezone"`
+	// Recurrence is an RFC 5545 RRULE such as "FREQ=WEEKLY;BYDAY=MO"; it needs a due date
+	Recurrence string `json:"recurrence" binding:"max=500"`
+	// Tags are tag names; tags that do not exist yet are created
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,max=50"`
 }
 
 // UpdateTodoRequest represents the request body for updating a todo
 type UpdateTodoRequest struct {
-	Title       string `json:"title" binding:"required"`
-	Description string `json:"description"`
+	Title       string     `json:"title" binding:"required"`
+	Description string     `json:"description"`
+	Priority    Priority   `json:"priority"`
+	ProjectID   *int       `json:"project_id"`
+	ParentID    *int       `json:"parent_id"`
+	DueAt       *time.Time `json:"due_at"`
+	DueTimezone string     `json:"due_timezone" binding:"omitempty,timezone"`
+	// Recurrence is an RFC 5545 RRULE such as "FREQ=WEEKLY;BYDAY=MO"; it needs a due date
+	Recurrence string `json:"recurrence" binding:"max=500"`
+	// Tags are tag names; tags that do not exist yet are created
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,max=50"`
+}
+
+// todoColumns is the column list scanned by scanTodo
+const todoColumns = `
+	id, title, description, completed, priority, project_id, parent_id, due_at, due_timezone,
+	recurrence, recurrence_start, series_id, workspace_id, version, created_at, updated_at, deleted_at
+`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTodo reads a todo selected with todoColumns, followed by any extra columns
+func scanTodo(row rowScanner, extra ...interface{}) (*Todo, error) {
+	todo := &Todo{}
+	var projectID, parentID, seriesID, workspaceID sql.NullInt64
+	var dueAt, recurrenceStart, deletedAt sql.NullTime
+
+	dest := append([]interface{}{
+		&todo.ID,
+		&todo.Title,
+		&todo.Description,
+		&todo.Completed,
+		&todo.Priority,
+		&projectID,
+		&parentID,
+		&dueAt,
+		&todo.DueTimezone,
+		&todo.Recurrence,
+		&recurrenceStart,
+		&seriesID,
+		&workspaceID,
+		&todo.Version,
+		&todo.CreatedAt,
+		&todo.UpdatedAt,
+		&deletedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		todo.ProjectID = &id
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		todo.ParentID = &id
+	}
+	if dueAt.Valid {
+		todo.DueAt = localDueAt(dueAt.Time, todo.DueTimezone)
+	}
+	if recurrenceStart.Valid {
+		todo.RecurrenceStart = localDueAt(recurrenceStart.Time, todo.DueTimezone)
+	}
+	if seriesID.Valid {
+		id := int(seriesID.Int64)
+		todo.SeriesID = &id
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		todo.WorkspaceID = &id
+	}
+	if deletedAt.Valid {
+		todo.DeletedAt = &deletedAt.Time
+	}
+
+	return todo, nil
+}
+
+// localDueAt renders a due date in the zone it was set in, falling back to UTC
+func localDueAt(dueAt time.Time, timezone string) *time.Time {
+	local := dueAt.UTC()
+	if loc, err := time.LoadLocation(timezone); err == nil && timezone != "" {
+		local = local.In(loc)
+	}
+	return &local
+}
+
+// dueAtArg converts an optional due date into its stored UTC form
+func dueAtArg(dueAt *time.Time) interface{} {
+	if dueAt == nil {
+		return nil
+	}
+	return dueAt.UTC()
 }
 
 // TodoModel handles database operations for todos
 type TodoModel struct {
 	DB *sql.DB
+	// Events receives an event for every change to a todo, once it is committed
+	Events *EventBroker
+	// WorkspaceID limits the model to the todos of one workspace, and gives
+	// it the todos it creates. When it is AllWorkspaces the model works on
+	// every todo, and the todos it creates belong to no workspace.
+	WorkspaceID int
 }
 
-// NewTodoModel creates a new TodoModel instance
+// NewTodoModel creates a new TodoModel instance working on the todos of
+// every workspace
 func NewTodoModel(db *sql.DB) *TodoModel {
-	return &TodoModel{DB: db}
+	return &TodoModel{DB: db, Events: NewEventBroker(), WorkspaceID: AllWorkspaces}
+}
+
+// ForWorkspace returns a TodoModel limited to the todos of one workspace,
+// sharing this model's database and event broker
+func (m *TodoModel) ForWorkspace(workspaceID int) TodoRepository {
+	return &TodoModel{DB: m.DB, Events: m.Events, WorkspaceID: workspaceID}
+}
+
+// ownerWorkspace returns the WorkspaceID of a record created in a workspace,
+// which is none for AllWorkspaces. It fails with ErrNoWorkspace for
+// workspace 0.
+func ownerWorkspace(workspace int) (*int, error) {
+	switch {
+	case workspace == AllWorkspaces:
+		return nil, nil
+	case workspace <= 0:
+		return nil, ErrNoWorkspace
+	}
+	return &workspace, nil
+}
+
+// checkWorkspace fails with ErrNotFound unless the todo, in the trash or not,
+// belongs to the workspace. Every todo passes for AllWorkspaces.
+func checkWorkspace(db dbtx, workspace int, id int) error {
+	if workspace == AllWorkspaces {
+		return nil
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM todos WHERE id = ? AND workspace_id = ?`, id, workspace).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// workspaceClause returns the condition limiting todos, or their events, to
+// a workspace, and its arguments. It matches every row for AllWorkspaces and
+// none for workspace 0.
+func workspaceClause(workspace int) (string, []interface{}) {
+	if workspace == AllWorkspaces {
+		return "1 = 1", nil
+	}
+	return "workspace_id = ?", []interface{}{workspace}
 }
 
 // Create inserts a new todo into the database
 func (m *TodoModel) Create(req CreateTodoRequest) (*Todo, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	events := &eventLog{}
+	todo, err := createTodo(tx, m.WorkspaceID, req, time.Now().UTC(), events)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.publish(events)
+
+	return todo, nil
+}
+
+// createTodo inserts a new todo into a workspace using the given connection
+// or transaction
+func createTodo(db dbtx, workspace int, req CreateTodoRequest, now time.Time, events *eventLog) (*Todo, error) {
 	query := `
-		INSERT INTO todos (title, description, completed, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO todos (
+			title, description, completed, priority, project_id, parent_id, due_at, due_timezone,
+			recurrence, recurrence_start, workspace_id, created_at, updated_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
 	`
 
-	now := time.Now()
-	result, err := m.DB.Exec(query, req.Title, req.Description, false, now, now)
+	owner, err := ownerWorkspace(workspace)
+	if err != nil {
+		return nil, err
+	}
+	recurrence, err := normalizeRecurrence(req.Recurrence, req.DueAt)
 	if err != nil {
 		return nil, err
 	}
+	var recurrenceStart interface{}
+	if recurrence != "" {
+		recurrenceStart = dueAtArg(req.DueAt)
+	}
+
+	if err := checkProjectExists(db, workspace, req.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := checkParent(db, workspace, 0, req.ParentID); err != nil {
+		return nil, err
+	}
 
-	id, err := result.LastInsertId()
+	var id int64
+	err = db.QueryRow(query, req.Title, req.Description, false, req.Priority, req.ProjectID, req.ParentID,
+		dueAtArg(req.DueAt), req.DueTimezone, recurrence, recurrenceStart, owner, now, now).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Todo{
+	tags, err := setTodoTags(db, int(id), req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := events.record(db, EventCreated, now, int(id)); err != nil {
+		return nil, err
+	}
+
+	todo := &Todo{
 		ID:          int(id),
+		WorkspaceID: owner,
 		Title:       req.Title,
 		Description: req.Description,
 		Completed:   false,
+		Priority:    req.Priority,
+		ProjectID:   req.ProjectID,
+		ParentID:    req.ParentID,
+		DueTimezone: req.DueTimezone,
+		Recurrence:  recurrence,
+		Tags:        tags,
+		Version:     1,
 		CreatedAt:   now,
 		UpdatedAt:   now,
-	}, nil
+	}
+	if req.DueAt != nil {
+		todo.DueAt = localDueAt(*req.DueAt, req.DueTimezone)
+	}
+	if recurrence != "" {
+		todo.RecurrenceStart = todo.DueAt
+	}
+
+	return todo, nil
 }
 
-// GetByID retrieves a todo by its ID
+// GetByID retrieves a todo by its ID. Todos in the trash fail with ErrNotFound.
 func (m *TodoModel) GetByID(id int) (*Todo, error) {
+	if err := checkWorkspace(m.DB, m.WorkspaceID, id); err != nil {
+		return nil, err
+	}
+	return getTodo(m.DB, id)
+}
+
+// getTodo reads a todo that is not in the trash, along with its tags
+func getTodo(db dbtx, id int) (*Todo, error) {
 	query := `
-		SELECT id, title, description, completed, created_at, updated_at
-		FROM todos WHERE id = ?
+		SELECT ` + todoColumns + `
+		FROM todos WHERE id = ? AND deleted_at IS NULL
 	`
 
-	todo := &Todo{}
-	err := m.DB.QueryRow(query, id).Scan(
-		&todo.ID,
-		&todo.Title,
-		&todo.Description,
-		&todo.Completed,
-		&todo.CreatedAt,
-		&todo.UpdatedAt,
-	)
-
+	todo, err := scanTodo(db.QueryRow(query, id))
 	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := loadTags(db, []*Todo{todo}); err != nil {
 		return nil, err
 	}
 
 	return todo, nil
 }
 
-// GetAll retrieves all todos from the database
+// GetAll retrieves all todos that are not in the trash
 func (m *TodoModel) GetAll() ([]*Todo, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
 	query := `
-		SELECT id, title, description, completed, created_at, updated_at
-		FROM todos ORDER BY created_at DESC
+		SELECT ` + todoColumns + `
+		FROM todos WHERE deleted_at IS NULL AND ` + workspace + ` ORDER BY created_at DESC
 	`
 
-	rows, err := m.DB.Query(query)
+	rows, err := m.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -104,15 +360,7 @@ func (m *TodoModel) GetAll() ([]*Todo, error) {
 
 	var todos []*Todo
 	for rows.Next() {
-		todo := &Todo{}
-		err := rows.Scan(
-			&todo.ID,
-			&todo.Title,
-			&todo.Description,
-			&todo.Completed,
-			&todo.CreatedAt,
-			&todo.UpdatedAt,
-		)
+		todo, err := scanTodo(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -123,48 +371,252 @@ func (m *TodoModel) GetAll() ([]*Todo, error) {
 		return nil, err
 	}
 
+	if err := loadTags(m.DB, todos); err != nil {
+		return nil, err
+	}
+
 	return todos, nil
 }
 
-// Update modifies an existing todo
-func (m *TodoModel) Update(id int, req UpdateTodoRequest) (*Todo, error) {
+// Update modifies an existing todo, or fails with ErrNotFound. A non-zero version
+// must match the todo's current version, or ErrVersionMismatch is returned and
+// nothing changes.
+func (m *TodoModel) Update(id int, req UpdateTodoRequest, version int) (*Todo, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	events := &eventLog{}
+	if err := updateTodo(tx, m.WorkspaceID, id, req, version, time.Now().UTC(), events); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.publish(events)
+
+	// Return the updated todo
+	return m.GetByID(id)
+}
+
+// updateTodo modifies an existing todo of a workspace using the given
+// connection or transaction
+func updateTodo(db dbtx, workspace int, id int, req UpdateTodoRequest, version int, now time.Time, events *eventLog) error {
 	query := `
 		UPDATE todos 
-		SET title = ?, description = ?, updated_at = ?
-		WHERE id = ?
+		SET title = ?, description = ?, priority = ?, project_id = ?, parent_id = ?,
+			due_at = ?, due_timezone = ?, recurrence = ?, recurrence_start = ?,
+			version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ?
 	`
 
-	now := time.Now()
-	_, err := m.DB.Exec(query, req.Title, req.Description, now, id)
+	if err := checkWorkspace(db, workspace, id); err != nil {
+		return err
+	}
+
+	recurrence, err := normalizeRecurrence(req.Recurrence, req.DueAt)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Return the updated todo
-	return m.GetByID(id)
+	if err := checkProjectExists(db, workspace, req.ProjectID); err != nil {
+		return err
+	}
+	if err := checkParent(db, workspace, id, req.ParentID); err != nil {
+		return err
+	}
+
+	current, err := scanTodo(db.QueryRow(`SELECT `+todoColumns+` FROM todos WHERE id = ? AND deleted_at IS NULL`, id))
+	if err != nil {
+		return notFound(err)
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionMismatch
+	}
+
+	// The series keeps its start unless the rule or the due date changes,
+	// so rules with a COUNT or UNTIL stay anchored to the first occurrence
+	var recurrenceStart interface{}
+	if recurrence != "" {
+		recurrenceStart = dueAtArg(req.DueAt)
+		if recurrence == current.Recurrence && current.RecurrenceStart != nil &&
+			current.DueAt != nil && current.DueAt.Equal(*req.DueAt) {
+			recurrenceStart = current.RecurrenceStart.UTC()
+		}
+	}
+
+	// The recurrence fields were worked out from the row just read, so the
+	// write only goes through if no other write has landed since
+	result, err := db.Exec(query, req.Title, req.Description, req.Priority, req.ProjectID, req.ParentID,
+		dueAtArg(req.DueAt), req.DueTimezone, recurrence, recurrenceStart, now, id, current.Version)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrVersionMismatch
+	}
+
+	if _, err := setTodoTags(db, id, req.Tags); err != nil {
+		return err
+	}
+
+	return events.record(db, EventUpdated, now, id)
+}
+
+// Delete moves a todo and its subtasks to the trash, or fails with ErrNotFound.
+// A non-zero version must match the todo's current version, or
+// ErrVersionMismatch is returned.
+func (m *TodoModel) Delete(id int, version int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	events := &eventLog{}
+	if err := deleteTodo(tx, m.WorkspaceID, id, version, time.Now().UTC(), events); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.publish(events)
+
+	return nil
+}
+
+// deleteTodo moves a todo of a workspace and its subtasks to the trash
+// using the given connection or transaction
+func deleteTodo(db dbtx, workspace int, id int, version int, now time.Time, events *eventLog) error {
+	if err := checkWorkspace(db, workspace, id); err != nil {
+		return err
+	}
+	if err := checkVersion(db, id, version); err != nil {
+		return err
+	}
+
+	ids, err := trashTodos(db, `id = ?`, []interface{}{id}, now)
+	if err != nil {
+		return err
+	}
+
+	return events.record(db, EventDeleted, now, ids...)
 }
 
-// Delete removes a todo from the database
-func (m *TodoModel) Delete(id int) error {
-	query := `DELETE FROM todos WHERE id = ?`
-	_, err := m.DB.Exec(query, id)
-	return err
+// checkVersion fails with ErrNotFound if the todo is missing or in the trash,
+// and with ErrVersionMismatch if a non-zero version is not its current one
+func checkVersion(db dbtx, id int, version int) error {
+	var current int
+	if err := db.QueryRow(`SELECT version FROM todos WHERE id = ? AND deleted_at IS NULL`, id).Scan(&current); err != nil {
+		return notFound(err)
+	}
+	if version != 0 && current != version {
+		return ErrVersionMismatch
+	}
+	return nil
 }
 
-// ToggleComplete toggles the completed status of a todo
-func (m *TodoModel) ToggleComplete(id int, completed bool) (*Todo, error) {
+// ToggleComplete toggles the completed status of a todo. When completing,
+// policy decides what happens to its subtasks; it is ignored when reopening.
+// Completing a recurring todo also creates its next occurrence, which is
+// returned in the NextOccurrence field.
+func (m *TodoModel) ToggleComplete(id int, completed bool, policy ChildPolicy) (*Todo, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWorkspace(tx, m.WorkspaceID, id); err != nil {
+		return nil, err
+	}
+
+	events := &eventLog{}
+	nextID, err := toggleComplete(tx, id, completed, policy, time.Now().UTC(), events)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.publish(events)
+
+	// Return the updated todo
+	return getCompletedTodo(m.DB, id, nextID)
+}
+
+// toggleComplete sets the completed status of a todo using the given
+// connection or transaction. It returns the ID of the next occurrence when
+// completing a recurring todo created one.
+func toggleComplete(db dbtx, id int, completed bool, policy ChildPolicy, now time.Time, events *eventLog) (*int, error) {
 	query := `
 		UPDATE todos 
-		SET completed = ?, updated_at = ?
+		SET completed = ?, version = version + 1, updated_at = ?
 		WHERE id = ?
 	`
 
-	now := time.Now()
-	_, err := m.DB.Exec(query, completed, now, id)
+	todo, err := getTodo(db, id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Return the updated todo
-	return m.GetByID(id)
+	var nextID *int
+	eventType := EventUpdated
+	if completed {
+		eventType = EventCompleted
+		children, err := completeChildren(db, id, policy, now)
+		if err != nil {
+			return nil, err
+		}
+		if err := events.record(db, EventCompleted, now, children...); err != nil {
+			return nil, err
+		}
+		if todo.Recurrence != "" && !todo.Completed {
+			if nextID, err = spawnNextOccurrence(db, todo, now); err != nil {
+				return nil, err
+			}
+			if nextID != nil {
+				if err := events.record(db, EventCreated, now, *nextID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	result, err := db.Exec(query, completed, now, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	if err := events.record(db, eventType, now, id); err != nil {
+		return nil, err
+	}
+
+	return nextID, nil
+}
+
+// getCompletedTodo reads a todo after toggleComplete, along with the next
+// occurrence it created, if any
+func getCompletedTodo(db dbtx, id int, nextID *int) (*Todo, error) {
+	todo, err := getTodo(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if nextID != nil {
+		if todo.NextOccurrence, err = getTodo(db, *nextID); err != nil {
+			return nil, err
+		}
+	}
+
+	return todo, nil
 }
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultAccessTokenTTL is how long an access token is accepted. Clients get
+// a new one from their refresh token when it runs out.
+const DefaultAccessTokenTTL = 15 * time.Minute
+
+// tokenIssuer is the "iss" claim of every access token
+const tokenIssuer = "todo-api"
+
+// ErrInvalidToken is returned when an access token is malformed, badly
+// signed or has expired
+var ErrInvalidToken = errors.New("invalid or expired access token")
+
+// Principal is who a request is made by
+type Principal struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	// APIKeyID is the API key the request was made with, or 0 when the user
+	// logged in
+	APIKeyID int `json:"api_key_id,omitempty"`
+	// Scopes limit what an API key may do. Logged in users may do anything.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// HasScope reports whether the principal may act within a scope
+func (p *Principal) HasScope(scope string) bool {
+	return p.APIKeyID == 0 || slices.Contains(p.Scopes, scope)
+}
+
+// TokenPair is returned by logging in and refreshing. The access token goes
+// in the Authorization header of every request; the refresh token is only
+// sent to POST /auth/refresh to get the next pair.
+type TokenPair struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is the number of seconds the access token is accepted for
+	ExpiresIn        int       `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	User             *User     `json:"user"`
+}
+
+// SigningKey is a key access tokens are signed or verified with. Keys
+// without a private half only verify, which lets tokens signed with a
+// retired key stay valid until they expire.
+type SigningKey struct {
+	// ID is sent as the "kid" header, so verifiers pick the right key
+	ID     string
+	Method jwt.SigningMethod
+	// sign and verify are the same secret for HMAC keys
+	sign   interface{}
+	verify interface{}
+}
+
+// NewHMACKey creates an HS256 key from a shared secret of at least 32 bytes
+func NewHMACKey(secret []byte) (*SigningKey, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("HS256 secret must be at least 32 bytes")
+	}
+	sum := sha256.Sum256(secret)
+	return &SigningKey{
+		ID:     base64.RawURLEncoding.EncodeToString(sum[:8]),
+		Method: jwt.SigningMethodHS256,
+		sign:   secret,
+		verify: secret,
+	}, nil
+}
+
+// NewPublicKey creates a verify only key from an RSA (RS256) or Ed25519
+// (EdDSA) public key
+func NewPublicKey(public crypto.PublicKey) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &SigningKey{
+		ID:     base64.RawURLEncoding.EncodeToString(sum[:8]),
+		Method: method,
+		verify: public,
+	}, nil
+}
+
+// NewPrivateKey creates a signing key from an RSA (RS256) or Ed25519 (EdDSA)
+// private key
+func NewPrivateKey(private crypto.Signer) (*SigningKey, error) {
+	key, err := NewPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, ok := private.(*rsa.PrivateKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("RS256 keys must be at least 2048 bits")
+	}
+	key.sign = private
+	return key, nil
+}
+
+// ParseKeyPEM reads a PEM encoded private key ("PRIVATE KEY" or "RSA PRIVATE
+// KEY") into a signing key, or a public key ("PUBLIC KEY") into a verify
+// only key
+func ParseKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", private)
+		}
+		return NewPrivateKey(signer)
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewPrivateKey(private)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewPublicKey(public)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// accessClaims are the claims of an access token. The subject is the user ID.
+type accessClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// TokenIssuer signs access tokens with its current key and verifies them
+// with any of its keys. To rotate keys, make the new key current and keep the
+// old one in Previous until the tokens it signed have expired.
+type TokenIssuer struct {
+	Current  *SigningKey
+	Previous []*SigningKey
+	TTL      time.Duration
+}
+
+// NewTokenIssuer creates a TokenIssuer whose tokens last for ttl
+func NewTokenIssuer(current *SigningKey, previous []*SigningKey, ttl time.Duration) (*TokenIssuer, error) {
+	if current == nil || current.sign == nil {
+		return nil, errors.New("the current key must be able to sign")
+	}
+	return &TokenIssuer{Current: current, Previous: previous, TTL: ttl}, nil
+}
+
+// Issue signs an access token for a user and returns it with its expiry
+func (i *TokenIssuer) Issue(user *User) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(i.TTL)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := jwt.NewWithClaims(i.Current.Method, accessClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			ID:        base64.RawURLEncoding.EncodeToString(id),
+		},
+	})
+	token.Header["kid"] = i.Current.ID
+
+	signed, err := token.SignedString(i.Current.sign)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Verify checks an access token and returns who it was issued to, or fails
+// with ErrInvalidToken
+func (i *TokenIssuer) Verify(token string) (*Principal, error) {
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, i.keyFor,
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return nil, fmt.Errorf("%w: bad subject", ErrInvalidToken)
+	}
+	return &Principal{UserID: userID, Email: claims.Email}, nil
+}
+
+// keyFor picks the key a token was signed with by its "kid" header. The
+// token's algorithm must be the key's, so an RSA public key is never taken
+// for an HMAC secret.
+func (i *TokenIssuer) keyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range append([]*SigningKey{i.Current}, i.Previous...) {
+		if key.ID == kid {
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("key %s does not sign %s tokens", kid, token.Method.Alg())
+			}
+			return key.verify, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
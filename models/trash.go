@@ -0,0 +1,106 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ErrParentInTrash is returned when restoring a subtask whose parent is still in the trash
+var ErrParentInTrash = newError(ErrConflict, "", "parent todo is in the trash")
+
+// DefaultTrashRetention is how long trashed todos are kept before they may be purged
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// trashTodos moves the todos matching cond, and every subtask below them, to
+// the trash, and returns their IDs. They all share one deleted_at so they can
+// be restored together.
+func trashTodos(db dbtx, cond string, args []interface{}, now time.Time) ([]int, error) {
+	query := `
+		WITH RECURSIVE trashed (id) AS (
+			SELECT id FROM todos WHERE ` + cond + ` AND deleted_at IS NULL
+			UNION
+			SELECT todos.id FROM todos JOIN trashed ON todos.parent_id = trashed.id
+			WHERE todos.deleted_at IS NULL
+		)
+		UPDATE todos SET deleted_at = ?, version = version + 1 WHERE id IN (SELECT id FROM trashed)
+		RETURNING id
+	`
+
+	return queryIDs(db, query, append(args, now)...)
+}
+
+// Restore takes a todo out of the trash, along with the subtasks that were
+// trashed with it. It fails with ErrNotFound if the todo is not in the trash.
+func (m *TodoModel) Restore(id int) (*Todo, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkWorkspace(tx, m.WorkspaceID, id); err != nil {
+		return nil, err
+	}
+	todo, err := scanTodo(tx.QueryRow(`SELECT `+todoColumns+` FROM todos WHERE id = ? AND deleted_at IS NOT NULL`, id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if todo.ParentID != nil {
+		var parentDeleted sql.NullTime
+		err := tx.QueryRow(`SELECT deleted_at FROM todos WHERE id = ?`, *todo.ParentID).Scan(&parentDeleted)
+		if err != nil {
+			return nil, err
+		}
+		if parentDeleted.Valid {
+			return nil, ErrParentInTrash
+		}
+	}
+
+	query := `
+		WITH RECURSIVE restored (id) AS (
+			SELECT id FROM todos WHERE id = ?
+			UNION
+			SELECT todos.id FROM todos JOIN restored ON todos.parent_id = restored.id
+			WHERE todos.deleted_at = ?
+		)
+		UPDATE todos SET deleted_at = NULL, version = version + 1 WHERE id IN (SELECT id FROM restored)
+		RETURNING id
+	`
+	ids, err := queryIDs(tx, query, id, *todo.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	events := &eventLog{}
+	if err := events.record(tx, EventRestored, time.Now().UTC(), ids...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.publish(events)
+
+	return m.GetByID(id)
+}
+
+// Purge permanently deletes todos that were moved to the trash before the
+// given time, and returns how many were deleted. Subtasks trashed along with
+// their parent are deleted by the cascade and not counted.
+func (m *TodoModel) Purge(before time.Time) (int64, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	query := `
+		DELETE FROM todos
+		WHERE deleted_at IS NOT NULL AND deleted_at < ? AND ` + workspace + `
+		AND (parent_id IS NULL OR parent_id NOT IN (
+			SELECT id FROM todos WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		))
+	`
+	args = append(append([]interface{}{before.UTC()}, args...), before.UTC())
+	result, err := m.DB.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
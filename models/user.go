@@ -0,0 +1,272 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultSessionTTL is how long a refresh token lasts before the user has to
+// log in again
+const DefaultSessionTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrEmailTaken is returned when registering an email address that already has an account
+	ErrEmailTaken = newError(ErrConflict, "email", "email is already registered")
+	// ErrPasswordTooLong is returned for passwords bcrypt cannot hash in full
+	ErrPasswordTooLong = newError(ErrValidation, "password", "password must be at most 72 bytes")
+	// ErrInvalidCredentials is returned when logging in with an unknown email or a wrong password
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrInvalidSession is returned when a refresh token is unknown, revoked or has expired
+	ErrInvalidSession = errors.New("invalid or expired refresh token")
+)
+
+// User is an account. Its todos are kept in the workspaces it is a member of.
+type User struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// PasswordHash is a bcrypt hash of the password; it is never sent to clients
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// RegisterRequest represents the request body for creating an account
+type RegisterRequest struct {
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Name     string `json:"name" binding:"max=100"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// LoginRequest represents the request body for logging in
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest represents the request body for refreshing tokens and logging out
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Session is a login. Its Token is the refresh token access tokens are
+// issued from; it is only known when the session is created, as the database
+// keeps a hash of it.
+type Session struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      *User     `json:"user"`
+}
+
+// UserModel handles database operations for users and their sessions
+type UserModel struct {
+	DB *sql.DB
+}
+
+// NewUserModel creates a new UserModel instance
+func NewUserModel(db *sql.DB) *UserModel {
+	return &UserModel{DB: db}
+}
+
+// userColumns is the column list scanned by scanUser
+const userColumns = `users.id, users.email, users.name, users.password_hash, users.created_at, users.updated_at`
+
+// scanUser reads a user selected with userColumns
+func scanUser(row rowScanner) (*User, error) {
+	user := &User{}
+	err := row.Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// normalizeEmail trims an email address and lower cases it, so each address
+// has one account however it is typed
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Register creates an account along with its personal workspace, failing
+// with ErrEmailTaken if the email address already has one
+func (m *UserModel) Register(req RegisterRequest) (*User, error) {
+	email := normalizeEmail(req.Email)
+
+	// bcrypt ignores everything after the 72nd byte
+	if len(req.Password) > 72 {
+		return nil, ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists int
+	if err := m.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE email = ?`, email).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	now := time.Now().UTC()
+	user := &User{
+		Email:        email,
+		Name:         strings.TrimSpace(req.Name),
+		PasswordHash: string(hash),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO users (email, name, password_hash, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
+	`, user.Email, user.Name, user.PasswordHash, now, now).Scan(&user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every user has a personal workspace their todos go in by default
+	if _, err := createWorkspace(tx, user.ID, personalWorkspaceName, true, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetByID retrieves a user by its ID, or fails with ErrNotFound
+func (m *UserModel) GetByID(id int) (*User, error) {
+	user, err := scanUser(m.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	return user, notFound(err)
+}
+
+// Login checks a user's password and starts a session lasting ttl. It fails
+// with ErrInvalidCredentials without saying whether the email or the
+// password was wrong.
+func (m *UserModel) Login(email, password string, ttl time.Duration) (*Session, error) {
+	user, err := scanUser(m.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, normalizeEmail(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		// Hash the password anyway, so unknown addresses take as long as known ones
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return startSession(m.DB, user, ttl)
+}
+
+// Refresh exchanges a refresh token for a new session lasting ttl. The old
+// token is revoked, so each refresh token can only be used once.
+func (m *UserModel) Refresh(token string, ttl time.Duration) (*Session, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Deleting the session first means two refreshes with the same token
+	// cannot both succeed
+	var userID int
+	err = tx.QueryRow(`DELETE FROM sessions WHERE token_hash = ? AND expires_at > ? RETURNING user_id`,
+		hashToken(token), time.Now().UTC()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userID))
+	if err != nil {
+		return nil, err
+	}
+	session, err := startSession(tx, user, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// startSession creates a session lasting ttl for a user
+func startSession(db dbtx, user *User, ttl time.Duration) (*Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{Token: token, ExpiresAt: now.Add(ttl), User: user}
+	_, err = db.Exec(`INSERT INTO sessions (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		user.ID, hashToken(token), now, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Logout revokes a refresh token. Revoking a token that does not exist is
+// not an error.
+func (m *UserModel) Logout(token string) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// PurgeSessions deletes the sessions that expired before the given time and
+// returns how many were removed
+func (m *UserModel) PurgeSessions(before time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// dummyPasswordHash is compared against when logging in with an unknown email
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// newToken returns a random, URL safe token for refresh tokens and API keys
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the form of a token kept in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,711 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// WebhookMaxAttempts is how many times a delivery is tried before it is marked failed
+	WebhookMaxAttempts = 8
+	// WebhookRetryDelay is how long the first retry waits. Each retry after it
+	// waits twice as long as the one before.
+	WebhookRetryDelay = 30 * time.Second
+	// WebhookTimeout is how long a webhook has to answer a delivery
+	WebhookTimeout = 10 * time.Second
+	// DefaultDeliveryRetention is how long finished deliveries are kept in the log
+	DefaultDeliveryRetention = 7 * 24 * time.Hour
+	// webhookBatchSize is how many due deliveries are sent at a time
+	webhookBatchSize = 50
+	// webhookConcurrency is how many endpoints are sent deliveries at once
+	webhookConcurrency = 8
+	// WebhookSignatureTolerance is how old a delivery's timestamp may be for
+	// VerifyWebhookSignature to accept it
+	WebhookSignatureTolerance = 5 * time.Minute
+)
+
+// Headers sent with every delivery
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// ErrInvalidWebhookURL is returned when a webhook URL is not an absolute http or https URL
+var ErrInvalidWebhookURL = newError(ErrValidation, "url", "must be an http or https URL")
+
+// ErrInvalidWebhookSignature is returned by VerifyWebhookSignature for a
+// delivery that was not signed with the secret, or was signed too long ago
+var ErrInvalidWebhookSignature = errors.New("invalid or expired webhook signature")
+
+// ErrWebhookAddressBlocked is returned when a delivery would connect to an
+// address that is not on the public internet
+var ErrWebhookAddressBlocked = errors.New("webhooks cannot be sent to private, loopback or link-local addresses")
+
+// blockedWebhookPrefixes are the non-public ranges netip.Addr has no method for
+var blockedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+}
+
+// DeliveryStatus is where a delivery is in the queue
+type DeliveryStatus string
+
+// Delivery statuses
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Webhook is a URL that is sent the todo events it subscribes to, for the
+// todos of its workspace
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// WorkspaceID is the workspace whose todos the webhook hears about;
+	// webhooks created before workspaces existed have none, and only hear
+	// about todos outside every workspace
+	WorkspaceID *int        `json:"workspace_id"`
+	Events      []EventType `json:"events"`
+	// Secret signs deliveries. It is only returned when the webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookRequest represents the request body for creating or updating a webhook
+type WebhookRequest struct {
+	URL    string      `json:"url" binding:"required,url,max=2048"`
+	Events []EventType `json:"events" binding:"required,min=1,dive,oneof=created updated deleted completed restored"`
+	// Secret is generated when a webhook is created without one, and kept
+	// when a webhook is updated without one
+	Secret string `json:"secret" binding:"omitempty,min=16,max=256"`
+	// Active defaults to true
+	Active *bool `json:"active"`
+}
+
+// WebhookDelivery is one event sent, or waiting to be sent, to a webhook
+type WebhookDelivery struct {
+	ID        int             `json:"id"`
+	WebhookID int             `json:"webhook_id"`
+	EventID   int64           `json:"event_id"`
+	EventType EventType       `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    DeliveryStatus  `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// ResponseStatus is the HTTP status of the last attempt, if it got a response
+	ResponseStatus *int       `json:"response_status"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// WebhookModel handles database operations for webhooks and their deliveries
+type WebhookModel struct {
+	DB     *sql.DB
+	Client *http.Client
+	// WorkspaceID limits the model to the webhooks of one workspace, and
+	// gives new webhooks to it. AllWorkspaces means every webhook.
+	WorkspaceID int
+}
+
+// NewWebhookModel creates a new WebhookModel instance. Its client only
+// connects to public addresses, so webhooks cannot reach the server's own
+// network.
+func NewWebhookModel(db *sql.DB) *WebhookModel {
+	return &WebhookModel{DB: db, Client: newWebhookClient(), WorkspaceID: AllWorkspaces}
+}
+
+// newWebhookClient returns a client that refuses to connect to addresses
+// that are not public. The check runs on the resolved address of every
+// connection, redirects included, so DNS cannot be used to get around it.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: WebhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddressBlocked, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	// Proxies are not used, since the proxy rather than the webhook's
+	// address would be checked
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: WebhookTimeout, Transport: transport}
+}
+
+// isPublicAddr reports whether addr may be reached by a webhook
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, prefix := range blockedWebhookPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ForWorkspace returns a WebhookModel limited to the webhooks of one workspace
+func (m *WebhookModel) ForWorkspace(workspaceID int) *WebhookModel {
+	return &WebhookModel{DB: m.DB, Client: m.Client, WorkspaceID: workspaceID}
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature of a delivery body sent
+// at timestamp (Unix seconds, as in X-Webhook-Timestamp): "sha256=" and the
+// hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+// Signing the timestamp lets receivers turn away old deliveries sent again.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the X-Webhook-Timestamp and
+// X-Webhook-Signature headers of a delivery received at now, and fails with
+// ErrInvalidWebhookSignature unless the body was signed with secret within
+// WebhookSignatureTolerance of now
+func VerifyWebhookSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidWebhookSignature
+	}
+	age := now.Sub(time.Unix(sent, 0))
+	if age > WebhookSignatureTolerance || age < -WebhookSignatureTolerance {
+		return ErrInvalidWebhookSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignWebhookPayload(secret, sent, body))) {
+		return ErrInvalidWebhookSignature
+	}
+	return nil
+}
+
+// webhookColumns selects a webhook without its secret
+const webhookColumns = `id, url, workspace_id, events, active, created_at, updated_at`
+
+// scanWebhook reads a webhook selected with webhookColumns
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	webhook := &Webhook{}
+	var workspaceID sql.NullInt64
+	var events string
+	err := row.Scan(&webhook.ID, &webhook.URL, &workspaceID, &events, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		webhook.WorkspaceID = &id
+	}
+	if err := json.Unmarshal([]byte(events), &webhook.Events); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// GetAll retrieves every webhook of the workspace
+func (m *WebhookModel) GetAll() ([]*Webhook, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	rows, err := m.DB.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE `+workspace+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetByID retrieves a webhook by its ID, or fails with ErrNotFound
+func (m *WebhookModel) GetByID(id int) (*Webhook, error) {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	webhook, err := scanWebhook(m.DB.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ? AND `+workspace, append([]interface{}{id}, args...)...))
+	return webhook, notFound(err)
+}
+
+// Create inserts a new webhook, generating its secret if the request has none
+func (m *WebhookModel) Create(req WebhookRequest) (*Webhook, error) {
+	if err := checkWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	owner, err := ownerWorkspace(m.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(key)
+	}
+	events, err := json.Marshal(req.Events)
+	if err != nil {
+		return nil, err
+	}
+	active := req.Active == nil || *req.Active
+
+	now := time.Now().UTC()
+	var id int64
+	err = m.DB.QueryRow(`
+		INSERT INTO webhooks (url, workspace_id, events, secret, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, req.URL, owner, string(events), secret, active, now, now).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Webhook{
+		ID:          int(id),
+		URL:         req.URL,
+		WorkspaceID: owner,
+		Events:      req.Events,
+		Secret:      secret,
+		Active:      active,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// Update modifies an existing webhook. Its secret is only replaced if the
+// request has one.
+func (m *WebhookModel) Update(id int, req WebhookRequest) (*Webhook, error) {
+	if err := checkWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	events, err := json.Marshal(req.Events)
+	if err != nil {
+		return nil, err
+	}
+	active := req.Active == nil || *req.Active
+
+	workspace, args := workspaceClause(m.WorkspaceID)
+	result, err := m.DB.Exec(`
+		UPDATE webhooks
+		SET url = ?, events = ?, secret = COALESCE(NULLIF(?, ''), secret), active = ?, updated_at = ?
+		WHERE id = ? AND `+workspace,
+		append([]interface{}{req.URL, string(events), req.Secret, active, time.Now().UTC(), id}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	return m.GetByID(id)
+}
+
+// Delete removes a webhook along with its deliveries
+func (m *WebhookModel) Delete(id int) error {
+	workspace, args := workspaceClause(m.WorkspaceID)
+	result, err := m.DB.Exec(`DELETE FROM webhooks WHERE id = ? AND `+workspace, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// checkWebhookURL returns ErrInvalidWebhookURL unless rawURL is an absolute http or https URL
+func checkWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+// enqueueDeliveries queues an event for every active webhook of the todo's
+// workspace subscribed to its type
+func enqueueDeliveries(db dbtx, event TodoEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// events holds a JSON array of event type strings, none of which contain quotes
+	ids, err := queryIDs(db, `SELECT id FROM webhooks WHERE active AND events LIKE ? AND workspace_id IS NOT DISTINCT FROM ?`,
+		`%"`+string(event.Type)+`"%`, event.Todo.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err := db.Exec(`
+			INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, id, event.ID, event.Type, string(payload), event.CreatedAt, event.CreatedAt, event.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryColumns selects a delivery
+const deliveryColumns = `
+	id, webhook_id, event_id, event_type, payload, status, attempts, response_status,
+	last_error, next_attempt_at, delivered_at, created_at, updated_at
+`
+
+// scanDelivery reads a delivery selected with deliveryColumns
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var payload string
+	var responseStatus sql.NullInt64
+	var nextAttemptAt, deliveredAt sql.NullTime
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&responseStatus,
+		&delivery.LastError,
+		&nextAttemptAt,
+		&deliveredAt,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = json.RawMessage(payload)
+	if responseStatus.Valid {
+		status := int(responseStatus.Int64)
+		delivery.ResponseStatus = &status
+	}
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return delivery, nil
+}
+
+// Deliveries lists up to limit of a webhook's deliveries, newest first,
+// optionally only those with the given status
+func (m *WebhookModel) Deliveries(webhookID int, status DeliveryStatus, limit int) ([]*WebhookDelivery, error) {
+	if _, err := m.GetByID(webhookID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = ?`
+	args := []interface{}{webhookID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// GetDelivery retrieves one of a webhook's deliveries, or fails with ErrNotFound
+func (m *WebhookModel) GetDelivery(webhookID, id int) (*WebhookDelivery, error) {
+	if _, err := m.GetByID(webhookID); err != nil {
+		return nil, err
+	}
+
+	delivery, err := scanDelivery(m.DB.QueryRow(`
+		SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? AND id = ?
+	`, webhookID, id))
+	return delivery, notFound(err)
+}
+
+// Replay queues a delivery's event to be sent again as a new delivery, and
+// returns the new delivery
+func (m *WebhookModel) Replay(webhookID, id int) (*WebhookDelivery, error) {
+	delivery, err := m.GetDelivery(webhookID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var newID int
+	err = m.DB.QueryRow(`
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, webhookID, delivery.EventID, delivery.EventType, string(delivery.Payload), now, now, now).Scan(&newID)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetDelivery(webhookID, newID)
+}
+
+// DeliverDue sends every pending delivery that is due at now to its webhook,
+// and returns how many were attempted. Deliveries to inactive webhooks wait
+// until they are activated again. Up to webhookConcurrency endpoints are sent
+// to at once, each one its deliveries in order. Once an endpoint fails, its
+// later deliveries wait until the failed one is retried, so a slow or broken
+// endpoint only holds up its own and receives them in order.
+func (m *WebhookModel) DeliverDue(now time.Time) (int, error) {
+	now = now.UTC()
+	attempted := 0
+	after := 0
+	// Endpoints that failed during this call are skipped in later batches
+	failed := map[string]bool{}
+	for {
+		due, err := m.dueDeliveries(now, after)
+		if err != nil {
+			return attempted, err
+		}
+		if len(due) > 0 {
+			after = due[len(due)-1].delivery.ID
+		}
+
+		for _, a := range m.sendBatch(due, failed) {
+			if err := m.recordAttempt(a.delivery, a.status, a.err, now); err != nil {
+				return attempted, err
+			}
+			attempted++
+		}
+
+		if len(due) < webhookBatchSize {
+			return attempted, nil
+		}
+	}
+}
+
+// attempt is the outcome of sending a delivery
+type attempt struct {
+	delivery *WebhookDelivery
+	status   int
+	err      error
+}
+
+// sendBatch sends deliveries to up to webhookConcurrency endpoints at once,
+// and each endpoint's deliveries in order until one fails. Endpoints in failed
+// are skipped, and those that fail are added to it. It returns the deliveries
+// that were attempted, so they can be recorded one at a time.
+func (m *WebhookModel) sendBatch(due []dueDelivery, failed map[string]bool) []attempt {
+	var endpoints [][]dueDelivery
+	byURL := map[string]int{}
+	for _, d := range due {
+		if failed[d.url] {
+			continue
+		}
+		i, ok := byURL[d.url]
+		if !ok {
+			i = len(endpoints)
+			byURL[d.url] = i
+			endpoints = append(endpoints, nil)
+		}
+		endpoints[i] = append(endpoints[i], d)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var attempts []attempt
+	slots := make(chan struct{}, webhookConcurrency)
+	for _, deliveries := range endpoints {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(deliveries []dueDelivery) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			for _, d := range deliveries {
+				status, err := m.send(d)
+				mu.Lock()
+				attempts = append(attempts, attempt{delivery: d.delivery, status: status, err: err})
+				if err != nil {
+					failed[d.url] = true
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(deliveries)
+	}
+	wg.Wait()
+
+	return attempts
+}
+
+// dueDelivery is a delivery along with where it is going
+type dueDelivery struct {
+	delivery *WebhookDelivery
+	url      string
+	secret   string
+}
+
+// dueDeliveries returns the oldest pending deliveries due at now with IDs
+// after the given one. Deliveries behind an earlier one of their webhook that
+// is waiting to be retried are left out, so they are not sent before it.
+func (m *WebhookModel) dueDeliveries(now time.Time, after int) ([]dueDelivery, error) {
+	rows, err := m.DB.Query(`
+		SELECT webhook_deliveries.id, webhook_deliveries.event_type, webhook_deliveries.payload,
+			webhook_deliveries.attempts, webhooks.url, webhooks.secret
+		FROM webhook_deliveries JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+		WHERE webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ? AND webhooks.active
+			AND webhook_deliveries.id > ?
+			AND NOT EXISTS (
+				SELECT 1 FROM webhook_deliveries earlier
+				WHERE earlier.webhook_id = webhook_deliveries.webhook_id AND earlier.id < webhook_deliveries.id
+					AND earlier.status = ? AND earlier.next_attempt_at > ?
+			)
+		ORDER BY webhook_deliveries.id
+		LIMIT ?
+	`, DeliveryPending, now, after, DeliveryPending, now, webhookBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueDelivery
+	for rows.Next() {
+		d := dueDelivery{delivery: &WebhookDelivery{}}
+		var payload string
+		if err := rows.Scan(&d.delivery.ID, &d.delivery.EventType, &payload, &d.delivery.Attempts, &d.url, &d.secret); err != nil {
+			return nil, err
+		}
+		d.delivery.Payload = json.RawMessage(payload)
+		due = append(due, d)
+	}
+
+	return due, rows.Err()
+}
+
+// send posts a delivery to its webhook and returns the response status, if there was one
+func (m *WebhookModel) send(d dueDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-todo-api-webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, string(d.delivery.EventType))
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(d.delivery.ID))
+	timestamp := time.Now().Unix()
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(d.secret, timestamp, d.delivery.Payload))
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt logs the outcome of an attempt. A failed delivery is retried
+// with exponential backoff until it has been tried WebhookMaxAttempts times.
+func (m *WebhookModel) recordAttempt(delivery *WebhookDelivery, status int, sendErr error, now time.Time) error {
+	attempts := delivery.Attempts + 1
+	var responseStatus interface{}
+	if status != 0 {
+		responseStatus = status
+	}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = m.DB.Exec(`
+			UPDATE webhook_deliveries
+			SET status = ?, attempts = ?, response_status = ?, last_error = '', next_attempt_at = NULL, delivered_at = ?, updated_at = ?
+			WHERE id = ?
+		`, DeliverySucceeded, attempts, responseStatus, now, now, delivery.ID)
+	case attempts >= WebhookMaxAttempts:
+		_, err = m.DB.Exec(`
+			UPDATE webhook_deliveries
+			SET status = ?, attempts = ?, response_status = ?, last_error = ?, next_attempt_at = NULL, updated_at = ?
+			WHERE id = ?
+		`, DeliveryFailed, attempts, responseStatus, sendErr.Error(), now, delivery.ID)
+	default:
+		_, err = m.DB.Exec(`
+			UPDATE webhook_deliveries
+			SET attempts = ?, response_status = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+			WHERE id = ?
+		`, attempts, responseStatus, sendErr.Error(), now.Add(WebhookRetryDelay<<(attempts-1)), now, delivery.ID)
+	}
+	return err
+}
+
+// PurgeDeliveries deletes finished deliveries last updated before the given
+// time and returns how many were removed
+func (m *WebhookModel) PurgeDeliveries(before time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND updated_at < ?`, DeliveryPending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,491 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Role is what a member may do in a workspace. Each role may do everything
+// the roles below it may.
+type Role string
+
+const (
+	// RoleOwner may also delete the workspace and make or remove owners
+	RoleOwner Role = "owner"
+	// RoleAdmin may also manage members and webhooks, rename the workspace
+	// and empty the trash
+	RoleAdmin Role = "admin"
+	// RoleEditor may also change todos, projects and tags
+	RoleEditor Role = "editor"
+	// RoleViewer may only read
+	RoleViewer Role = "viewer"
+)
+
+// roleRanks orders the roles from least to most allowed
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+// AtLeast reports whether the role may do everything min may
+func (r Role) AtLeast(min Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[min]
+}
+
+// personalWorkspaceName is the name of the workspace every user gets
+const personalWorkspaceName = "Personal"
+
+// AllWorkspaces is the workspace of models that work on the rows of every
+// workspace, which only background jobs should use. Requests work through
+// ForWorkspace, and a model limited to workspace 0 sees nothing and creates
+// nothing.
+const AllWorkspaces = -1
+
+var (
+	// ErrInsufficientRole is returned when the user's role in a workspace does not allow a change
+	ErrInsufficientRole = newError(ErrForbidden, "", "your role in this workspace does not allow this")
+	// ErrPersonalWorkspace is returned when sharing or deleting a personal workspace
+	ErrPersonalWorkspace = newError(ErrForbidden, "", "personal workspaces cannot be shared or deleted")
+	// ErrLastOwner is returned when a change would leave a workspace without an owner
+	ErrLastOwner = newError(ErrConflict, "role", "a workspace must keep at least one owner")
+	// ErrAlreadyMember is returned when adding a user who is already a member
+	ErrAlreadyMember = newError(ErrConflict, "email", "user is already a member of this workspace")
+	// ErrUnknownMember is returned when adding an email address that has no account
+	ErrUnknownMember = newError(ErrValidation, "email", "no account has this email address")
+	// ErrNoWorkspace is returned when creating a record through a model that
+	// is limited to no workspace
+	ErrNoWorkspace = newError(ErrForbidden, "", "no workspace was chosen for this request")
+)
+
+// Workspace holds the todos, projects, tags and webhooks its members share
+type Workspace struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Personal is set on the workspace every user gets, which cannot be
+	// shared or deleted
+	Personal bool `json:"personal"`
+	// Role is the role of the user the workspace was read for
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Member is a user's membership of a workspace
+type Member struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkspaceRequest represents the request body for creating or renaming a workspace
+type WorkspaceRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// AddMemberRequest represents the request body for adding a member to a workspace
+type AddMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  Role   `json:"role" binding:"required,oneof=owner admin editor viewer"`
+}
+
+// MemberRoleRequest represents the request body for changing a member's role
+type MemberRoleRequest struct {
+	Role Role `json:"role" binding:"required,oneof=owner admin editor viewer"`
+}
+
+// WorkspaceModel handles database operations for workspaces and their members
+type WorkspaceModel struct {
+	DB *sql.DB
+}
+
+// NewWorkspaceModel creates a new WorkspaceModel instance
+func NewWorkspaceModel(db *sql.DB) *WorkspaceModel {
+	return &WorkspaceModel{DB: db}
+}
+
+// workspaceColumns selects a workspace joined with one of its members
+const workspaceColumns = `
+	workspaces.id, workspaces.name, workspaces.personal_user_id, workspace_members.role,
+	workspaces.created_at, workspaces.updated_at
+`
+
+// scanWorkspace reads a workspace selected with workspaceColumns
+func scanWorkspace(row rowScanner) (*Workspace, error) {
+	workspace := &Workspace{}
+	var personalUserID sql.NullInt64
+	err := row.Scan(&workspace.ID, &workspace.Name, &personalUserID, &workspace.Role, &workspace.CreatedAt, &workspace.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	workspace.Personal = personalUserID.Valid
+	return workspace, nil
+}
+
+// membershipQuery selects the workspaces a user is a member of
+const membershipQuery = `
+	SELECT ` + workspaceColumns + `
+	FROM workspaces JOIN workspace_members ON workspace_members.workspace_id = workspaces.id
+	WHERE workspace_members.user_id = ?
+`
+
+// ListForUser retrieves the workspaces a user is a member of, their personal
+// workspace first
+func (m *WorkspaceModel) ListForUser(userID int) ([]*Workspace, error) {
+	rows, err := m.DB.Query(membershipQuery+` ORDER BY workspaces.personal_user_id IS NULL, workspaces.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := make([]*Workspace, 0)
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+
+	return workspaces, rows.Err()
+}
+
+// Membership retrieves a workspace along with the user's role in it. It fails
+// with ErrNotFound if the user is not a member, so workspaces of others are
+// not given away.
+func (m *WorkspaceModel) Membership(userID, workspaceID int) (*Workspace, error) {
+	workspace, err := scanWorkspace(m.DB.QueryRow(membershipQuery+` AND workspaces.id = ?`, userID, workspaceID))
+	return workspace, notFound(err)
+}
+
+// Personal retrieves a user's personal workspace
+func (m *WorkspaceModel) Personal(userID int) (*Workspace, error) {
+	workspace, err := scanWorkspace(m.DB.QueryRow(membershipQuery+` AND workspaces.personal_user_id = ?`, userID, userID))
+	return workspace, notFound(err)
+}
+
+// Create creates a shared workspace with the user as its owner
+func (m *WorkspaceModel) Create(userID int, req WorkspaceRequest) (*Workspace, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	workspace, err := createWorkspace(tx, userID, strings.TrimSpace(req.Name), false, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// createWorkspace inserts a workspace owned by a user using the given connection
+func createWorkspace(db dbtx, userID int, name string, personal bool, now time.Time) (*Workspace, error) {
+	var personalUserID interface{}
+	if personal {
+		personalUserID = userID
+	}
+
+	workspace := &Workspace{Name: name, Personal: personal, Role: RoleOwner, CreatedAt: now, UpdatedAt: now}
+	err := db.QueryRow(`
+		INSERT INTO workspaces (name, personal_user_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`, name, personalUserID, now, now).Scan(&workspace.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`,
+		workspace.ID, userID, RoleOwner, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace, nil
+}
+
+// Rename changes the name of a workspace the user is a member of and returns it
+func (m *WorkspaceModel) Rename(workspace *Workspace, req WorkspaceRequest) (*Workspace, error) {
+	if !workspace.Role.AtLeast(RoleAdmin) {
+		return nil, ErrInsufficientRole
+	}
+
+	now := time.Now().UTC()
+	name := strings.TrimSpace(req.Name)
+	if _, err := m.DB.Exec(`UPDATE workspaces SET name = ?, updated_at = ? WHERE id = ?`, name, now, workspace.ID); err != nil {
+		return nil, err
+	}
+
+	renamed := *workspace
+	renamed.Name = name
+	renamed.UpdatedAt = now
+	return &renamed, nil
+}
+
+// Delete deletes a shared workspace along with everything in it. Only owners
+// may delete a workspace.
+func (m *WorkspaceModel) Delete(workspace *Workspace) error {
+	if workspace.Personal {
+		return ErrPersonalWorkspace
+	}
+	if !workspace.Role.AtLeast(RoleOwner) {
+		return ErrInsufficientRole
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// SQLite cannot add foreign keys to existing tables, so the workspace's
+	// rows are deleted here rather than by cascading. Todos go before the
+	// projects they are in, and take their tags and subtasks with them.
+	for _, table := range []string{"todos", "todo_events", "projects", "tags", "webhooks", "workspaces"} {
+		column := "workspace_id"
+		if table == "workspaces" {
+			column = "id"
+		}
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE `+column+` = ?`, workspace.ID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ClaimResult counts the rows ClaimUnowned moved into a workspace
+type ClaimResult struct {
+	Todos, Projects, Tags, Webhooks int64
+}
+
+// ClaimUnowned moves the todos, projects, tags and webhooks that belong to
+// no workspace into one. Databases from before accounts or workspaces keep
+// such rows, which no request can reach until they are claimed. A project
+// that todos of other workspaces are in stays out of every workspace, and a
+// tag whose name the workspace already uses is merged into that tag.
+func (m *WorkspaceModel) ClaimUnowned(workspaceID int) (*ClaimResult, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM workspaces WHERE id = ?`, workspaceID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrNotFound
+	}
+
+	// Nobody could see the claimed todos before, so no event announces them.
+	// Each step adds the rows it changed to its count, if it has one.
+	result := &ClaimResult{}
+	ws := []interface{}{workspaceID}
+	steps := []struct {
+		count *int64
+		query string
+		args  []interface{}
+	}{
+		{&result.Todos, `UPDATE todos SET workspace_id = ? WHERE workspace_id IS NULL`, ws},
+		{nil, `UPDATE todo_events SET workspace_id = ? WHERE workspace_id IS NULL`, ws},
+		{&result.Projects, `
+			UPDATE projects SET workspace_id = ?
+			WHERE workspace_id IS NULL
+			AND NOT EXISTS (SELECT 1 FROM todos WHERE todos.project_id = projects.id AND todos.workspace_id <> ?)
+		`, []interface{}{workspaceID, workspaceID}},
+		{nil, `
+			INSERT INTO todo_tags (todo_id, tag_id)
+			SELECT todo_tags.todo_id, claimed.id
+			FROM todo_tags
+			JOIN tags AS unowned ON unowned.id = todo_tags.tag_id
+			JOIN tags AS claimed ON claimed.name = unowned.name AND claimed.workspace_id = ?
+			WHERE unowned.workspace_id IS NULL
+			ON CONFLICT DO NOTHING
+		`, ws},
+		{&result.Tags, `
+			DELETE FROM tags
+			WHERE workspace_id IS NULL AND name IN (SELECT name FROM tags WHERE workspace_id = ?)
+		`, ws},
+		{&result.Tags, `UPDATE tags SET workspace_id = ? WHERE workspace_id IS NULL`, ws},
+		{&result.Webhooks, `UPDATE webhooks SET workspace_id = ? WHERE workspace_id IS NULL`, ws},
+	}
+	for _, step := range steps {
+		res, err := tx.Exec(step.query, step.args...)
+		if err != nil {
+			return nil, err
+		}
+		if step.count == nil {
+			continue
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		*step.count += affected
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Members lists the members of a workspace in the order they joined
+func (m *WorkspaceModel) Members(workspaceID int) ([]*Member, error) {
+	rows, err := m.DB.Query(`
+		SELECT users.id, users.email, users.name, workspace_members.role, workspace_members.created_at
+		FROM workspace_members JOIN users ON users.id = workspace_members.user_id
+		WHERE workspace_members.workspace_id = ?
+		ORDER BY workspace_members.created_at, users.id
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]*Member, 0)
+	for rows.Next() {
+		member := &Member{}
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Name, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// AddMember adds the user with an email address to a shared workspace. Only
+// owners may add owners.
+func (m *WorkspaceModel) AddMember(workspace *Workspace, req AddMemberRequest) (*Member, error) {
+	if workspace.Personal {
+		return nil, ErrPersonalWorkspace
+	}
+	if !workspace.Role.AtLeast(RoleAdmin) || !workspace.Role.AtLeast(req.Role) {
+		return nil, ErrInsufficientRole
+	}
+
+	member := &Member{Role: req.Role, CreatedAt: time.Now().UTC()}
+	err := m.DB.QueryRow(`SELECT id, email, name FROM users WHERE email = ?`, normalizeEmail(req.Email)).
+		Scan(&member.UserID, &member.Email, &member.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnknownMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := memberRole(m.DB, workspace.ID, member.UserID); err == nil {
+		return nil, ErrAlreadyMember
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	_, err = m.DB.Exec(`INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`,
+		workspace.ID, member.UserID, member.Role, member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// UpdateMember changes a member's role. Only owners may change the role of
+// owners or make others owners.
+func (m *WorkspaceModel) UpdateMember(workspace *Workspace, userID int, req MemberRoleRequest) error {
+	return m.changeMember(workspace, userID, RoleAdmin, func(tx *sql.Tx, current Role) error {
+		if !workspace.Role.AtLeast(req.Role) {
+			return ErrInsufficientRole
+		}
+		if current == RoleOwner && req.Role != RoleOwner {
+			if err := checkOtherOwners(tx, workspace.ID, userID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?`, req.Role, workspace.ID, userID)
+		return err
+	})
+}
+
+// RemoveMember takes a user out of a workspace on behalf of callerID. Any
+// member may leave, but only owners may remove owners.
+func (m *WorkspaceModel) RemoveMember(workspace *Workspace, callerID, userID int) error {
+	minRole := RoleAdmin
+	if userID == callerID {
+		minRole = RoleViewer
+	}
+	return m.changeMember(workspace, userID, minRole, func(tx *sql.Tx, current Role) error {
+		if current == RoleOwner {
+			if err := checkOtherOwners(tx, workspace.ID, userID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, workspace.ID, userID)
+		return err
+	})
+}
+
+// changeMember runs a change to a member of a shared workspace in a
+// transaction, once the user's role has been checked against minRole and the
+// member's
+func (m *WorkspaceModel) changeMember(workspace *Workspace, userID int, minRole Role, change func(tx *sql.Tx, current Role) error) error {
+	if workspace.Personal {
+		return ErrPersonalWorkspace
+	}
+	if !workspace.Role.AtLeast(minRole) {
+		return ErrInsufficientRole
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := memberRole(tx, workspace.ID, userID)
+	if err != nil {
+		return err
+	}
+	if !workspace.Role.AtLeast(current) {
+		return ErrInsufficientRole
+	}
+	if err := change(tx, current); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// memberRole returns a member's role, or fails with ErrNotFound
+func memberRole(db dbtx, workspaceID, userID int) (Role, error) {
+	var role Role
+	err := db.QueryRow(`SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, workspaceID, userID).Scan(&role)
+	return role, notFound(err)
+}
+
+// checkOtherOwners returns ErrLastOwner unless the workspace has an owner
+// besides the user
+func checkOtherOwners(db dbtx, workspaceID, userID int) error {
+	var owners int
+	err := db.QueryRow(`SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = ? AND user_id != ?`,
+		workspaceID, RoleOwner, userID).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
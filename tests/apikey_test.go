@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestAPIKeys tests creating, using and revoking API keys, and the scopes
+// they are limited to
+func TestAPIKeys(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_apikeys.db")
+
+	userModel := models.NewUserModel(db)
+	apiKeyModel := models.NewAPIKeyModel(db)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyModel)
+	issuer := newTestTokenIssuer(t, time.Minute)
+	todoHandler := handlers.NewTodoHandler(models.NewTodoModel(db))
+	tagHandler := handlers.NewTagHandler(models.NewTagModel(db, nil))
+	workspace := handlers.Workspace(models.NewWorkspaceModel(db))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(handlers.Authenticate(issuer, apiKeyModel))
+	private := router.Group("", handlers.RequireUser())
+	apiKeys := private.Group("/api-keys", handlers.RequireLogin())
+	apiKeys.GET("", apiKeyHandler.GetAPIKeys)
+	apiKeys.POST("", apiKeyHandler.CreateAPIKey)
+	apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey)
+	todos := private.Group("/todos", handlers.RequireScope("todos"), workspace)
+	todos.GET("", todoHandler.GetTodos)
+	todos.POST("", todoHandler.CreateTodo)
+	tags := private.Group("/tags", handlers.RequireScope("tags"), workspace)
+	tags.GET("", tagHandler.GetTags)
+
+	send := func(method, url string, header http.Header, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+
+	loginAs := func(email string) http.Header {
+		user, err := userModel.Register(models.RegisterRequest{Email: email, Password: "correct horse"})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		token, _, err := issuer.Issue(user)
+		assert.NoError(t, err)
+		return bearer(token)
+	}
+	alice := loginAs("alice@example.com")
+	bob := loginAs("bob@example.com")
+
+	createKey := func(login http.Header, body string) models.NewAPIKey {
+		w := send("POST", "/api-keys", login, body)
+		if !assert.Equal(t, http.StatusCreated, w.Code) {
+			t.FailNow()
+		}
+		var key models.NewAPIKey
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &key))
+		return key
+	}
+
+	var reader, writer models.NewAPIKey
+	t.Run("Create And List", func(t *testing.T) {
+		reader = createKey(alice, `{"name": "Dashboard", "scopes": ["todos:read", "todos:read"]}`)
+		assert.True(t, strings.HasPrefix(reader.Key, "tda_"))
+		assert.Equal(t, reader.Key[:12], reader.Prefix)
+		assert.Equal(t, "Dashboard", reader.Name)
+		assert.Equal(t, []string{"todos:read"}, reader.Scopes)
+		assert.Nil(t, reader.ExpiresAt)
+
+		expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		writer = createKey(alice, `{"name": "CI", "scopes": ["todos:read", "todos:write"], "expires_at": "`+expiresAt.Format(time.RFC3339)+`"}`)
+		if assert.NotNil(t, writer.ExpiresAt) {
+			assert.True(t, expiresAt.Equal(*writer.ExpiresAt))
+		}
+
+		// The key itself is only shown once, and only its hash is stored
+		w := send("GET", "/api-keys", alice, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), reader.Key)
+		var keys []models.APIKey
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+		if assert.Len(t, keys, 2) {
+			assert.Equal(t, writer.ID, keys[0].ID)
+			assert.Equal(t, reader.Prefix, keys[1].Prefix)
+		}
+		var stored int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE key_hash = ?`, reader.Key).Scan(&stored))
+		assert.Equal(t, 0, stored)
+
+		// Other users do not see them
+		w = send("GET", "/api-keys", bob, "")
+		assert.Equal(t, "[]", w.Body.String())
+	})
+
+	t.Run("Invalid Keys", func(t *testing.T) {
+		w := send("POST", "/api-keys", alice, `{"name": "Admin", "scopes": ["everything"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = send("POST", "/api-keys", alice, `{"name": "Nothing", "scopes": []}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("POST", "/api-keys", alice, `{"name": "Stale", "scopes": ["todos:read"], "expires_at": "2020-01-01T00:00:00Z"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var problem handlers.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, []handlers.InvalidParam{{Name: "expires_at", Reason: "expires_at must be in the future"}}, problem.InvalidParams)
+	})
+
+	t.Run("Scopes", func(t *testing.T) {
+		// Keys work in either header
+		assert.Equal(t, http.StatusOK, send("GET", "/todos", bearer(reader.Key), "").Code)
+		assert.Equal(t, http.StatusOK, send("GET", "/todos", http.Header{"X-Api-Key": {reader.Key}}, "").Code)
+
+		w := send("POST", "/todos", bearer(reader.Key), `{"title": "Not allowed"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "todos:write")
+		assert.Equal(t, http.StatusForbidden, send("GET", "/tags", bearer(writer.Key), "").Code)
+
+		// Todos created with a key belong to its user
+		w = send("POST", "/todos", http.Header{"X-Api-Key": {writer.Key}}, `{"title": "From CI"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var todo models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+		w = send("GET", "/todos", alice, "")
+		assert.Contains(t, w.Body.String(), "From CI")
+		w = send("GET", "/todos", bob, "")
+		assert.NotContains(t, w.Body.String(), "From CI")
+
+		// Keys cannot manage keys
+		assert.Equal(t, http.StatusForbidden, send("GET", "/api-keys", bearer(writer.Key), "").Code)
+		assert.Equal(t, http.StatusForbidden, send("POST", "/api-keys", bearer(writer.Key), `{"name": "More", "scopes": ["todos:write"]}`).Code)
+
+		// Using a key is recorded
+		var userID int
+		assert.NoError(t, db.QueryRow(`SELECT user_id FROM api_keys WHERE id = ?`, writer.ID).Scan(&userID))
+		keys, err := apiKeyModel.List(userID)
+		assert.NoError(t, err)
+		assert.Len(t, keys, 2)
+		for _, key := range keys {
+			assert.NotNil(t, key.LastUsedAt, key.Name)
+		}
+	})
+
+	t.Run("Revoke And Expire", func(t *testing.T) {
+		url := "/api-keys/" + strconv.Itoa(reader.ID)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", url, bob, "").Code)
+		assert.Equal(t, http.StatusOK, send("DELETE", url, alice, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", url, alice, "").Code)
+
+		w := send("GET", "/todos", bearer(reader.Key), "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+		_, err := db.Exec(`UPDATE api_keys SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Minute).UTC(), writer.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/todos", bearer(writer.Key), "").Code)
+
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/todos", bearer("tda_not-a-real-key"), "").Code)
+	})
+}
@@ -0,0 +1,369 @@
+package tests
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// newTestTokenIssuer returns a TokenIssuer signing HS256 tokens that last ttl
+func newTestTokenIssuer(t *testing.T, ttl time.Duration) *models.TokenIssuer {
+	key, err := models.NewHMACKey(bytes.Repeat([]byte("k"), 32))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	issuer, err := models.NewTokenIssuer(key, nil, ttl)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return issuer
+}
+
+// TestAuth tests registering, logging in and out, refreshing tokens and
+// keeping each user's todos to themselves
+func TestAuth(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_auth.db")
+
+	userModel := models.NewUserModel(db)
+	issuer := newTestTokenIssuer(t, time.Minute)
+	authHandler := handlers.NewAuthHandler(userModel, issuer, time.Hour)
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	idempotencyModel := models.NewIdempotencyModel(db)
+	workspaceModel := models.NewWorkspaceModel(db)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(handlers.Authenticate(issuer, models.NewAPIKeyModel(db)), handlers.Idempotency(idempotencyModel, time.Hour))
+	router.POST("/auth/register", authHandler.Register)
+	router.POST("/auth/login", authHandler.Login)
+	router.POST("/auth/refresh", authHandler.Refresh)
+	router.POST("/auth/logout", authHandler.Logout)
+	private := router.Group("", handlers.RequireUser())
+	private.GET("/auth/me", authHandler.Me)
+	todos := private.Group("/todos", handlers.Workspace(workspaceModel))
+	todos.GET("", todoHandler.GetTodos)
+	todos.POST("", todoHandler.CreateTodo)
+	todos.GET("/:id", todoHandler.GetTodo)
+	todos.PUT("/:id", todoHandler.UpdateTodo)
+	todos.DELETE("/:id", todoHandler.DeleteTodo)
+
+	send := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	readTokens := func(w *httptest.ResponseRecorder) models.TokenPair {
+		if !assert.Equal(t, http.StatusOK, w.Code) {
+			t.FailNow()
+		}
+		var tokens models.TokenPair
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.Equal(t, "Bearer", tokens.TokenType)
+		assert.Equal(t, 60, tokens.ExpiresIn)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), tokens.RefreshExpiresAt, time.Minute)
+		return tokens
+	}
+
+	login := func(email, password string) models.TokenPair {
+		return readTokens(send("POST", "/auth/login", "", `{"email": "`+email+`", "password": "`+password+`"}`))
+	}
+
+	var alice models.User
+	t.Run("Register", func(t *testing.T) {
+		w := send("POST", "/auth/register", "", `{"email": "Alice@Example.com", "name": "Alice", "password": "correct horse"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &alice))
+		assert.Equal(t, "alice@example.com", alice.Email)
+		assert.Equal(t, "Alice", alice.Name)
+		assert.NotContains(t, w.Body.String(), "password")
+
+		// The password is stored as a bcrypt hash
+		user, err := userModel.GetByID(alice.ID)
+		assert.NoError(t, err)
+		assert.Contains(t, user.PasswordHash, "$2a$")
+
+		w = send("POST", "/auth/register", "", `{"email": "ALICE@example.com", "password": "another one"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = send("POST", "/auth/register", "", `{"email": "not an email", "password": "short"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var problem handlers.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.ElementsMatch(t, []handlers.InvalidParam{
+			{Name: "email", Reason: "must be an email address"},
+			{Name: "password", Reason: "must be at least 8 characters"},
+		}, problem.InvalidParams)
+	})
+
+	t.Run("Login And Logout", func(t *testing.T) {
+		w := send("POST", "/auth/login", "", `{"email": "alice@example.com", "password": "wrong horse"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		w = send("POST", "/auth/login", "", `{"email": "nobody@example.com", "password": "correct horse"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		tokens := login("ALICE@example.com", "correct horse")
+		assert.Equal(t, alice.ID, tokens.User.ID)
+		w = send("GET", "/auth/me", tokens.AccessToken, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var me models.User
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+		assert.Equal(t, alice.ID, me.ID)
+
+		// The refresh token is not an access token
+		w = send("GET", "/auth/me", tokens.RefreshToken, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+		w = send("POST", "/auth/logout", "", `{"refresh_token": "`+tokens.RefreshToken+`"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = send("POST", "/auth/refresh", "", `{"refresh_token": "`+tokens.RefreshToken+`"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = send("GET", "/auth/me", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = send("GET", "/todos", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = send("GET", "/todos", "not.a.token", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		first := login("alice@example.com", "correct horse")
+		second := readTokens(send("POST", "/auth/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`))
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		assert.Equal(t, http.StatusOK, send("GET", "/auth/me", second.AccessToken, "").Code)
+
+		// Each refresh token works once
+		w := send("POST", "/auth/refresh", "", `{"refresh_token": "`+first.RefreshToken+`"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		readTokens(send("POST", "/auth/refresh", "", `{"refresh_token": "`+second.RefreshToken+`"}`))
+
+		// Refresh tokens are stored hashed
+		var stored int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE token_hash = ?`, second.RefreshToken).Scan(&stored))
+		assert.Equal(t, 0, stored)
+	})
+
+	t.Run("Expired Tokens", func(t *testing.T) {
+		session, err := userModel.Login("alice@example.com", "correct horse", -time.Minute)
+		assert.NoError(t, err)
+		w := send("POST", "/auth/refresh", "", `{"refresh_token": "`+session.Token+`"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		purged, err := userModel.PurgeSessions(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+
+		expired, _, err := newTestTokenIssuer(t, -time.Minute).Issue(&alice)
+		assert.NoError(t, err)
+		w = send("GET", "/auth/me", expired, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Todos Are Private", func(t *testing.T) {
+		w := send("POST", "/auth/register", "", `{"email": "bob@example.com", "password": "battery staple"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		aliceToken := login("alice@example.com", "correct horse").AccessToken
+		bobToken := login("bob@example.com", "battery staple").AccessToken
+
+		w = send("POST", "/todos", aliceToken, `{"title": "Alice's secret"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var todo models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+		personal, err := workspaceModel.Personal(alice.ID)
+		if !assert.NoError(t, err) || !assert.NotNil(t, todo.WorkspaceID) {
+			t.FailNow()
+		}
+		assert.Equal(t, personal.ID, *todo.WorkspaceID)
+		url := "/todos/" + strconv.Itoa(todo.ID)
+
+		assert.Equal(t, http.StatusOK, send("GET", url, aliceToken, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", url, bobToken, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("PUT", url, bobToken, `{"title": "Bob's now"}`).Code)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", url, bobToken, "").Code)
+
+		w = send("GET", "/todos", bobToken, "")
+		var page models.TodoPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Empty(t, page.Todos)
+
+		w = send("GET", "/todos", aliceToken, "")
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Len(t, page.Todos, 1)
+	})
+
+	t.Run("Idempotency Keys Are Per User", func(t *testing.T) {
+		aliceToken := login("alice@example.com", "correct horse").AccessToken
+		bobToken := login("bob@example.com", "battery staple").AccessToken
+		body := `{"title": "Same request"}`
+
+		send := func(token string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("POST", "/todos", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set(handlers.IdempotencyKeyHeader, "shared-key")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		first := send(aliceToken)
+		assert.Equal(t, http.StatusCreated, first.Code)
+		other := send(bobToken)
+		assert.Equal(t, http.StatusCreated, other.Code)
+		assert.Empty(t, other.Header().Get("Idempotent-Replayed"))
+		assert.NotEqual(t, first.Body.String(), other.Body.String())
+
+		retry := send(aliceToken)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+	})
+}
+
+// TestAccessTokens tests signing and verifying access tokens with each kind
+// of key, and rotating keys
+func TestAccessTokens(t *testing.T) {
+	alice := &models.User{ID: 7, Email: "alice@example.com"}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	pemKey := func(private crypto.Signer) *models.SigningKey {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		assert.NoError(t, err)
+		key, err := models.ParseKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return key
+	}
+	pemPublicKey := func(public crypto.PublicKey) *models.SigningKey {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		assert.NoError(t, err)
+		key, err := models.ParseKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return key
+	}
+
+	hmacKey, err := models.NewHMACKey(bytes.Repeat([]byte("s"), 32))
+	assert.NoError(t, err)
+	keys := map[string]*models.SigningKey{
+		"HS256": hmacKey,
+		"RS256": pemKey(rsaKey),
+		"EdDSA": pemKey(edKey),
+	}
+
+	for alg, key := range keys {
+		t.Run(alg, func(t *testing.T) {
+			assert.Equal(t, alg, key.Method.Alg())
+			issuer, err := models.NewTokenIssuer(key, nil, time.Minute)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			token, expiresAt, err := issuer.Issue(alice)
+			assert.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, 5*time.Second)
+
+			principal, err := issuer.Verify(token)
+			assert.NoError(t, err)
+			assert.Equal(t, &models.Principal{UserID: 7, Email: "alice@example.com"}, principal)
+
+			// Changing the claims breaks the signature
+			parts := strings.Split(token, ".")
+			claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			parts[1] = base64.RawURLEncoding.EncodeToString(bytes.Replace(claims, []byte(`"7"`), []byte(`"8"`), 1))
+			_, err = issuer.Verify(strings.Join(parts, "."))
+			assert.ErrorIs(t, err, models.ErrInvalidToken)
+		})
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		old, err := models.NewTokenIssuer(keys["RS256"], nil, time.Minute)
+		assert.NoError(t, err)
+		token, _, err := old.Issue(alice)
+		assert.NoError(t, err)
+
+		// Tokens signed with the retired key are accepted while it is kept
+		rotated, err := models.NewTokenIssuer(keys["EdDSA"], []*models.SigningKey{pemPublicKey(rsaKey.Public())}, time.Minute)
+		assert.NoError(t, err)
+		_, err = rotated.Verify(token)
+		assert.NoError(t, err)
+
+		dropped, err := models.NewTokenIssuer(keys["EdDSA"], nil, time.Minute)
+		assert.NoError(t, err)
+		_, err = dropped.Verify(token)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+		// A public key cannot sign
+		_, err = models.NewTokenIssuer(pemPublicKey(rsaKey.Public()), nil, time.Minute)
+		assert.Error(t, err)
+	})
+
+	t.Run("Algorithm Confusion", func(t *testing.T) {
+		issuer, err := models.NewTokenIssuer(keys["RS256"], nil, time.Minute)
+		assert.NoError(t, err)
+		kid := keys["RS256"].ID
+		claims := jwt.MapClaims{
+			"iss": "todo-api",
+			"sub": "7",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+
+		// An HS256 token keyed with the RSA public key
+		der, _ := x509.MarshalPKIXPublicKey(rsaKey.Public())
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		forged.Header["kid"] = kid
+		token, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		assert.NoError(t, err)
+		_, err = issuer.Verify(token)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+		// An unsigned token
+		unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+		unsigned.Header["kid"] = kid
+		token, err = unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		assert.NoError(t, err)
+		_, err = issuer.Verify(token)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+	})
+
+	t.Run("Short Secret", func(t *testing.T) {
+		_, err := models.NewHMACKey([]byte("too short"))
+		assert.Error(t, err)
+	})
+}
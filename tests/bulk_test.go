@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestBulkTodos tests running several todo operations in one request
+func TestBulkTodos(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_bulk.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.POST("/todos/bulk", todoHandler.BulkTodos)
+
+	bulk := func(body string) (int, handlers.BulkResponse) {
+		req, _ := http.NewRequest("POST", "/todos/bulk", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp handlers.BulkResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	statuses := func(resp handlers.BulkResponse) []int {
+		var out []int
+		for _, result := range resp.Results {
+			out = append(out, result.Status)
+		}
+		return out
+	}
+
+	t.Run("Runs Every Kind Of Operation", func(t *testing.T) {
+		first, _ := todoModel.Create(models.CreateTodoRequest{Title: "Finish report"})
+		second, _ := todoModel.Create(models.CreateTodoRequest{Title: "Old errand"})
+		third, _ := todoModel.Create(models.CreateTodoRequest{Title: "Typo"})
+
+		code, resp := bulk(`{"operations": [
+			{"op": "create", "todo": {"title": "New todo", "tags": ["bulk"]}},
+			{"op": "complete", "id": ` + strconv.Itoa(first.ID) + `},
+			{"op": "delete", "id": ` + strconv.Itoa(second.ID) + `},
+			{"op": "update", "id": ` + strconv.Itoa(third.ID) + `, "version": 1, "todo": {"title": "Fixed"}}
+		]}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, resp.Committed)
+		assert.Equal(t, []int{201, 200, 200, 200}, statuses(resp))
+
+		assert.Equal(t, "New todo", resp.Results[0].Todo.Title)
+		assert.Equal(t, []string{"bulk"}, resp.Results[0].Todo.Tags)
+		assert.True(t, resp.Results[1].Todo.Completed)
+		assert.Nil(t, resp.Results[2].Todo)
+		assert.Equal(t, "Fixed", resp.Results[3].Todo.Title)
+		assert.Equal(t, 2, resp.Results[3].Todo.Version)
+
+		_, err := todoModel.GetByID(second.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("Atomic Failure Rolls Everything Back", func(t *testing.T) {
+		before, _ := todoModel.GetAll()
+		todo, _ := todoModel.Create(models.CreateTodoRequest{Title: "Stays open"})
+
+		code, resp := bulk(`{"operations": [
+			{"op": "create", "todo": {"title": "Rolled back"}},
+			{"op": "complete", "id": ` + strconv.Itoa(todo.ID) + `},
+			{"op": "delete", "id": 9999},
+			{"op": "create", "todo": {"title": "Never attempted"}}
+		]}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.False(t, resp.Committed)
+		assert.Equal(t, []int{424, 424, 404, 424}, statuses(resp))
+		assert.Equal(t, "Rolled back because operation 2 failed", resp.Results[0].Error.Detail)
+		assert.Equal(t, "Not attempted because operation 2 failed", resp.Results[3].Error.Detail)
+
+		after, _ := todoModel.GetAll()
+		assert.Len(t, after, len(before)+1)
+		reloaded, _ := todoModel.GetByID(todo.ID)
+		assert.False(t, reloaded.Completed)
+	})
+
+	t.Run("Best Effort Keeps The Operations That Worked", func(t *testing.T) {
+		todo, _ := todoModel.Create(models.CreateTodoRequest{Title: "Edited elsewhere"})
+
+		code, resp := bulk(`{"mode": "best_effort", "operations": [
+			{"op": "create", "todo": {"title": "Kept"}},
+			{"op": "update", "id": ` + strconv.Itoa(todo.ID) + `, "version": 7, "todo": {"title": "Stale"}},
+			{"op": "create", "todo": {"title": "Orphan", "parent_id": 9999}},
+			{"op": "complete", "id": ` + strconv.Itoa(todo.ID) + `}
+		]}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, resp.Committed)
+		assert.Equal(t, []int{201, 412, 400, 200}, statuses(resp))
+		assert.Equal(t, "parent_id", resp.Results[2].Error.InvalidParams[0].Name)
+
+		kept, err := todoModel.GetByID(resp.Results[0].Todo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Kept", kept.Title)
+
+		reloaded, _ := todoModel.GetByID(todo.ID)
+		assert.Equal(t, "Edited elsewhere", reloaded.Title)
+		assert.True(t, reloaded.Completed)
+	})
+
+	t.Run("Invalid Operations", func(t *testing.T) {
+		body := `{"operations": [
+			{"op": "create", "todo": {"title": "Fine"}},
+			{"op": "create", "todo": {"description": "No title"}},
+			{"op": "archive", "id": 1},
+			{"op": "delete"}
+		]}`
+
+		// Atomic requests with an invalid operation do not run at all
+		before, _ := todoModel.GetAll()
+		code, resp := bulk(body)
+		assert.Equal(t, http.StatusOK, code)
+		assert.False(t, resp.Committed)
+		assert.Equal(t, []int{424, 400, 400, 400}, statuses(resp))
+		assert.Equal(t, "todo.title", resp.Results[1].Error.InvalidParams[0].Name)
+		assert.Equal(t, "op", resp.Results[2].Error.InvalidParams[0].Name)
+		assert.Equal(t, "id", resp.Results[3].Error.InvalidParams[0].Name)
+		after, _ := todoModel.GetAll()
+		assert.Len(t, after, len(before))
+
+		// Best effort requests skip them
+		body = `{"mode": "best_effort", ` + body[1:]
+		_, resp = bulk(body)
+		assert.True(t, resp.Committed)
+		assert.Equal(t, []int{201, 400, 400, 400}, statuses(resp))
+	})
+
+	t.Run("Rejects Malformed Requests", func(t *testing.T) {
+		code, _ := bulk(`{"operations": []}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = bulk(`{"mode": "sometimes", "operations": [{"op": "delete", "id": 1}]}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/database"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// openTestDB opens the database a test runs against, and closes it when the
+// test ends. Tests use a fresh SQLite file at dbPath unless TEST_DATABASE_URL
+// names a Postgres database, which is emptied first.
+func openTestDB(tb testing.TB, dbPath string) *sql.DB {
+	tb.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		db, err := database.InitDB(dbPath)
+		if !assert.NoError(tb, err) {
+			tb.FailNow()
+		}
+		tb.Cleanup(func() {
+			database.CloseDB(db)
+			os.Remove(dbPath)
+		})
+		return db
+	}
+
+	db, err := database.InitPostgres(dsn)
+	if !assert.NoError(tb, err) {
+		tb.FailNow()
+	}
+	tb.Cleanup(func() { database.CloseDB(db) })
+
+	_, err = db.Exec(`
+		TRUNCATE projects, todos, tags, todo_tags, todo_events, idempotency_keys, webhooks, webhook_deliveries,
+			users, sessions, api_keys, workspaces, workspace_members
+		RESTART IDENTITY CASCADE
+	`)
+	if !assert.NoError(tb, err) {
+		tb.FailNow()
+	}
+	_, err = db.Exec(`ALTER SEQUENCE todo_events_id_seq RESTART`)
+	if !assert.NoError(tb, err) {
+		tb.FailNow()
+	}
+
+	return db
+}
+
+// testUsers numbers the users signInTestUser registers
+var testUsers int64
+
+// signInTestUser registers a user and returns middleware that signs requests
+// in as them, unless they have their own Authorization header, so handlers
+// work in the user's personal workspace. The ID of that workspace is
+// returned with it.
+func signInTestUser(t *testing.T, db *sql.DB) (gin.HandlersChain, int) {
+	t.Helper()
+
+	email := fmt.Sprintf("user%d@example.com", atomic.AddInt64(&testUsers, 1))
+	user, err := models.NewUserModel(db).Register(models.RegisterRequest{Email: email, Password: "correct horse"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	issuer := newTestTokenIssuer(t, time.Hour)
+	token, _, err := issuer.Issue(user)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	workspaceModel := models.NewWorkspaceModel(db)
+	workspace, err := workspaceModel.Personal(user.ID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	signIn := func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return gin.HandlersChain{signIn, handlers.Authenticate(issuer, nil), handlers.Workspace(workspaceModel)}, workspace.ID
+}
+
+// TestRebind tests numbering ? placeholders for Postgres
+func TestRebind(t *testing.T) {
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM todos WHERE id = ? AND title = ?`, `SELECT * FROM todos WHERE id = $1 AND title = $2`},
+		// Question marks that are not placeholders
+		{`SELECT '?', 'it''s ?', ? FROM t`, `SELECT '?', 'it''s ?', $1 FROM t`},
+		{`SELECT "odd?column" FROM t WHERE a = ?`, `SELECT "odd?column" FROM t WHERE a = $1`},
+		{`SELECT E'it\'s ?', ?`, `SELECT E'it\'s ?', $1`},
+		{`SELECT 'back\', ?`, `SELECT 'back\', $1`},
+		{"SELECT ? -- why?\nFROM t WHERE a = ?", "SELECT $1 -- why?\nFROM t WHERE a = $2"},
+		{`SELECT ? /* why? /* nested? */ still? */ , ?`, `SELECT $1 /* why? /* nested? */ still? */ , $2`},
+		{`DO $$ BEGIN PERFORM '?'; END $$; SELECT ?`, `DO $$ BEGIN PERFORM '?'; END $$; SELECT $1`},
+		{`SELECT $fn$ why? $$ $fn$, ?`, `SELECT $fn$ why? $$ $fn$, $1`},
+		{`SELECT a$b, ?`, `SELECT a$b, $1`},
+		// ?? is a literal ?, for JSONB operators
+		{`SELECT * FROM t WHERE data ?? ? AND data ??| ?`, `SELECT * FROM t WHERE data ? $1 AND data ?| $2`},
+		// Unterminated quotes and comments run to the end of the query
+		{`SELECT ?, 'open ?`, `SELECT $1, 'open ?`},
+		{`SELECT ? -- end?`, `SELECT $1 -- end?`},
+		{`SELECT ? /* open?`, `SELECT $1 /* open?`},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, database.Rebind(tc.query), tc.query)
+	}
+}
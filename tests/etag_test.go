@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestETags tests todo versions, ETags and conditional requests
+func TestETags(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_etags.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	tagHandler := handlers.NewTagHandler(models.NewTagModel(db, nil))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.GET("/todos/:id", todoHandler.GetTodo)
+	router.PUT("/todos/:id", todoHandler.UpdateTodo)
+	router.PATCH("/todos/:id", todoHandler.PatchTodo)
+	router.DELETE("/todos/:id", todoHandler.DeleteTodo)
+	router.PATCH("/todos/:id/complete", todoHandler.CompleteTodo)
+	router.PUT("/tags/:id", tagHandler.RenameTag)
+
+	send := func(method, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/todos", `{"title": "Shared todo", "tags": ["team"]}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	todos, err := todoModel.GetAll()
+	assert.NoError(t, err)
+	url := "/todos/" + strconv.Itoa(todos[0].ID)
+
+	t.Run("Every Write Bumps The Version", func(t *testing.T) {
+		w := send("GET", url, "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+
+		w = send("PATCH", url+"/complete", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+		etag = w.Header().Get("ETag")
+
+		// Renaming a tag changes how the todo reads, so it counts as a write
+		tag, err := models.NewTagModel(db, nil).GetAll()
+		assert.NoError(t, err)
+		w = send("PUT", "/tags/"+strconv.Itoa(tag[0].ID), `{"name": "squad"}`, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = send("GET", url, "", nil)
+		assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	})
+
+	t.Run("If-None-Match", func(t *testing.T) {
+		etag := send("GET", url, "", nil).Header().Get("ETag")
+
+		w := send("GET", url, "", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+
+		w = send("GET", url, "", map[string]string{"If-None-Match": `"0", W/` + etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+
+		w = send("GET", url, "", map[string]string{"If-None-Match": `"0"`})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("If-Match", func(t *testing.T) {
+		etag := send("GET", url, "", nil).Header().Get("ETag")
+
+		// The first writer wins, the second one holds a stale ETag
+		w := send("PUT", url, `{"title": "First writer"}`, map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusOK, w.Code)
+		fresh := w.Header().Get("ETag")
+		assert.NotEqual(t, etag, fresh)
+
+		w = send("PUT", url, `{"title": "Second writer"}`, map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		w = send("PATCH", url, `{"title": "Second writer"}`, map[string]string{
+			"Content-Type": models.MergePatchType,
+			"If-Match":     etag,
+		})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		// Weak ETags never satisfy If-Match
+		w = send("PUT", url, `{"title": "Weak writer"}`, map[string]string{"If-Match": "W/" + fresh})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		stored, err := todoModel.GetByID(todos[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "First writer", stored.Title)
+
+		w = send("PATCH", url, `{"description": "Patched"}`, map[string]string{
+			"Content-Type": models.MergePatchType,
+			"If-Match":     fresh,
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+		fresh = w.Header().Get("ETag")
+
+		w = send("DELETE", url, "", map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		w = send("DELETE", url, "", map[string]string{"If-Match": fresh})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Model Version Check", func(t *testing.T) {
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: "Versioned"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, todo.Version)
+
+		updated, err := todoModel.Update(todo.ID, models.UpdateTodoRequest{Title: "Versioned twice"}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, updated.Version)
+
+		_, err = todoModel.Update(todo.ID, models.UpdateTodoRequest{Title: "Stale"}, 1)
+		assert.ErrorIs(t, err, models.ErrVersionMismatch)
+		assert.ErrorIs(t, todoModel.Delete(todo.ID, 1), models.ErrVersionMismatch)
+	})
+}
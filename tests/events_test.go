@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// streamedEvent is one event read from a Server-Sent Events stream
+type streamedEvent struct {
+	ID    string
+	Event string
+	Data  models.TodoEvent
+}
+
+// TestTodoEvents tests the Server-Sent Events change feed
+func TestTodoEvents(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_events.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos/events", todoHandler.StreamTodoEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// connect opens the stream and returns a function that reads its next event
+	connect := func(t *testing.T, lastEventID string) (func() streamedEvent, context.CancelFunc) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/todos/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		next := func() streamedEvent {
+			var event streamedEvent
+			for {
+				line, err := reader.ReadString('\n')
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				line = strings.TrimSuffix(line, "\n")
+				switch {
+				case line == "" && event.ID != "":
+					return event
+				case strings.HasPrefix(line, "id: "):
+					event.ID = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					event.Event = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data))
+				}
+			}
+		}
+		return next, func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	first, err := todoModel.Create(models.CreateTodoRequest{Title: "Before the stream"})
+	assert.NoError(t, err)
+
+	t.Run("Streams Live Changes", func(t *testing.T) {
+		next, disconnect := connect(t, "")
+		defer disconnect()
+
+		parent, _ := todoModel.Create(models.CreateTodoRequest{Title: "Parent"})
+		child, _ := todoModel.Create(models.CreateTodoRequest{Title: "Child", ParentID: &parent.ID})
+		_, err := todoModel.SetPriority(parent.ID, models.PriorityHigh)
+		assert.NoError(t, err)
+		_, err = todoModel.ToggleComplete(parent.ID, true, models.ChildPolicyCascade)
+		assert.NoError(t, err)
+		assert.NoError(t, todoModel.Delete(parent.ID, 0))
+		_, err = todoModel.Restore(parent.ID)
+		assert.NoError(t, err)
+
+		want := []struct {
+			event  string
+			todoID int
+		}{
+			{"created", parent.ID},
+			{"created", child.ID},
+			{"updated", parent.ID},
+			{"completed", child.ID},
+			{"completed", parent.ID},
+			{"deleted", parent.ID},
+			{"deleted", child.ID},
+			{"restored", parent.ID},
+			{"restored", child.ID},
+		}
+		for _, w := range want {
+			event := next()
+			assert.Equal(t, w.event, event.Event)
+			assert.Equal(t, models.EventType(w.event), event.Data.Type)
+			assert.Equal(t, strconv.FormatInt(event.Data.ID, 10), event.ID)
+			// Events of one write may come in either order
+			if event.Data.TodoID != w.todoID {
+				assert.Contains(t, []int{parent.ID, child.ID}, event.Data.TodoID)
+			}
+		}
+	})
+
+	t.Run("Events Carry The Todo", func(t *testing.T) {
+		next, disconnect := connect(t, "")
+		defer disconnect()
+
+		todo, _ := todoModel.Create(models.CreateTodoRequest{Title: "Tagged", Tags: []string{"home"}})
+		event := next()
+		assert.Equal(t, todo.ID, event.Data.Todo.ID)
+		assert.Equal(t, "Tagged", event.Data.Todo.Title)
+		assert.Equal(t, []string{"home"}, event.Data.Todo.Tags)
+
+		assert.NoError(t, todoModel.Delete(todo.ID, 0))
+		event = next()
+		assert.Equal(t, "deleted", event.Event)
+		assert.NotNil(t, event.Data.Todo.DeletedAt)
+	})
+
+	t.Run("Resumes From Last-Event-ID", func(t *testing.T) {
+		// The first event in the log is the todo created before any stream opened
+		next, disconnect := connect(t, "0")
+		event := next()
+		assert.Equal(t, "created", event.Event)
+		assert.Equal(t, first.ID, event.Data.TodoID)
+		disconnect()
+
+		// Changes made while disconnected are replayed on reconnect
+		resumeFrom, err := todoModel.LastEventID()
+		assert.NoError(t, err)
+		missed, _ := todoModel.Create(models.CreateTodoRequest{Title: "Missed"})
+		_, err = todoModel.ToggleComplete(missed.ID, true, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+
+		next, disconnect = connect(t, strconv.FormatInt(resumeFrom, 10))
+		defer disconnect()
+		assert.Equal(t, "created", next().Event)
+		assert.Equal(t, "completed", next().Event)
+
+		// Then the stream carries on with live changes
+		_, err = todoModel.ToggleComplete(missed.ID, false, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+		event = next()
+		assert.Equal(t, "updated", event.Event)
+		assert.False(t, event.Data.Todo.Completed)
+	})
+
+	t.Run("Rolled Back Writes Are Not Published", func(t *testing.T) {
+		next, disconnect := connect(t, "")
+		defer disconnect()
+		before, err := todoModel.LastEventID()
+		assert.NoError(t, err)
+
+		_, committed, err := todoModel.Bulk([]models.BulkOperation{
+			{Op: models.BulkCreate, Create: models.CreateTodoRequest{Title: "Rolled back"}},
+			{Op: models.BulkDelete, ID: 9999},
+		}, true)
+		assert.NoError(t, err)
+		assert.False(t, committed)
+
+		todo, _ := todoModel.Create(models.CreateTodoRequest{Title: "After the bulk request"})
+		event := next()
+		assert.Equal(t, todo.ID, event.Data.TodoID)
+
+		// Nor are they left in the log
+		events, err := todoModel.EventsSince(before, 10)
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("Rejects An Invalid Last-Event-ID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/todos/events", nil)
+		req.Header.Set("Last-Event-ID", "yesterday")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestIdempotencyKeys tests replaying writes sent with an Idempotency-Key
+func TestIdempotencyKeys(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_idempotency.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	idempotencyModel := models.NewIdempotencyModel(db)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	api := router.Group("")
+	api.Use(handlers.Idempotency(idempotencyModel, time.Hour))
+	api.POST("/todos", todoHandler.CreateTodo)
+	api.DELETE("/todos/:id", todoHandler.DeleteTodo)
+
+	// A flaky endpoint that fails the first time it is called
+	calls := 0
+	api.POST("/flaky", func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.JSON(http.StatusInternalServerError, gin.H{"calls": calls})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"calls": calls})
+	})
+
+	expired := router.Group("/expired")
+	expired.Use(handlers.Idempotency(idempotencyModel, 0))
+	expired.POST("/todos", todoHandler.CreateTodo)
+
+	send := func(method, url, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(handlers.IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	countTodos := func() int {
+		todos, err := todoModel.GetAll()
+		assert.NoError(t, err)
+		return len(todos)
+	}
+
+	t.Run("Retry Replays The Original Response", func(t *testing.T) {
+		before := countTodos()
+		body := `{"title": "Buy milk"}`
+
+		first := send("POST", "/todos", "create-milk", body)
+		assert.Equal(t, http.StatusCreated, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		retry := send("POST", "/todos", "create-milk", body)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+		assert.Equal(t, "application/json; charset=utf-8", retry.Header().Get("Content-Type"))
+
+		assert.Equal(t, before+1, countTodos())
+	})
+
+	t.Run("Same Key With A Different Body Is 422", func(t *testing.T) {
+		before := countTodos()
+
+		w := send("POST", "/todos", "create-bread", `{"title": "Buy bread"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = send("POST", "/todos", "create-bread", `{"title": "Buy cake"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, handlers.ProblemContentType, w.Header().Get("Content-Type"))
+
+		// The key is tied to the URL as well as the body
+		w = send("DELETE", "/todos/1", "create-bread", `{"title": "Buy bread"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		assert.Equal(t, before+1, countTodos())
+	})
+
+	t.Run("Requests Without A Key Are Not Deduplicated", func(t *testing.T) {
+		before := countTodos()
+		send("POST", "/todos", "", `{"title": "Water plants"}`)
+		send("POST", "/todos", "", `{"title": "Water plants"}`)
+		assert.Equal(t, before+2, countTodos())
+	})
+
+	t.Run("Client Errors Are Replayed", func(t *testing.T) {
+		first := send("POST", "/todos", "bad-create", `{"description": "No title"}`)
+		assert.Equal(t, http.StatusBadRequest, first.Code)
+
+		retry := send("POST", "/todos", "bad-create", `{"description": "No title"}`)
+		assert.Equal(t, http.StatusBadRequest, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, handlers.ProblemContentType, retry.Header().Get("Content-Type"))
+	})
+
+	t.Run("Server Errors Can Be Retried", func(t *testing.T) {
+		w := send("POST", "/flaky", "flaky-key", `{}`)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+		w = send("POST", "/flaky", "flaky-key", `{}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		w = send("POST", "/flaky", "flaky-key", `{}`)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Expired Keys Run Again", func(t *testing.T) {
+		before := countTodos()
+		send("POST", "/expired/todos", "short-lived", `{"title": "Again"}`)
+		send("POST", "/expired/todos", "short-lived", `{"title": "Again"}`)
+		assert.Equal(t, before+2, countTodos())
+
+		purged, err := idempotencyModel.Purge(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+	})
+
+	t.Run("Rejects Overlong Keys", func(t *testing.T) {
+		w := send("POST", "/todos", strings.Repeat("k", 256), `{"title": "Long key"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestListFiltering tests filtering and sorting on the todo list endpoint
+func TestListFiltering(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_list.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos", todoHandler.GetTodos)
+
+	// Seed a small, known data set
+	titles := []string{"Charlie", "alpha", "Bravo", "100% done", "delta"}
+	for _, title := range titles {
+		_, err := todoModel.Create(models.CreateTodoRequest{Title: title, Description: "seed"})
+		assert.NoError(t, err)
+	}
+	all, err := todoModel.GetAll()
+	assert.NoError(t, err)
+	for _, todo := range all {
+		if todo.Title == "Bravo" || todo.Title == "delta" {
+			_, err := todoModel.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+			assert.NoError(t, err)
+		}
+	}
+
+	list := func(t *testing.T, url string) (int, models.TodoPage) {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var page models.TodoPage
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		}
+		return w.Code, page
+	}
+
+	titlesOf := func(page models.TodoPage) []string {
+		var out []string
+		for _, todo := range page.Todos {
+			out = append(out, todo.Title)
+		}
+		return out
+	}
+
+	t.Run("Filter By Completed", func(t *testing.T) {
+		code, page := list(t, "/todos?completed=true&sort=title")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"Bravo", "delta"}, titlesOf(page))
+		assert.Equal(t, 2, *page.Total)
+	})
+
+	t.Run("Substring Search Escapes Wildcards", func(t *testing.T) {
+		code, page := list(t, "/todos?q=0%25")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"100% done"}, titlesOf(page))
+	})
+
+	t.Run("Date Range", func(t *testing.T) {
+		code, page := list(t, "/todos?created_after=2000-01-01T00:00:00Z&created_before=2000-01-02T00:00:00Z")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Empty(t, page.Todos)
+
+		code, page = list(t, "/todos?updated_after=2000-01-01T00:00:00Z")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, page.Todos, len(titles))
+	})
+
+	t.Run("Multi-key Sort Across Pages", func(t *testing.T) {
+		var got []string
+		url := "/todos?sort=-completed,title&limit=2"
+		for url != "" {
+			code, page := list(t, url)
+			assert.Equal(t, http.StatusOK, code)
+			got = append(got, titlesOf(page)...)
+
+			url = ""
+			if page.NextCursor != "" {
+				url = "/todos?sort=-completed,title&limit=2&cursor=" + page.NextCursor
+			}
+		}
+		assert.Equal(t, []string{"Bravo", "delta", "100% done", "Charlie", "alpha"}, got)
+	})
+
+	t.Run("Cursor Bound To Sort", func(t *testing.T) {
+		_, page := list(t, "/todos?sort=title&limit=1")
+		assert.NotEmpty(t, page.NextCursor)
+
+		code, _ := list(t, "/todos?sort=-title&limit=1&cursor="+page.NextCursor)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Reject Unknown Fields", func(t *testing.T) {
+		for _, url := range []string{
+			"/todos?sort=owner",
+			"/todos?sort=title,-title",
+			"/todos?colour=red",
+			"/todos?completed=maybe",
+			"/todos?created_after=yesterday",
+		} {
+			code, _ := list(t, url)
+			assert.Equal(t, http.StatusBadRequest, code, url)
+		}
+	})
+}
+
+// TestDueViews tests due dates and the overdue, today and upcoming views
+func TestDueViews(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_due.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.GET("/todos/overdue", todoHandler.GetOverdueTodos)
+	router.GET("/todos/today", todoHandler.GetTodayTodos)
+	router.GET("/todos/upcoming", todoHandler.GetUpcomingTodos)
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	now := time.Now().In(tokyo)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tokyo)
+
+	create := func(title string, dueAt *time.Time) *models.Todo {
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: title, DueAt: dueAt})
+		assert.NoError(t, err)
+		return todo
+	}
+	at := func(t time.Time) *time.Time { return &t }
+
+	late := create("late", at(now.Add(-48*time.Hour)))
+	dueToday := create("today", at(today.Add(time.Second)))
+	inThreeDays := create("in three days", at(today.AddDate(0, 0, 3).Add(12*time.Hour)))
+	done := create("done", at(now.Add(-48*time.Hour)))
+	_, err = todoModel.ToggleComplete(done.ID, true, models.ChildPolicyIgnore)
+	assert.NoError(t, err)
+	create("someday", nil)
+
+	view := func(t *testing.T, url string) []int {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, url)
+
+		var page models.TodoPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		var ids []int
+		for _, todo := range page.Todos {
+			ids = append(ids, todo.ID)
+		}
+		return ids
+	}
+
+	t.Run("Overdue", func(t *testing.T) {
+		ids := view(t, "/todos/overdue?tz=Asia/Tokyo")
+		assert.Contains(t, ids, late.ID)
+		assert.NotContains(t, ids, done.ID)
+		assert.NotContains(t, ids, inThreeDays.ID)
+		assert.Equal(t, late.ID, ids[0], "oldest due date first")
+	})
+
+	t.Run("Today", func(t *testing.T) {
+		assert.Equal(t, []int{dueToday.ID}, view(t, "/todos/today?tz=Asia/Tokyo"))
+	})
+
+	t.Run("Upcoming", func(t *testing.T) {
+		assert.Contains(t, view(t, "/todos/upcoming?tz=Asia/Tokyo&days=3"), inThreeDays.ID)
+		assert.NotContains(t, view(t, "/todos/upcoming?tz=Asia/Tokyo&days=2"), inThreeDays.ID)
+	})
+
+	t.Run("Due Timezone Round Trip", func(t *testing.T) {
+		body := `{"title": "call", "due_at": "2030-05-01T09:00:00Z", "due_timezone": "Asia/Tokyo"}`
+		req, _ := http.NewRequest("POST", "/todos", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"due_at":"2030-05-01T18:00:00+09:00"`)
+
+		var created models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		stored, err := todoModel.GetByID(created.ID)
+		assert.NoError(t, err)
+		assert.True(t, created.DueAt.Equal(*stored.DueAt))
+		assert.Equal(t, "Asia/Tokyo", stored.DueTimezone)
+	})
+
+	t.Run("Reject Invalid Timezones", func(t *testing.T) {
+		body := `{"title": "call", "due_at": "2030-05-01T09:00:00Z", "due_timezone": "Mars/Olympus"}`
+		req, _ := http.NewRequest("POST", "/todos", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		req, _ = http.NewRequest("GET", "/todos/today?tz=Mars/Olympus", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,318 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/database"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestMigrations tests applying, reverting and listing schema migrations
+func TestMigrations(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_migrations.db")
+
+	migrator, err := database.NewMigrator(db)
+	assert.NoError(t, err)
+	if !assert.NotEmpty(t, migrator.Migrations) {
+		t.FailNow()
+	}
+	latest := migrator.Migrations[len(migrator.Migrations)-1].Version
+
+	tableExists := func(name string) bool {
+		_, err := db.Exec(`SELECT COUNT(*) FROM ` + name)
+		return err == nil
+	}
+
+	t.Run("InitDB Applies Every Migration", func(t *testing.T) {
+		version, err := migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, latest, version)
+
+		statuses, err := migrator.Status()
+		assert.NoError(t, err)
+		assert.Len(t, statuses, len(migrator.Migrations))
+		for _, status := range statuses {
+			assert.NotNil(t, status.AppliedAt, "migration %d", status.Version)
+		}
+	})
+
+	t.Run("Down And Up", func(t *testing.T) {
+		assert.NoError(t, migrator.To(0))
+		assert.False(t, tableExists("todos"))
+
+		version, err := migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, version)
+
+		statuses, err := migrator.Status()
+		assert.NoError(t, err)
+		for _, status := range statuses {
+			assert.Nil(t, status.AppliedAt, "migration %d", status.Version)
+		}
+
+		assert.NoError(t, migrator.Up())
+		assert.True(t, tableExists("todos"))
+
+		_, err = models.NewTodoModel(db).Create(models.CreateTodoRequest{Title: "After migrating"})
+		assert.NoError(t, err)
+
+		// Up again has nothing left to do
+		assert.NoError(t, migrator.Up())
+		version, err = migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, latest, version)
+	})
+
+	t.Run("Go Migrations", func(t *testing.T) {
+		migrator.Migrations = append(migrator.Migrations, database.Migration{
+			Version: latest + 1,
+			Name:    "add_todo_notes",
+			Up: func(tx *sql.Tx) error {
+				_, err := tx.Exec(`ALTER TABLE todos ADD COLUMN notes TEXT`)
+				return err
+			},
+			Down: func(tx *sql.Tx) error {
+				_, err := tx.Exec(`ALTER TABLE todos DROP COLUMN notes`)
+				return err
+			},
+		})
+
+		assert.NoError(t, migrator.Up())
+		_, err := db.Exec(`SELECT notes FROM todos`)
+		assert.NoError(t, err)
+
+		assert.NoError(t, migrator.Down())
+		_, err = db.Exec(`SELECT notes FROM todos`)
+		assert.Error(t, err)
+
+		version, err := migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, latest, version)
+	})
+
+	t.Run("Failed Migration Is Rolled Back", func(t *testing.T) {
+		migrator.Migrations = append(migrator.Migrations, database.Migration{
+			Version: latest + 2,
+			Name:    "broken",
+			Up: func(tx *sql.Tx) error {
+				if _, err := tx.Exec(`CREATE TABLE half_done (id INTEGER PRIMARY KEY)`); err != nil {
+					return err
+				}
+				return errors.New("something went wrong")
+			},
+		})
+
+		assert.Error(t, migrator.Up())
+		assert.False(t, tableExists("half_done"))
+
+		version, err := migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, latest+1, version)
+
+		// The migrations before it were still applied
+		assert.NoError(t, migrator.To(latest))
+	})
+
+	t.Run("Irreversible Migration", func(t *testing.T) {
+		migrator.Migrations = append(migrator.Migrations[:len(migrator.Migrations)-2], database.Migration{
+			Version: latest + 1,
+			Name:    "one_way",
+			Up:      func(tx *sql.Tx) error { return nil },
+		})
+
+		assert.NoError(t, migrator.Up())
+		err := migrator.Down()
+		assert.True(t, errors.Is(err, database.ErrIrreversible))
+
+		// Forget the migration so later tests start from the real schema
+		_, err = db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, latest+1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unknown Version", func(t *testing.T) {
+		err := migrator.To(latest + 100)
+		assert.True(t, errors.Is(err, database.ErrUnknownMigration))
+	})
+}
+
+// TestMigrationsAdoptExistingDatabase tests that a SQLite database created
+// before migrations existed keeps its data
+func TestMigrationsAdoptExistingDatabase(t *testing.T) {
+	dbPath := "test_migrations_existing.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.OpenDB(dbPath)
+	assert.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE projects (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			color TEXT NOT NULL DEFAULT '',
+			archived BOOLEAN NOT NULL DEFAULT FALSE,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		INSERT INTO projects (name, created_at, updated_at) VALUES ('Existing', '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+	`)
+	assert.NoError(t, err)
+	database.CloseDB(db)
+
+	db, err = database.InitDB(dbPath)
+	assert.NoError(t, err)
+	defer database.CloseDB(db)
+
+	project, err := models.NewProjectModel(db, nil).GetByID(1)
+	assert.NoError(t, err)
+	if assert.NotNil(t, project) {
+		assert.Equal(t, "Existing", project.Name)
+	}
+
+	migrator, err := database.NewMigrator(db)
+	assert.NoError(t, err)
+	version, err := migrator.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, migrator.Migrations[len(migrator.Migrations)-1].Version, version)
+}
+
+// TestMigrationsAdoptBaselineDatabase tests that a SQLite database created by
+// the first release, whose todos table has none of the later columns, is
+// upgraded and keeps its todos
+func TestMigrationsAdoptBaselineDatabase(t *testing.T) {
+	dbPath := "test_migrations_baseline.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.OpenDB(dbPath)
+	assert.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE todos (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			description TEXT,
+			completed BOOLEAN DEFAULT FALSE,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		INSERT INTO todos (title, description, completed, created_at, updated_at)
+		VALUES ('Existing', 'From the first release', TRUE, '2024-01-01 09:30:00.123456789+02:00', '2024-01-01 09:30:00.123456789+02:00');
+		INSERT INTO todos (title, description, completed, created_at, updated_at)
+		VALUES ('Later', 'Written in UTC', FALSE, '2024-01-01 08:00:00+00:00', '2024-01-01 08:00:00+00:00');
+	`)
+	assert.NoError(t, err)
+	database.CloseDB(db)
+
+	db, err = database.InitDB(dbPath)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer database.CloseDB(db)
+
+	todoModel := models.NewTodoModel(db)
+	todo, err := todoModel.GetByID(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Existing", todo.Title)
+		assert.True(t, todo.Completed)
+		assert.Equal(t, models.PriorityNone, todo.Priority)
+		assert.Equal(t, 1, todo.Version)
+		assert.Nil(t, todo.DueAt)
+		assert.Nil(t, todo.ProjectID)
+	}
+
+	// Times written with the server's offset are rewritten in UTC, so they
+	// sort among the ones written in UTC
+	var createdAt string
+	assert.NoError(t, db.QueryRow(`SELECT CAST(created_at AS TEXT) FROM todos WHERE id = 1`).Scan(&createdAt))
+	assert.Equal(t, "2024-01-01 07:30:00.123456789+00:00", createdAt)
+	sort, err := models.ParseSort("created_at")
+	assert.NoError(t, err)
+	params := models.ListParams{Limit: 1, Sort: sort}
+	var titles []string
+	for {
+		page, err := todoModel.List(params)
+		if !assert.NoError(t, err) {
+			break
+		}
+		for _, todo := range page.Todos {
+			titles = append(titles, todo.Title)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"Existing", "Later"}, titles)
+
+	// The added columns work like they do in a new database
+	_, err = todoModel.SetPriority(1, models.PriorityHigh)
+	assert.NoError(t, err)
+	assert.NoError(t, todoModel.Delete(1, 0))
+	restored, err := todoModel.Restore(1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, models.PriorityHigh, restored.Priority)
+		assert.Equal(t, 4, restored.Version)
+	}
+}
+
+// TestMigrationsAdoptIntermediateDatabases tests that SQLite databases
+// created by the releases that added columns to todos, before migrations
+// existed, are given the columns added after them
+func TestMigrationsAdoptIntermediateDatabases(t *testing.T) {
+	baseline := `id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL, description TEXT,
+		completed BOOLEAN DEFAULT FALSE, created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL`
+	releases := []struct {
+		name    string
+		columns string
+	}{
+		{"Due Dates", `due_at DATETIME, due_timezone TEXT NOT NULL DEFAULT ''`},
+		{"Priorities", `due_at DATETIME, due_timezone TEXT NOT NULL DEFAULT '', priority INTEGER NOT NULL DEFAULT 0`},
+		{"Trash", `due_at DATETIME, due_timezone TEXT NOT NULL DEFAULT '', priority INTEGER NOT NULL DEFAULT 0,
+			project_id INTEGER, parent_id INTEGER, recurrence TEXT NOT NULL DEFAULT '', recurrence_start DATETIME,
+			series_id INTEGER, deleted_at DATETIME`},
+		{"Versions", `due_at DATETIME, due_timezone TEXT NOT NULL DEFAULT '', priority INTEGER NOT NULL DEFAULT 0,
+			project_id INTEGER, parent_id INTEGER, recurrence TEXT NOT NULL DEFAULT '', recurrence_start DATETIME,
+			series_id INTEGER, deleted_at DATETIME, version INTEGER NOT NULL DEFAULT 1`},
+	}
+
+	for _, release := range releases {
+		t.Run(release.name, func(t *testing.T) {
+			dbPath := "test_migrations_intermediate.db"
+			defer os.Remove(dbPath)
+
+			db, err := database.OpenDB(dbPath)
+			assert.NoError(t, err)
+			_, err = db.Exec(`
+				CREATE TABLE todos (` + baseline + `, ` + release.columns + `);
+				INSERT INTO todos (title, description, due_timezone, created_at, updated_at)
+				VALUES ('Existing', '', 'Europe/Paris', '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+			`)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			database.CloseDB(db)
+
+			db, err = database.InitDB(dbPath)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			defer database.CloseDB(db)
+
+			// The columns the release already had keep their values, and the
+			// ones added after it work
+			todoModel := models.NewTodoModel(db)
+			todo, err := todoModel.GetByID(1)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "Europe/Paris", todo.DueTimezone)
+			}
+			todo, err = todoModel.Update(1, models.UpdateTodoRequest{Title: "Existing", Priority: models.PriorityLow}, 1)
+			if assert.NoError(t, err) {
+				assert.Equal(t, models.PriorityLow, todo.Priority)
+				assert.Equal(t, 2, todo.Version)
+			}
+		})
+	}
+}
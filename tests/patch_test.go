@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestPatchTodo tests partial updates with JSON Merge Patch and JSON Patch
+func TestPatchTodo(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_patch.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.PATCH("/todos/:id", todoHandler.PatchTodo)
+
+	todo, err := todoModel.Create(models.CreateTodoRequest{
+		Title:       "Write report",
+		Description: "Quarterly numbers",
+		Priority:    models.PriorityMedium,
+		Tags:        []string{"work"},
+	})
+	assert.NoError(t, err)
+	url := "/todos/" + strconv.Itoa(todo.ID)
+
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PATCH", url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// current reads the todo back from the database
+	current := func() *models.Todo {
+		stored, err := todoModel.GetByID(todo.ID)
+		assert.NoError(t, err)
+		return stored
+	}
+
+	t.Run("Merge Patch Changes Only Given Fields", func(t *testing.T) {
+		w := patch(models.MergePatchType, `{"priority": "urgent", "tags": ["work", "q3"]}`)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var patched models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &patched))
+		assert.Equal(t, "Write report", patched.Title)
+		assert.Equal(t, "Quarterly numbers", patched.Description)
+		assert.Equal(t, models.PriorityUrgent, patched.Priority)
+		assert.Equal(t, []string{"q3", "work"}, patched.Tags)
+
+		// null removes a field
+		w = patch(models.MergePatchType+"; charset=utf-8", `{"description": null}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, current().Description)
+	})
+
+	t.Run("JSON Patch", func(t *testing.T) {
+		w := patch(models.JSONPatchType, `[
+			{"op": "test", "path": "/title", "value": "Write report"},
+			{"op": "replace", "path": "/title", "value": "Write Q3 report"},
+			{"op": "add", "path": "/tags/-", "value": "finance"}
+		]`)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		stored := current()
+		assert.Equal(t, "Write Q3 report", stored.Title)
+		assert.Equal(t, []string{"finance", "q3", "work"}, stored.Tags)
+	})
+
+	t.Run("Failed Patch Leaves Todo Unchanged", func(t *testing.T) {
+		before := current()
+
+		cases := []struct {
+			contentType string
+			body        string
+			status      int
+		}{
+			// The test operation fails after an earlier operation already applied
+			{models.JSONPatchType, `[
+				{"op": "replace", "path": "/priority", "value": "low"},
+				{"op": "test", "path": "/title", "value": "Something else"}
+			]`, http.StatusConflict},
+			{models.JSONPatchType, `[{"op": "remove", "path": "/nope"}]`, http.StatusBadRequest},
+			{models.JSONPatchType, `{"op": "replace"}`, http.StatusBadRequest},
+			{models.MergePatchType, `{"title": null}`, http.StatusBadRequest},
+			{models.MergePatchType, `{"completed": true}`, http.StatusBadRequest},
+			{models.MergePatchType, `{"priority": "critical"}`, http.StatusBadRequest},
+			{models.MergePatchType, `{"parent_id": 99999}`, http.StatusBadRequest},
+			{models.MergePatchType, `[]`, http.StatusBadRequest},
+			{"application/json", `{"title": "Plain JSON"}`, http.StatusUnsupportedMediaType},
+		}
+		for _, tc := range cases {
+			w := patch(tc.contentType, tc.body)
+			assert.Equal(t, tc.status, w.Code, tc.body)
+		}
+
+		after := current()
+		assert.Equal(t, before.Title, after.Title)
+		assert.Equal(t, before.Priority, after.Priority)
+		assert.Equal(t, before.Tags, after.Tags)
+		assert.Equal(t, before.UpdatedAt, after.UpdatedAt)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		req, _ := http.NewRequest("PATCH", "/todos/99999", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", models.MergePatchType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
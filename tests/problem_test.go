@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/database"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestProblemResponses tests that errors are reported as RFC 7807 problem details
+func TestProblemResponses(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_problems.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	tagHandler := handlers.NewTagHandler(models.NewTagModel(db, nil))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos", todoHandler.GetTodos)
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.GET("/todos/:id", todoHandler.GetTodo)
+	router.PUT("/todos/:id", todoHandler.UpdateTodo)
+	router.DELETE("/todos/:id", todoHandler.DeleteTodo)
+	router.PATCH("/todos/:id/uncomplete", todoHandler.UncompleteTodo)
+	router.POST("/tags", tagHandler.CreateTag)
+
+	send := func(method, url, body string) (*httptest.ResponseRecorder, handlers.Problem) {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var problem handlers.Problem
+		if w.Code >= 400 {
+			assert.Equal(t, handlers.ProblemContentType, w.Header().Get("Content-Type"))
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, w.Code, problem.Status)
+			assert.Equal(t, strings.SplitN(url, "?", 2)[0], problem.Instance)
+		}
+		return w, problem
+	}
+
+	t.Run("Missing Todo Is 404", func(t *testing.T) {
+		for _, method := range []string{"GET", "PUT", "DELETE"} {
+			w, problem := send(method, "/todos/999", `{"title": "Nothing"}`)
+			assert.Equal(t, http.StatusNotFound, w.Code, method)
+			assert.Equal(t, "Not Found", problem.Title)
+			assert.Equal(t, "Todo not found", problem.Detail)
+		}
+
+		w, _ := send("PATCH", "/todos/999/uncomplete", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Validation Errors List The Fields", func(t *testing.T) {
+		body := `{"tags": ["ok", "` + strings.Repeat("x", 51) + `"]}`
+		w, problem := send("POST", "/todos", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		reasons := map[string]string{}
+		for _, param := range problem.InvalidParams {
+			reasons[param.Name] = param.Reason
+		}
+		assert.Equal(t, "is required", reasons["title"])
+		assert.Equal(t, "must be at most 50 characters", reasons["tags[1]"])
+	})
+
+	t.Run("Wrong JSON Type Names The Field", func(t *testing.T) {
+		w, problem := send("POST", "/todos", `{"title": 42}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		if assert.Len(t, problem.InvalidParams, 1) {
+			assert.Equal(t, "title", problem.InvalidParams[0].Name)
+		}
+	})
+
+	t.Run("Model Validation Errors Name The Field", func(t *testing.T) {
+		w, problem := send("POST", "/todos", `{"title": "Orphan", "parent_id": 999}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		if assert.Len(t, problem.InvalidParams, 1) {
+			assert.Equal(t, "parent_id", problem.InvalidParams[0].Name)
+		}
+
+		w, _ = send("GET", "/todos?sort=nonsense", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Conflicts Are 409", func(t *testing.T) {
+		w, _ := send("POST", "/tags", `{"name": "home"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w, problem := send("POST", "/tags", `{"name": "home"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, "Conflict", problem.Title)
+	})
+
+	t.Run("Database Failures Are Not 404", func(t *testing.T) {
+		database.CloseDB(db)
+
+		w, problem := send("GET", "/todos/1", "")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "Failed to retrieve workspace", problem.Detail)
+	})
+}
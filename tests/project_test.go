@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestProjects tests projects and the todos nested under them
+func TestProjects(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_projects.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	projectModel := models.NewProjectModel(db, todoModel.Events)
+	projectHandler := handlers.NewProjectHandler(projectModel, todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos", todoHandler.GetTodos)
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.GET("/projects", projectHandler.GetProjects)
+	router.POST("/projects", projectHandler.CreateProject)
+	router.PUT("/projects/:id", projectHandler.UpdateProject)
+	router.DELETE("/projects/:id", projectHandler.DeleteProject)
+	router.GET("/projects/:id/todos", projectHandler.GetProjectTodos)
+	router.POST("/projects/:id/todos", projectHandler.CreateProjectTodo)
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	createProject := func(body string) *models.Project {
+		w := send("POST", "/projects", body)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var project models.Project
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &project))
+		return &project
+	}
+
+	listIDs := func(url string) []int {
+		w := send("GET", url, "")
+		assert.Equal(t, http.StatusOK, w.Code, url)
+		var page models.TodoPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		var ids []int
+		for _, todo := range page.Todos {
+			ids = append(ids, todo.ID)
+		}
+		return ids
+	}
+
+	t.Run("Project CRUD", func(t *testing.T) {
+		home := createProject(`{"name": "Home", "color": "#33aa55", "sort_order": 2}`)
+		work := createProject(`{"name": "Work", "sort_order": 1}`)
+		assert.Equal(t, "#33aa55", home.Color)
+
+		w := send("POST", "/projects", `{"name": "Bad", "color": "green"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("GET", "/projects", "")
+		var projects []models.Project
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &projects))
+		assert.Equal(t, work.ID, projects[0].ID, "ordered by sort_order")
+
+		w = send("PUT", "/projects/"+strconv.Itoa(home.ID), `{"name": "Home", "archived": true}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = send("GET", "/projects", "")
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &projects))
+		assert.Len(t, projects, 1)
+
+		w = send("GET", "/projects?include_archived=true", "")
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &projects))
+		assert.Len(t, projects, 2)
+
+		w = send("PUT", "/projects/9999", `{"name": "Ghost"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Nested Todos", func(t *testing.T) {
+		project := createProject(`{"name": "Garden"}`)
+		id := strconv.Itoa(project.ID)
+
+		w := send("POST", "/projects/"+id+"/todos", `{"title": "Mow the lawn"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var nested models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &nested))
+		assert.Equal(t, project.ID, *nested.ProjectID)
+
+		w = send("POST", "/todos", `{"title": "Water plants", "project_id": `+id+`}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		w = send("POST", "/todos", `{"title": "Unfiled"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var unfiled models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &unfiled))
+		assert.Nil(t, unfiled.ProjectID)
+
+		assert.Len(t, listIDs("/projects/"+id+"/todos"), 2)
+		assert.Len(t, listIDs("/todos?project_id="+id), 2)
+		assert.Contains(t, listIDs("/todos?project_id=none"), unfiled.ID)
+
+		w = send("POST", "/todos", `{"title": "Lost", "project_id": 9999}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = send("POST", "/projects/9999/todos", `{"title": "Lost"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = send("GET", "/projects/9999/todos", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Delete Moves Todos To Inbox", func(t *testing.T) {
+		project := createProject(`{"name": "Temporary"}`)
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: "Keep me", ProjectID: &project.ID})
+		assert.NoError(t, err)
+		events, unsubscribe := todoModel.Subscribe(10)
+		defer unsubscribe()
+
+		w := send("DELETE", "/projects/"+strconv.Itoa(project.ID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		kept, err := todoModel.GetByID(todo.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, kept.ProjectID)
+
+		// Feeds hear that the todo left the project
+		if assert.Len(t, events, 1) {
+			event := <-events
+			assert.Equal(t, models.EventUpdated, event.Type)
+			assert.Equal(t, todo.ID, event.TodoID)
+			assert.Nil(t, event.Todo.ProjectID)
+			assert.Equal(t, kept.Version, event.Todo.Version)
+		}
+	})
+
+	t.Run("Delete Cascades To Todos", func(t *testing.T) {
+		project := createProject(`{"name": "Doomed"}`)
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: "Delete me", ProjectID: &project.ID})
+		assert.NoError(t, err)
+
+		w := send("DELETE", "/projects/"+strconv.Itoa(project.ID)+"?todos=shred", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		last, err := todoModel.LastEventID()
+		assert.NoError(t, err)
+		w = send("DELETE", "/projects/"+strconv.Itoa(project.ID)+"?todos=cascade", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		_, err = todoModel.GetByID(todo.ID)
+		assert.Error(t, err)
+
+		// The event log has the trashed todo, for feeds that catch up later
+		logged, err := todoModel.EventsSince(last, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, logged, 1) {
+			assert.Equal(t, models.EventDeleted, logged[0].Type)
+			assert.Equal(t, todo.ID, logged[0].TodoID)
+			assert.NotNil(t, logged[0].Todo.DeletedAt)
+		}
+	})
+}
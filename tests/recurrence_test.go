@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestRecurrence tests recurring todos and the occurrence preview
+func TestRecurrence(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_recurrence.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.PUT("/todos/:id", todoHandler.UpdateTodo)
+	router.PATCH("/todos/:id/complete", todoHandler.CompleteTodo)
+	router.GET("/todos/:id/occurrences", todoHandler.GetTodoOccurrences)
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	create := func(body string) *models.Todo {
+		w := send("POST", "/todos", body)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var todo models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+		return &todo
+	}
+
+	t.Run("Invalid Rules", func(t *testing.T) {
+		bodies := []string{
+			`{"title": "No due date", "recurrence": "FREQ=DAILY"}`,
+			`{"title": "Bad rule", "due_at": "2030-01-01T09:00:00Z", "recurrence": "FREQ=SOMETIMES"}`,
+			`{"title": "Too often", "due_at": "2030-01-01T09:00:00Z", "recurrence": "FREQ=MINUTELY"}`,
+			`{"title": "Own start", "due_at": "2030-01-01T09:00:00Z", "recurrence": "DTSTART:20300101T090000Z\nRRULE:FREQ=DAILY"}`,
+		}
+		for _, body := range bodies {
+			w := send("POST", "/todos", body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+
+	t.Run("Complete Creates Next Occurrence", func(t *testing.T) {
+		todo := create(`{
+			"title": "Take out bins", "priority": "high", "tags": ["chores"],
+			"due_at": "2030-01-07T19:00:00Z", "recurrence": "RRULE:FREQ=WEEKLY;BYDAY=MO"
+		}`)
+		assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO", todo.Recurrence)
+
+		w := send("PATCH", "/todos/"+strconv.Itoa(todo.ID)+"/complete", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var done models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &done))
+		assert.True(t, done.Completed)
+		assert.Empty(t, done.Recurrence)
+		assert.Equal(t, todo.ID, *done.SeriesID)
+
+		next := done.NextOccurrence
+		if assert.NotNil(t, next) {
+			assert.NotEqual(t, todo.ID, next.ID)
+			assert.False(t, next.Completed)
+			assert.Equal(t, "Take out bins", next.Title)
+			assert.Equal(t, models.PriorityHigh, next.Priority)
+			assert.Equal(t, []string{"chores"}, next.Tags)
+			assert.Equal(t, todo.Recurrence, next.Recurrence)
+			assert.Equal(t, todo.ID, *next.SeriesID)
+			assert.True(t, next.DueAt.Equal(time.Date(2030, 1, 14, 19, 0, 0, 0, time.UTC)))
+		}
+
+		// Completing the finished occurrence again must not create another one
+		w = send("PATCH", "/todos/"+strconv.Itoa(todo.ID)+"/complete", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var again models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &again))
+		assert.Nil(t, again.NextOccurrence)
+	})
+
+	t.Run("Series Ends With Count", func(t *testing.T) {
+		todo := create(`{"title": "Course", "due_at": "2030-03-01T10:00:00Z", "recurrence": "FREQ=DAILY;COUNT=2"}`)
+
+		first, err := todoModel.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+		assert.NotNil(t, first.NextOccurrence)
+
+		last, err := todoModel.ToggleComplete(first.NextOccurrence.ID, true, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+		assert.Nil(t, last.NextOccurrence)
+	})
+
+	t.Run("Wall Clock Kept Across DST", func(t *testing.T) {
+		todo := create(`{
+			"title": "Standup", "due_at": "2030-03-08T09:00:00-05:00",
+			"due_timezone": "America/New_York", "recurrence": "FREQ=DAILY"
+		}`)
+
+		done, err := todoModel.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+		next := done.NextOccurrence
+		if assert.NotNil(t, next) {
+			// Clocks go forward on March 10th, but the 9am standup stays at 9am
+			next, err = todoModel.ToggleComplete(next.ID, true, models.ChildPolicyIgnore)
+			assert.NoError(t, err)
+			due := next.NextOccurrence.DueAt
+			assert.Equal(t, 10, due.Day())
+			assert.Equal(t, 9, due.Hour())
+			assert.Equal(t, "America/New_York", due.Location().String())
+		}
+	})
+
+	t.Run("Preview Occurrences", func(t *testing.T) {
+		todo := create(`{"title": "Rent", "due_at": "2030-01-31T12:00:00Z", "recurrence": "FREQ=MONTHLY;BYMONTHDAY=-1"}`)
+		url := "/todos/" + strconv.Itoa(todo.ID) + "/occurrences"
+
+		w := send("GET", url+"?count=3", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var preview struct {
+			Occurrences []time.Time `json:"occurrences"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+		if assert.Len(t, preview.Occurrences, 3) {
+			assert.Equal(t, 31, preview.Occurrences[0].Day())
+			assert.Equal(t, time.February, preview.Occurrences[1].Month())
+			assert.Equal(t, 28, preview.Occurrences[1].Day())
+			assert.Equal(t, 31, preview.Occurrences[2].Day())
+		}
+
+		w = send("GET", url, "")
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+		assert.Len(t, preview.Occurrences, 5)
+
+		w = send("GET", url+"?count=101", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		plain := create(`{"title": "Once"}`)
+		w = send("GET", "/todos/"+strconv.Itoa(plain.ID)+"/occurrences", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("GET", "/todos/99999/occurrences", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Update Changes Rule", func(t *testing.T) {
+		todo := create(`{"title": "Water plants", "due_at": "2030-05-01T08:00:00Z", "recurrence": "FREQ=DAILY"}`)
+
+		w := send("PUT", "/todos/"+strconv.Itoa(todo.ID), `{
+			"title": "Water plants", "due_at": "2030-05-01T08:00:00Z", "recurrence": "FREQ=DAILY;INTERVAL=3"
+		}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		occurrences, err := todoModel.PreviewOccurrences(todo.ID, 2)
+		assert.NoError(t, err)
+		assert.True(t, occurrences[1].Equal(time.Date(2030, 5, 4, 8, 0, 0, 0, time.UTC)))
+
+		// Removing the due date of a recurring todo is refused
+		w = send("PUT", "/todos/"+strconv.Itoa(todo.ID), `{"title": "Water plants", "recurrence": "FREQ=DAILY"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,585 @@
+package tests
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/models"
+)
+
+// repositoryFactory creates an empty repository for one test, along with
+// functions that create a project of a workspace (or of none, given 0) todos
+// can be put in, and a workspace todos can belong to
+type repositoryFactory func(t *testing.T) (models.TodoRepository, func(workspaceID int) int, func() int)
+
+// TestDatabaseTodoRepository runs the conformance suite against TodoModel,
+// in SQLite or in the Postgres database at TEST_DATABASE_URL
+func TestDatabaseTodoRepository(t *testing.T) {
+	runTodoRepositoryConformance(t, func(t *testing.T) (models.TodoRepository, func(int) int, func() int) {
+		db := openTestDB(t, "test_repository.db")
+
+		projectModel := models.NewProjectModel(db, nil)
+		createProject := func(workspaceID int) int {
+			project, err := projectModel.ForWorkspace(workspaceID).Create(models.ProjectRequest{Name: "Project"})
+			assert.NoError(t, err)
+			return project.ID
+		}
+		userModel := models.NewUserModel(db)
+		workspaceModel := models.NewWorkspaceModel(db)
+		users := 0
+		createWorkspace := func() int {
+			users++
+			user, err := userModel.Register(models.RegisterRequest{
+				Email:    "user" + strconv.Itoa(users) + "@example.com",
+				Password: "correct horse",
+			})
+			assert.NoError(t, err)
+			workspace, err := workspaceModel.Create(user.ID, models.WorkspaceRequest{Name: "Team"})
+			assert.NoError(t, err)
+			return workspace.ID
+		}
+		return models.NewTodoModel(db), createProject, createWorkspace
+	})
+}
+
+// TestMemoryTodoRepository runs the conformance suite against MemoryTodoRepository
+func TestMemoryTodoRepository(t *testing.T) {
+	runTodoRepositoryConformance(t, func(t *testing.T) (models.TodoRepository, func(int) int, func() int) {
+		repo := models.NewMemoryTodoRepository()
+		// projects maps each project to its workspace
+		projects := map[int]int{}
+		repo.ProjectExists = func(workspaceID, id int) (bool, error) {
+			workspace, ok := projects[id]
+			return ok && (workspaceID == models.AllWorkspaces || workspace == workspaceID), nil
+		}
+		createProject := func(workspaceID int) int {
+			id := len(projects) + 1
+			projects[id] = workspaceID
+			return id
+		}
+		workspaces := 0
+		createWorkspace := func() int {
+			workspaces++
+			return workspaces
+		}
+		return repo, createProject, createWorkspace
+	})
+}
+
+// runTodoRepositoryConformance checks the behaviour every TodoRepository must share
+func runTodoRepositoryConformance(t *testing.T, newRepo repositoryFactory) {
+	intPtr := func(v int) *int { return &v }
+	boolPtr := func(v bool) *bool { return &v }
+
+	t.Run("Create and get", func(t *testing.T) {
+		repo, createProject, _ := newRepo(t)
+		projectID := createProject(models.AllWorkspaces)
+
+		todo, err := repo.Create(models.CreateTodoRequest{
+			Title:     "Write report",
+			Priority:  models.PriorityHigh,
+			ProjectID: &projectID,
+			Tags:      []string{" work ", "Urgent", "WORK", "  "},
+		})
+		assert.NoError(t, err)
+		assert.NotZero(t, todo.ID)
+		assert.Equal(t, 1, todo.Version)
+		assert.Equal(t, []string{"Urgent", "work"}, todo.Tags)
+
+		got, err := repo.GetByID(todo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Write report", got.Title)
+		assert.Equal(t, models.PriorityHigh, got.Priority)
+		assert.Equal(t, projectID, *got.ProjectID)
+		assert.Equal(t, []string{"Urgent", "work"}, got.Tags)
+
+		// Tags keep the case they were first used in
+		other, err := repo.Create(models.CreateTodoRequest{Title: "Other", Tags: []string{"urgent"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Urgent"}, other.Tags)
+
+		_, err = repo.GetByID(todo.ID + 100)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+
+		_, err = repo.Create(models.CreateTodoRequest{Title: "Lost", ProjectID: intPtr(projectID + 100)})
+		assert.ErrorIs(t, err, models.ErrUnknownProject)
+		_, err = repo.Create(models.CreateTodoRequest{Title: "Orphan", ParentID: intPtr(todo.ID + 100)})
+		assert.ErrorIs(t, err, models.ErrUnknownParent)
+		_, err = repo.Create(models.CreateTodoRequest{Title: "Never", Recurrence: "FREQ=DAILY"})
+		assert.ErrorIs(t, err, models.ErrInvalidRecurrence)
+
+		all, err := repo.GetAll()
+		assert.NoError(t, err)
+		if !assert.Len(t, all, 2) {
+			t.FailNow()
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		todo, err := repo.Create(models.CreateTodoRequest{Title: "Draft"})
+		assert.NoError(t, err)
+
+		updated, err := repo.Update(todo.ID, models.UpdateTodoRequest{Title: "Final", Tags: []string{"done"}}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "Final", updated.Title)
+		assert.Equal(t, 2, updated.Version)
+		assert.Equal(t, []string{"done"}, updated.Tags)
+
+		_, err = repo.Update(todo.ID, models.UpdateTodoRequest{Title: "Stale"}, 1)
+		assert.ErrorIs(t, err, models.ErrVersionMismatch)
+		_, err = repo.Update(todo.ID+100, models.UpdateTodoRequest{Title: "Missing"}, 0)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+
+		prioritized, err := repo.SetPriority(todo.ID, models.PriorityUrgent)
+		assert.NoError(t, err)
+		assert.Equal(t, models.PriorityUrgent, prioritized.Priority)
+		assert.Equal(t, 3, prioritized.Version)
+		_, err = repo.SetPriority(todo.ID+100, models.PriorityLow)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		repo, createProject, _ := newRepo(t)
+		projectID := createProject(models.AllWorkspaces)
+
+		for i, title := range []string{"Delta", "alpha", "Charlie", "Bravo", "Echo"} {
+			req := models.CreateTodoRequest{Title: title, Priority: models.Priority(i % 3)}
+			if i%2 == 0 {
+				req.ProjectID = &projectID
+				req.Tags = []string{"even"}
+			}
+			todo, err := repo.Create(req)
+			assert.NoError(t, err)
+			if title == "Charlie" {
+				_, err = repo.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+				assert.NoError(t, err)
+			}
+		}
+
+		sort, err := models.ParseSort("title")
+		assert.NoError(t, err)
+		var titles []string
+		params := models.ListParams{Limit: 2, Sort: sort, IncludeTotal: true}
+		for {
+			page, err := repo.List(params)
+			assert.NoError(t, err)
+			assert.Equal(t, 5, *page.Total)
+			for _, todo := range page.Todos {
+				titles = append(titles, todo.Title)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			params.Cursor = page.NextCursor
+		}
+		assert.Equal(t, []string{"Bravo", "Charlie", "Delta", "Echo", "alpha"}, titles)
+
+		sort, err = models.ParseSort("-priority,title")
+		assert.NoError(t, err)
+		page, err := repo.List(models.ListParams{Sort: sort})
+		assert.NoError(t, err)
+		if !assert.Len(t, page.Todos, 5) {
+			t.FailNow()
+		}
+		assert.Equal(t, "Charlie", page.Todos[0].Title)
+
+		filters := map[string]struct {
+			filter models.TodoFilter
+			want   int
+		}{
+			"completed":  {models.TodoFilter{Completed: boolPtr(true)}, 1},
+			"project":    {models.TodoFilter{ProjectID: &projectID}, 3},
+			"inbox":      {models.TodoFilter{Inbox: true}, 2},
+			"tag":        {models.TodoFilter{Tags: []string{"EVEN"}}, 3},
+			"all tags":   {models.TodoFilter{Tags: []string{"even", "odd"}, TagMode: models.TagModeAll}, 0},
+			"search":     {models.TodoFilter{Search: "HARL"}, 1},
+			"created":    {models.TodoFilter{CreatedAfter: time.Now().Add(-time.Hour)}, 5},
+			"due":        {models.TodoFilter{DueBefore: time.Now().Add(time.Hour)}, 0},
+			"trash":      {models.TodoFilter{Trashed: true}, 0},
+			"top level":  {models.TodoFilter{TopLevel: true}, 5},
+			"no matches": {models.TodoFilter{Search: "zulu"}, 0},
+		}
+		for name, tc := range filters {
+			count, err := repo.Count(tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, count, name)
+		}
+
+		_, err = repo.List(models.ListParams{Cursor: "not-a-cursor"})
+		assert.ErrorIs(t, err, models.ErrInvalidCursor)
+	})
+
+	t.Run("Subtasks", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		parent, err := repo.Create(models.CreateTodoRequest{Title: "Parent"})
+		assert.NoError(t, err)
+		child, err := repo.Create(models.CreateTodoRequest{Title: "Child", ParentID: &parent.ID})
+		assert.NoError(t, err)
+		grandchild, err := repo.Create(models.CreateTodoRequest{Title: "Grandchild", ParentID: &child.ID})
+		assert.NoError(t, err)
+
+		children, err := repo.GetChildren(parent.ID)
+		assert.NoError(t, err)
+		if !assert.Len(t, children, 1) {
+			t.FailNow()
+		}
+		assert.Equal(t, child.ID, children[0].ID)
+
+		tree, err := repo.GetTree(parent.ID)
+		assert.NoError(t, err)
+		if !assert.Len(t, tree, 1) {
+			t.FailNow()
+		}
+		if !assert.Len(t, tree[0].Children, 1) {
+			t.FailNow()
+		}
+		assert.Equal(t, grandchild.ID, tree[0].Children[0].ID)
+
+		// A todo cannot move below itself
+		_, err = repo.Update(parent.ID, models.UpdateTodoRequest{Title: "Parent", ParentID: &grandchild.ID}, 0)
+		assert.ErrorIs(t, err, models.ErrCycle)
+
+		_, err = repo.ToggleComplete(parent.ID, true, models.ChildPolicyRefuse)
+		assert.ErrorIs(t, err, models.ErrOpenChildren)
+
+		completed, err := repo.ToggleComplete(parent.ID, true, models.ChildPolicyCascade)
+		assert.NoError(t, err)
+		assert.True(t, completed.Completed)
+		got, err := repo.GetByID(grandchild.ID)
+		assert.NoError(t, err)
+		assert.True(t, got.Completed)
+		assert.Equal(t, 2, got.Version)
+	})
+
+	t.Run("Recurrence", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		due := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+		todo, err := repo.Create(models.CreateTodoRequest{
+			Title:       "Standup",
+			DueAt:       &due,
+			DueTimezone: "Europe/Berlin",
+			Recurrence:  "FREQ=DAILY;COUNT=2",
+			Tags:        []string{"meetings"},
+		})
+		assert.NoError(t, err)
+
+		occurrences, err := repo.PreviewOccurrences(todo.ID, 5)
+		assert.NoError(t, err)
+		if !assert.Len(t, occurrences, 2) {
+			t.FailNow()
+		}
+		assert.True(t, occurrences[1].Equal(due.AddDate(0, 0, 1)))
+
+		completed, err := repo.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+		assert.Empty(t, completed.Recurrence)
+		if !assert.NotNil(t, completed.NextOccurrence) {
+			t.FailNow()
+		}
+		next := completed.NextOccurrence
+		assert.True(t, next.DueAt.Equal(due.AddDate(0, 0, 1)))
+		assert.Equal(t, "Europe/Berlin", next.DueAt.Location().String())
+		assert.Equal(t, []string{"meetings"}, next.Tags)
+		assert.Equal(t, todo.ID, *next.SeriesID)
+
+		// The series ends after its second occurrence
+		last, err := repo.ToggleComplete(next.ID, true, models.ChildPolicyIgnore)
+		assert.NoError(t, err)
+		assert.Nil(t, last.NextOccurrence)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		parent, err := repo.Create(models.CreateTodoRequest{Title: "Parent"})
+		assert.NoError(t, err)
+		child, err := repo.Create(models.CreateTodoRequest{Title: "Child", ParentID: &parent.ID})
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, repo.Delete(parent.ID, 5), models.ErrVersionMismatch)
+		assert.NoError(t, repo.Delete(parent.ID, 1))
+		_, err = repo.GetByID(child.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		assert.ErrorIs(t, repo.Delete(parent.ID, 0), models.ErrNotFound)
+
+		count, err := repo.Count(models.TodoFilter{Trashed: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		_, err = repo.Restore(child.ID)
+		assert.ErrorIs(t, err, models.ErrParentInTrash)
+
+		restored, err := repo.Restore(parent.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, restored.DeletedAt)
+		assert.Equal(t, 3, restored.Version)
+		_, err = repo.GetByID(child.ID)
+		assert.NoError(t, err)
+		_, err = repo.Restore(parent.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+
+		assert.NoError(t, repo.Delete(parent.ID, 0))
+		purged, err := repo.Purge(time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Zero(t, purged)
+		// The child goes with its parent and is not counted
+		purged, err = repo.Purge(time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+		_, err = repo.Restore(child.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		todo, err := repo.Create(models.CreateTodoRequest{Title: "Existing"})
+		assert.NoError(t, err)
+		lastEventID, err := repo.LastEventID()
+		assert.NoError(t, err)
+
+		ops := []models.BulkOperation{
+			{Op: models.BulkCreate, Create: models.CreateTodoRequest{Title: "New", Tags: []string{"fresh"}}},
+			{Op: models.BulkComplete, ID: todo.ID},
+			{Op: models.BulkDelete, ID: todo.ID + 100},
+		}
+
+		results, committed, err := repo.Bulk(ops, true)
+		assert.NoError(t, err)
+		assert.False(t, committed)
+		assert.ErrorIs(t, results[2].Err, models.ErrNotFound)
+		assert.Nil(t, results[0].Todo)
+		all, err := repo.GetAll()
+		assert.NoError(t, err)
+		assert.Len(t, all, 1)
+		got, err := repo.GetByID(todo.ID)
+		assert.NoError(t, err)
+		assert.False(t, got.Completed)
+		events, err := repo.EventsSince(lastEventID, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+
+		results, committed, err = repo.Bulk(ops, false)
+		assert.NoError(t, err)
+		assert.True(t, committed)
+		if !assert.NotNil(t, results[0].Todo) {
+			t.FailNow()
+		}
+		assert.Equal(t, []string{"fresh"}, results[0].Todo.Tags)
+		assert.True(t, results[1].Todo.Completed)
+		assert.ErrorIs(t, results[2].Err, models.ErrNotFound)
+		events, err = repo.EventsSince(lastEventID, 10)
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 2) {
+			t.FailNow()
+		}
+		assert.Equal(t, models.EventCreated, events[0].Type)
+		assert.Equal(t, models.EventCompleted, events[1].Type)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		live, unsubscribe := repo.Subscribe(10)
+		defer unsubscribe()
+
+		todo, err := repo.Create(models.CreateTodoRequest{Title: "Watched"})
+		assert.NoError(t, err)
+		_, err = repo.Update(todo.ID, models.UpdateTodoRequest{Title: "Watched closely"}, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.Delete(todo.ID, 0))
+		_, err = repo.Restore(todo.ID)
+		assert.NoError(t, err)
+
+		want := []models.EventType{models.EventCreated, models.EventUpdated, models.EventDeleted, models.EventRestored}
+		for _, eventType := range want {
+			select {
+			case event := <-live:
+				assert.Equal(t, eventType, event.Type)
+				assert.Equal(t, todo.ID, event.TodoID)
+			case <-time.After(time.Second):
+				t.Fatalf("no %s event published", eventType)
+			}
+		}
+
+		events, err := repo.EventsSince(0, 10)
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 4) {
+			t.FailNow()
+		}
+		assert.Equal(t, "Watched closely", events[1].Todo.Title)
+		assert.NotNil(t, events[2].Todo.DeletedAt)
+
+		lastID, err := repo.LastEventID()
+		assert.NoError(t, err)
+		assert.Equal(t, events[3].ID, lastID)
+		events, err = repo.EventsSince(events[1].ID, 1)
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 1) {
+			t.FailNow()
+		}
+		assert.Equal(t, models.EventDeleted, events[0].Type)
+
+		purged, err := repo.PurgeEvents(time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), purged)
+		events, err = repo.EventsSince(0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		repo, _, _ := newRepo(t)
+		title, err := repo.Create(models.CreateTodoRequest{Title: "Quarterly report", Description: "Numbers for finance"})
+		assert.NoError(t, err)
+		description, err := repo.Create(models.CreateTodoRequest{Title: "Email", Description: "Send the report to Anna"})
+		assert.NoError(t, err)
+		_, err = repo.Create(models.CreateTodoRequest{Title: "Groceries"})
+		assert.NoError(t, err)
+
+		results, err := repo.Search("report", 10)
+		if errors.Is(err, models.ErrSearchUnavailable) {
+			t.Skip("full-text search needs: go test -tags sqlite_fts5 ./...")
+		}
+		assert.NoError(t, err)
+		if !assert.Len(t, results, 2) {
+			t.FailNow()
+		}
+		assert.Equal(t, title.ID, results[0].ID)
+		assert.Equal(t, description.ID, results[1].ID)
+		assert.Greater(t, results[0].Score, results[1].Score)
+		assert.Equal(t, "Quarterly <mark>report</mark>", results[0].TitleHighlight)
+		assert.Contains(t, results[1].Snippet, "<mark>report</mark>")
+
+		results, err = repo.Search(`"send the" quart*`, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+		results, err = repo.Search("quart*", 10)
+		assert.NoError(t, err)
+		if !assert.Len(t, results, 1) {
+			t.FailNow()
+		}
+		results, err = repo.Search(`"the report"`, 10)
+		assert.NoError(t, err)
+		if !assert.Len(t, results, 1) {
+			t.FailNow()
+		}
+		assert.Equal(t, description.ID, results[0].ID)
+
+		_, err = repo.Search(`""`, 10)
+		assert.ErrorIs(t, err, models.ErrInvalidFilter)
+
+		// Highlights are HTML, so the todo's own text is escaped
+		_, err = repo.Create(models.CreateTodoRequest{Title: `<img src=x onerror=alert(1)> invoice`, Description: `"Pay" the invoice & file it`})
+		assert.NoError(t, err)
+		results, err = repo.Search("invoice", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, `&lt;img src=x onerror=alert(1)&gt; <mark>invoice</mark>`, results[0].TitleHighlight)
+			assert.Equal(t, `&#34;Pay&#34; the <mark>invoice</mark> &amp; file it`, results[0].Snippet)
+		}
+	})
+
+	t.Run("Workspaces", func(t *testing.T) {
+		repo, createProject, createWorkspace := newRepo(t)
+		aliceID, bobID := createWorkspace(), createWorkspace()
+		alice, bob := repo.ForWorkspace(aliceID), repo.ForWorkspace(bobID)
+		live, unsubscribe := bob.Subscribe(10)
+		defer unsubscribe()
+
+		parent, err := alice.Create(models.CreateTodoRequest{Title: "Alice's"})
+		assert.NoError(t, err)
+		if !assert.NotNil(t, parent.WorkspaceID) {
+			t.FailNow()
+		}
+		assert.Equal(t, aliceID, *parent.WorkspaceID)
+		child, err := alice.Create(models.CreateTodoRequest{Title: "Alice's subtask", ParentID: &parent.ID})
+		assert.NoError(t, err)
+		unowned, err := repo.Create(models.CreateTodoRequest{Title: "Nobody's"})
+		assert.NoError(t, err)
+		assert.Nil(t, unowned.WorkspaceID)
+
+		// Projects of other workspaces cannot be used
+		projectID := createProject(aliceID)
+		_, err = bob.Create(models.CreateTodoRequest{Title: "Bob's", ProjectID: &projectID})
+		assert.ErrorIs(t, err, models.ErrUnknownProject)
+		_, err = alice.Update(child.ID, models.UpdateTodoRequest{Title: "In a project", ProjectID: &projectID, ParentID: &parent.ID}, 0)
+		assert.NoError(t, err)
+
+		// Other workspaces' todos are not found, whatever is done to them
+		_, err = bob.GetByID(parent.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = bob.GetByID(unowned.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = bob.Update(parent.ID, models.UpdateTodoRequest{Title: "Bob's now"}, 0)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = bob.SetPriority(parent.ID, models.PriorityUrgent)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = bob.ToggleComplete(parent.ID, true, models.ChildPolicyIgnore)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = bob.GetChildren(parent.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = bob.Create(models.CreateTodoRequest{Title: "Bob's subtask", ParentID: &parent.ID})
+		assert.ErrorIs(t, err, models.ErrUnknownParent)
+		results, _, err := bob.Bulk([]models.BulkOperation{{Op: models.BulkComplete, ID: parent.ID}}, false)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, results[0].Err, models.ErrNotFound)
+		assert.ErrorIs(t, bob.Delete(parent.ID, 0), models.ErrNotFound)
+
+		all, err := bob.GetAll()
+		assert.NoError(t, err)
+		assert.Empty(t, all)
+		page, err := alice.List(models.ListParams{IncludeTotal: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *page.Total)
+		count, err := repo.Count(models.TodoFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		assert.NoError(t, alice.Delete(parent.ID, 0))
+		_, err = bob.Restore(parent.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		purged, err := bob.Purge(time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Zero(t, purged)
+		count, err = alice.Count(models.TodoFilter{Trashed: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		_, err = alice.Restore(child.ID)
+		assert.ErrorIs(t, err, models.ErrParentInTrash)
+
+		// Events are only seen in the workspace of their todo
+		events, err := bob.EventsSince(0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+		lastID, err := bob.LastEventID()
+		assert.NoError(t, err)
+		assert.Zero(t, lastID)
+		events, err = alice.EventsSince(0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, events, 5)
+
+		todo, err := bob.Create(models.CreateTodoRequest{Title: "Bob's"})
+		assert.NoError(t, err)
+		select {
+		case event := <-live:
+			assert.Equal(t, todo.ID, event.TodoID)
+		case <-time.After(time.Second):
+			t.Fatal("no event published")
+		}
+
+		// A repository limited to no workspace sees and creates nothing
+		nobody := repo.ForWorkspace(0)
+		_, err = nobody.Create(models.CreateTodoRequest{Title: "Nowhere"})
+		assert.ErrorIs(t, err, models.ErrNoWorkspace)
+		_, err = nobody.GetByID(todo.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		all, err = nobody.GetAll()
+		assert.NoError(t, err)
+		assert.Empty(t, all)
+		events, err = nobody.EventsSince(0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestSearch tests full-text search over todos.
+// It needs FTS5, so run it with: go test -tags sqlite_fts5 ./...
+func TestSearch(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_search.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	if _, err := todoModel.Search("probe", 1); err == models.ErrSearchUnavailable {
+		t.Skip("SQLite built without FTS5")
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos/search", todoHandler.SearchTodos)
+
+	search := func(t *testing.T, q string) (int, []models.SearchResult) {
+		req, _ := http.NewRequest("GET", "/todos/search?q="+url.QueryEscape(q), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Results []models.SearchResult `json:"results"`
+		}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w.Code, response.Results
+	}
+
+	groceries, err := todoModel.Create(models.CreateTodoRequest{
+		Title:       "Buy groceries",
+		Description: "Milk, bread and eggs from the corner shop",
+	})
+	assert.NoError(t, err)
+	_, err = todoModel.Create(models.CreateTodoRequest{
+		Title:       "Plan the week",
+		Description: "Remember to buy groceries on Saturday",
+	})
+	assert.NoError(t, err)
+	_, err = todoModel.Create(models.CreateTodoRequest{
+		Title:       "Read a book",
+		Description: "Something about the groceries business, maybe",
+	})
+	assert.NoError(t, err)
+
+	t.Run("Ranked By Relevance", func(t *testing.T) {
+		code, results := search(t, "groceries")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, results, 3)
+		assert.Equal(t, groceries.ID, results[0].ID)
+		assert.Equal(t, "Buy <mark>groceries</mark>", results[0].TitleHighlight)
+		assert.GreaterOrEqual(t, results[0].Score, results[1].Score)
+	})
+
+	t.Run("Prefix Query", func(t *testing.T) {
+		code, results := search(t, "groc*")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, results, 3)
+	})
+
+	t.Run("Phrase Query", func(t *testing.T) {
+		code, results := search(t, `"buy groceries"`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, results, 2)
+		assert.Contains(t, results[1].Snippet, "<mark>buy groceries</mark>")
+	})
+
+	t.Run("Index Follows Updates And Deletes", func(t *testing.T) {
+		_, err := todoModel.Update(groceries.ID, models.UpdateTodoRequest{Title: "Buy vegetables"}, 0)
+		assert.NoError(t, err)
+
+		_, results := search(t, "vegetables")
+		assert.Len(t, results, 1)
+
+		assert.NoError(t, todoModel.Delete(groceries.ID, 0))
+
+		_, results = search(t, "vegetables")
+		assert.Empty(t, results)
+	})
+
+	t.Run("Highlights Are Escaped", func(t *testing.T) {
+		_, err := todoModel.Create(models.CreateTodoRequest{
+			Title:       `<script>alert("hi")</script> receipts`,
+			Description: "File the receipts & invoices",
+		})
+		assert.NoError(t, err)
+		// Text holding FTS5's marker characters is still highlighted correctly
+		_, err = todoModel.Create(models.CreateTodoRequest{Title: "Odd \x02<b>\x03 ledger"})
+		assert.NoError(t, err)
+
+		_, results := search(t, "receipts")
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, `&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; <mark>receipts</mark>`, results[0].TitleHighlight)
+			assert.Equal(t, "File the <mark>receipts</mark> &amp; invoices", results[0].Snippet)
+		}
+
+		_, results = search(t, "ledger")
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, "Odd \x02&lt;b&gt;\x03 <mark>ledger</mark>", results[0].TitleHighlight)
+		}
+	})
+
+	t.Run("Operators Are Treated As Text", func(t *testing.T) {
+		code, _ := search(t, `NEAR(a b) OR -"unbalanced`)
+		assert.Equal(t, http.StatusOK, code)
+
+		code, _ = search(t, `  "" * `)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestSocket tests the WebSocket endpoint for live updates and mutations
+func TestSocket(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_socket.db")
+
+	todoModel := models.NewTodoModel(db)
+	projectModel := models.NewProjectModel(db, todoModel.Events)
+	socketHandler := handlers.NewSocketHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID, projectModel.WorkspaceID = workspaceID, workspaceID
+	router.GET("/ws", socketHandler.Connect)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	connect := func(t *testing.T) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+		ws, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		return ws
+	}
+
+	read := func(t *testing.T, ws *websocket.Conn) handlers.SocketReply {
+		var reply handlers.SocketReply
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if !assert.NoError(t, ws.ReadJSON(&reply)) {
+			t.FailNow()
+		}
+		return reply
+	}
+
+	// send sends a message and returns its acknowledgement, along with any
+	// events that arrived before it
+	send := func(t *testing.T, ws *websocket.Conn, msg string) (handlers.SocketReply, []handlers.SocketReply) {
+		assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte(msg)))
+		var events []handlers.SocketReply
+		for {
+			reply := read(t, ws)
+			if reply.Type == handlers.SocketAck {
+				return reply, events
+			}
+			events = append(events, reply)
+		}
+	}
+
+	project, err := projectModel.Create(models.ProjectRequest{Name: "Home"})
+	assert.NoError(t, err)
+
+	t.Run("Mutations Are Acknowledged", func(t *testing.T) {
+		ws := connect(t)
+		defer ws.Close()
+
+		ack, _ := send(t, ws, `{"id": "c1", "type": "create", "todo": {"title": "From the socket"}}`)
+		assert.Equal(t, "c1", ack.ID)
+		assert.Equal(t, http.StatusCreated, ack.Status)
+		assert.Equal(t, "From the socket", ack.Todo.Title)
+
+		todo, err := todoModel.GetByID(ack.Todo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "From the socket", todo.Title)
+
+		ack, _ = send(t, ws, `{"id": "c2", "type": "complete", "todo_id": `+strconv.Itoa(todo.ID)+`}`)
+		assert.Equal(t, http.StatusOK, ack.Status)
+		assert.True(t, ack.Todo.Completed)
+
+		ack, _ = send(t, ws, `{"id": "c3", "type": "update", "todo_id": `+strconv.Itoa(todo.ID)+`, "version": 1, "todo": {"title": "Stale"}}`)
+		assert.Equal(t, http.StatusPreconditionFailed, ack.Status)
+
+		ack, _ = send(t, ws, `{"id": "c4", "type": "delete", "todo_id": `+strconv.Itoa(todo.ID)+`}`)
+		assert.Equal(t, http.StatusOK, ack.Status)
+		_, err = todoModel.GetByID(todo.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("Mutations Are Validated Like HTTP Requests", func(t *testing.T) {
+		ws := connect(t)
+		defer ws.Close()
+
+		ack, _ := send(t, ws, `{"id": "v1", "type": "create", "todo": {"description": "No title"}}`)
+		assert.Equal(t, http.StatusBadRequest, ack.Status)
+		assert.Equal(t, "todo.title", ack.Error.InvalidParams[0].Name)
+
+		ack, _ = send(t, ws, `{"id": "v2", "type": "create", "todo": {"title": "Orphan", "parent_id": 9999}}`)
+		assert.Equal(t, http.StatusBadRequest, ack.Status)
+		assert.Equal(t, "parent_id", ack.Error.InvalidParams[0].Name)
+
+		ack, _ = send(t, ws, `{"id": "v3", "type": "complete"}`)
+		assert.Equal(t, http.StatusBadRequest, ack.Status)
+		assert.Equal(t, "todo_id", ack.Error.InvalidParams[0].Name)
+
+		ack, _ = send(t, ws, `{"id": "v4", "type": "archive", "todo_id": 1}`)
+		assert.Equal(t, http.StatusBadRequest, ack.Status)
+		assert.Equal(t, "type", ack.Error.InvalidParams[0].Name)
+
+		ack, _ = send(t, ws, `{"id": "v5", "type": "delete", "todo_id": 9999}`)
+		assert.Equal(t, http.StatusNotFound, ack.Status)
+
+		// A malformed message does not end the connection
+		ack, _ = send(t, ws, `{not json`)
+		assert.Equal(t, http.StatusBadRequest, ack.Status)
+		ack, _ = send(t, ws, `{"id": "v6", "type": "subscribe", "all": true}`)
+		assert.Equal(t, http.StatusOK, ack.Status)
+	})
+
+	t.Run("Subscriptions Filter Events", func(t *testing.T) {
+		ws := connect(t)
+		defer ws.Close()
+
+		watched, _ := todoModel.Create(models.CreateTodoRequest{Title: "Watched"})
+		ack, _ := send(t, ws, `{"id": "s1", "type": "subscribe", "todo_ids": [`+strconv.Itoa(watched.ID)+`], "project_ids": [`+strconv.Itoa(project.ID)+`]}`)
+		assert.Equal(t, http.StatusOK, ack.Status)
+
+		// Changes to other todos are not sent
+		todoModel.Create(models.CreateTodoRequest{Title: "Elsewhere"})
+		inProject, _ := todoModel.Create(models.CreateTodoRequest{Title: "In the project", ProjectID: &project.ID})
+		todoModel.ToggleComplete(watched.ID, true, models.ChildPolicyIgnore)
+
+		event := read(t, ws)
+		assert.Equal(t, handlers.SocketEvent, event.Type)
+		assert.Equal(t, models.EventCreated, event.Event.Type)
+		assert.Equal(t, inProject.ID, event.Event.TodoID)
+
+		event = read(t, ws)
+		assert.Equal(t, models.EventCompleted, event.Event.Type)
+		assert.Equal(t, watched.ID, event.Event.TodoID)
+
+		// After unsubscribing only the project is left
+		ack, events := send(t, ws, `{"id": "s2", "type": "unsubscribe", "todo_ids": [`+strconv.Itoa(watched.ID)+`]}`)
+		assert.Equal(t, http.StatusOK, ack.Status)
+		assert.Empty(t, events)
+
+		todoModel.ToggleComplete(watched.ID, false, models.ChildPolicyIgnore)
+		todoModel.SetPriority(inProject.ID, models.PriorityHigh)
+		event = read(t, ws)
+		assert.Equal(t, models.EventUpdated, event.Event.Type)
+		assert.Equal(t, inProject.ID, event.Event.TodoID)
+	})
+
+	t.Run("Collaborators See Each Other's Changes", func(t *testing.T) {
+		alice := connect(t)
+		defer alice.Close()
+		bob := connect(t)
+		defer bob.Close()
+
+		ack, _ := send(t, bob, `{"type": "subscribe", "project_ids": [`+strconv.Itoa(project.ID)+`]}`)
+		assert.Equal(t, http.StatusOK, ack.Status)
+
+		ack, _ = send(t, alice, `{"id": "a1", "type": "create", "todo": {"title": "Shared", "project_id": `+strconv.Itoa(project.ID)+`}}`)
+		assert.Equal(t, http.StatusCreated, ack.Status)
+
+		event := read(t, bob)
+		assert.Equal(t, models.EventCreated, event.Event.Type)
+		assert.Equal(t, "Shared", event.Event.Todo.Title)
+	})
+
+	t.Run("Plain HTTP Is Rejected", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/ws")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
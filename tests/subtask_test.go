@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestSubtasks tests parent/child todos and completion policies
+func TestSubtasks(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_subtasks.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.PUT("/todos/:id", todoHandler.UpdateTodo)
+	router.GET("/todos/:id/children", todoHandler.GetTodoChildren)
+	router.PATCH("/todos/:id/complete", todoHandler.CompleteTodo)
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// tree builds root -> (a -> (a1), b) and returns the todos by name
+	tree := func(prefix string) map[string]*models.Todo {
+		todos := map[string]*models.Todo{}
+		create := func(name string, parent *models.Todo) {
+			req := models.CreateTodoRequest{Title: prefix + " " + name}
+			if parent != nil {
+				req.ParentID = &parent.ID
+			}
+			todo, err := todoModel.Create(req)
+			assert.NoError(t, err)
+			todos[name] = todo
+		}
+		create("root", nil)
+		create("a", todos["root"])
+		create("b", todos["root"])
+		create("a1", todos["a"])
+		return todos
+	}
+
+	t.Run("Children And Nested Tree", func(t *testing.T) {
+		todos := tree("view")
+		url := "/todos/" + strconv.Itoa(todos["root"].ID) + "/children"
+
+		w := send("GET", url, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var children []*models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &children))
+		assert.Len(t, children, 2)
+		assert.Empty(t, children[0].Children)
+
+		w = send("GET", url+"?nested=true", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &children))
+		assert.Equal(t, todos["a"].ID, children[0].ID)
+		assert.Len(t, children[0].Children, 1)
+		assert.Equal(t, todos["a1"].ID, children[0].Children[0].ID)
+		assert.Equal(t, todos["b"].ID, children[1].ID)
+
+		w = send("GET", "/todos/9999/children", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Reject Cycles And Unknown Parents", func(t *testing.T) {
+		todos := tree("cycle")
+		root := strconv.Itoa(todos["root"].ID)
+
+		w := send("PUT", "/todos/"+root, `{"title": "cycle root", "parent_id": `+root+`}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("PUT", "/todos/"+root, `{"title": "cycle root", "parent_id": `+strconv.Itoa(todos["a1"].ID)+`}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("POST", "/todos", `{"title": "orphan", "parent_id": 9999}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// Moving a subtree under an unrelated todo is fine
+		w = send("PUT", "/todos/"+strconv.Itoa(todos["a"].ID), `{"title": "cycle a", "parent_id": `+strconv.Itoa(todos["b"].ID)+`}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Refuse While Children Open", func(t *testing.T) {
+		todos := tree("refuse")
+		url := "/todos/" + strconv.Itoa(todos["root"].ID) + "/complete?children=refuse"
+
+		w := send("PATCH", url, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		for _, name := range []string{"a1", "a", "b"} {
+			_, err := todoModel.ToggleComplete(todos[name].ID, true, models.ChildPolicyRefuse)
+			assert.NoError(t, err)
+		}
+
+		w = send("PATCH", url, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Cascade To Children", func(t *testing.T) {
+		todos := tree("cascade")
+
+		w := send("PATCH", "/todos/"+strconv.Itoa(todos["root"].ID)+"/complete?children=cascade", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		for _, name := range []string{"root", "a", "b", "a1"} {
+			todo, err := todoModel.GetByID(todos[name].ID)
+			assert.NoError(t, err)
+			assert.True(t, todo.Completed, name)
+		}
+	})
+
+	t.Run("Ignore Children By Default", func(t *testing.T) {
+		todos := tree("ignore")
+
+		w := send("PATCH", "/todos/"+strconv.Itoa(todos["root"].ID)+"/complete", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		child, err := todoModel.GetByID(todos["a"].ID)
+		assert.NoError(t, err)
+		assert.False(t, child.Completed)
+
+		w = send("PATCH", "/todos/"+strconv.Itoa(todos["root"].ID)+"/complete?children=maybe", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Deleting A Parent Deletes Its Subtree", func(t *testing.T) {
+		todos := tree("delete")
+		assert.NoError(t, todoModel.Delete(todos["root"].ID, 0))
+
+		_, err := todoModel.GetByID(todos["a1"].ID)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestTags tests tagging todos and the tag management endpoints
+func TestTags(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_tags.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	tagModel := models.NewTagModel(db, todoModel.Events)
+	tagHandler := handlers.NewTagHandler(tagModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos", todoHandler.GetTodos)
+	router.POST("/todos", todoHandler.CreateTodo)
+	router.PUT("/todos/:id", todoHandler.UpdateTodo)
+	router.GET("/tags", tagHandler.GetTags)
+	router.POST("/tags", tagHandler.CreateTag)
+	router.PUT("/tags/:id", tagHandler.RenameTag)
+	router.DELETE("/tags/:id", tagHandler.DeleteTag)
+	router.POST("/tags/:id/merge", tagHandler.MergeTag)
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tagID := func(name string) int {
+		tags, err := tagModel.GetAll()
+		assert.NoError(t, err)
+		for _, tag := range tags {
+			if tag.Name == name {
+				return tag.ID
+			}
+		}
+		t.Fatalf("tag %q not found", name)
+		return 0
+	}
+
+	t.Run("Tags Created On Demand", func(t *testing.T) {
+		w := send("POST", "/todos", `{"title": "Write report", "tags": ["work", " Urgent ", "WORK"]}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var todo models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+		assert.Equal(t, []string{"Urgent", "work"}, todo.Tags)
+
+		stored, err := todoModel.GetByID(todo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Urgent", "work"}, stored.Tags)
+
+		// Existing tags are reused whatever their case
+		w = send("PUT", "/todos/"+strconv.Itoa(todo.ID), `{"title": "Write report", "tags": ["urgent", "home"]}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+		assert.Equal(t, []string{"home", "Urgent"}, todo.Tags)
+	})
+
+	t.Run("Untagged Todos Return Empty Tags", func(t *testing.T) {
+		w := send("POST", "/todos", `{"title": "Nothing special"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"tags":[]`)
+	})
+
+	t.Run("Filter By Tag", func(t *testing.T) {
+		_, err := todoModel.Create(models.CreateTodoRequest{Title: "Errand", Tags: []string{"home"}})
+		assert.NoError(t, err)
+
+		titles := func(url string) []string {
+			w := send("GET", url, "")
+			assert.Equal(t, http.StatusOK, w.Code)
+			var page models.TodoPage
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			var out []string
+			for _, todo := range page.Todos {
+				out = append(out, todo.Title)
+			}
+			return out
+		}
+
+		assert.ElementsMatch(t, []string{"Write report", "Errand"}, titles("/todos?tag=home,urgent"))
+		assert.ElementsMatch(t, []string{"Write report", "Errand"}, titles("/todos?tag=home&tag=urgent&tag_mode=any"))
+		assert.Equal(t, []string{"Write report"}, titles("/todos?tag=home&tag=urgent&tag_mode=all"))
+		assert.Empty(t, titles("/todos?tag=nope"))
+
+		w := send("GET", "/todos?tag=home&tag_mode=some", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Tag CRUD", func(t *testing.T) {
+		w := send("POST", "/tags", `{"name": "reading"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = send("POST", "/tags", `{"name": "Reading"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		id := strconv.Itoa(tagID("reading"))
+		w = send("PUT", "/tags/"+id, `{"name": "books"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"name":"books"`)
+
+		w = send("PUT", "/tags/"+id, `{"name": "home"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = send("DELETE", "/tags/"+id, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = send("DELETE", "/tags/"+id, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Merge Tags", func(t *testing.T) {
+		homeID, urgentID := tagID("home"), tagID("Urgent")
+
+		w := send("POST", "/tags/"+strconv.Itoa(homeID)+"/merge", `{"target_id": `+strconv.Itoa(urgentID)+`}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var merged models.Tag
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &merged))
+		assert.Equal(t, "Urgent", merged.Name)
+		assert.Equal(t, 2, merged.TodoCount)
+
+		todos, err := todoModel.GetAll()
+		assert.NoError(t, err)
+		for _, todo := range todos {
+			assert.NotContains(t, todo.Tags, "home")
+		}
+
+		w = send("POST", "/tags/"+strconv.Itoa(urgentID)+"/merge", `{"target_id": `+strconv.Itoa(urgentID)+`}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("POST", "/tags/"+strconv.Itoa(homeID)+"/merge", `{"target_id": `+strconv.Itoa(urgentID)+`}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Tag Changes Reach Todo Feeds", func(t *testing.T) {
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: "Plan trip", Tags: []string{"travel"}})
+		assert.NoError(t, err)
+		events, unsubscribe := todoModel.Subscribe(10)
+		defer unsubscribe()
+
+		expect := func(tag string) {
+			if assert.Len(t, events, 1) {
+				event := <-events
+				assert.Equal(t, models.EventUpdated, event.Type)
+				assert.Equal(t, todo.ID, event.TodoID)
+				if tag == "" {
+					assert.Empty(t, event.Todo.Tags)
+				} else {
+					assert.Equal(t, []string{tag}, event.Todo.Tags)
+				}
+			}
+		}
+
+		travelID := tagID("travel")
+		w := send("PUT", "/tags/"+strconv.Itoa(travelID), `{"name": "trips"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		expect("trips")
+
+		w = send("POST", "/tags", `{"name": "holidays"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		holidaysID := tagID("holidays")
+		w = send("POST", "/tags/"+strconv.Itoa(travelID)+"/merge", `{"target_id": `+strconv.Itoa(holidaysID)+`}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		expect("holidays")
+
+		w = send("DELETE", "/tags/"+strconv.Itoa(holidaysID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		expect("")
+	})
+
+	t.Run("Deleting A Todo Detaches Its Tags", func(t *testing.T) {
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: "Short lived", Tags: []string{"ephemeral"}})
+		assert.NoError(t, err)
+		assert.NoError(t, todoModel.Delete(todo.ID, 0))
+
+		tag, err := tagModel.GetByID(tagID("ephemeral"))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, tag.TodoCount)
+	})
+}
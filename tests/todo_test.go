@@ -2,17 +2,15 @@ package tests
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
-	"github.com/umair/go-todo-api/database"
 	"github.com/umair/go-todo-api/handlers"
 	"github.com/umair/go-todo-api/models"
 )
@@ -20,12 +18,7 @@ import (
 // TestTodoModel tests the TodoModel database operations
 func TestTodoModel(t *testing.T) {
 	// Use a test database
-	dbPath := "test_todo.db"
-	defer os.Remove(dbPath)
-
-	db, err := database.InitDB(dbPath)
-	assert.NoError(t, err)
-	defer database.CloseDB(db)
+	db := openTestDB(t, "test_todo.db")
 
 	todoModel := models.NewTodoModel(db)
 
@@ -67,7 +60,7 @@ func TestTodoModel(t *testing.T) {
 		todo, err := todoModel.GetByID(999)
 		assert.Error(t, err)
 		assert.Nil(t, todo)
-		assert.Equal(t, sql.ErrNoRows, err)
+		assert.ErrorIs(t, err, models.ErrNotFound)
 	})
 
 	t.Run("Get All Todos", func(t *testing.T) {
@@ -100,7 +93,7 @@ func TestTodoModel(t *testing.T) {
 			Description: "Updated Description",
 		}
 
-		updatedTodo, err := todoModel.Update(createdTodo.ID, updateReq)
+		updatedTodo, err := todoModel.Update(createdTodo.ID, updateReq, 0)
 		assert.NoError(t, err)
 		assert.NotNil(t, updatedTodo)
 		assert.Equal(t, updateReq.Title, updatedTodo.Title)
@@ -118,7 +111,7 @@ func TestTodoModel(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Delete the todo
-		err = todoModel.Delete(createdTodo.ID)
+		err = todoModel.Delete(createdTodo.ID, 0)
 		assert.NoError(t, err)
 
 		// Verify it's deleted
@@ -138,12 +131,12 @@ func TestTodoModel(t *testing.T) {
 		assert.False(t, createdTodo.Completed)
 
 		// Mark as complete
-		completedTodo, err := todoModel.ToggleComplete(createdTodo.ID, true)
+		completedTodo, err := todoModel.ToggleComplete(createdTodo.ID, true, models.ChildPolicyIgnore)
 		assert.NoError(t, err)
 		assert.True(t, completedTodo.Completed)
 
 		// Mark as incomplete
-		incompletedTodo, err := todoModel.ToggleComplete(createdTodo.ID, false)
+		incompletedTodo, err := todoModel.ToggleComplete(createdTodo.ID, false, models.ChildPolicyIgnore)
 		assert.NoError(t, err)
 		assert.False(t, incompletedTodo.Completed)
 	})
@@ -152,21 +145,20 @@ func TestTodoModel(t *testing.T) {
 // TestTodoHandlers tests the HTTP handlers
 func TestTodoHandlers(t *testing.T) {
 	// Use a test database
-	dbPath := "test_handlers.db"
-	defer os.Remove(dbPath)
-
-	db, err := database.InitDB(dbPath)
-	assert.NoError(t, err)
-	defer database.CloseDB(db)
+	db := openTestDB(t, "test_handlers.db")
 
 	todoModel := models.NewTodoModel(db)
 	todoHandler := handlers.NewTodoHandler(todoModel)
 
 	// Set Gin to test mode
+	signIn, workspaceID := signInTestUser(t, db)
+	todoModel.WorkspaceID = workspaceID
+
 	gin.SetMode(gin.TestMode)
 
 	t.Run("Create Todo Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.POST("/todos", todoHandler.CreateTodo)
 
 		reqBody := models.CreateTodoRequest{
@@ -193,6 +185,7 @@ func TestTodoHandlers(t *testing.T) {
 
 	t.Run("Get Todos Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.GET("/todos", todoHandler.GetTodos)
 
 		// Create a todo first
@@ -210,14 +203,59 @@ func TestTodoHandlers(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, w.Code)
 
-		var response []models.Todo
+		var response models.TodoPage
 		err = json.Unmarshal(w.Body.Bytes(), &response)
 		assert.NoError(t, err)
-		assert.GreaterOrEqual(t, len(response), 1)
+		assert.GreaterOrEqual(t, len(response.Todos), 1)
+		assert.NotNil(t, response.Total)
+	})
+
+	t.Run("Get Todos Handler Pagination", func(t *testing.T) {
+		router := gin.New()
+		router.Use(signIn...)
+		router.GET("/todos", todoHandler.GetTodos)
+
+		for i := 0; i < 5; i++ {
+			_, err := todoModel.Create(models.CreateTodoRequest{Title: "Page Todo " + strconv.Itoa(i)})
+			assert.NoError(t, err)
+		}
+
+		// Walk every page and make sure no todo is returned twice
+		seen := map[int]bool{}
+		url := "/todos?limit=2&count=false"
+		for url != "" {
+			req, _ := http.NewRequest("GET", url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var response models.TodoPage
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Nil(t, response.Total)
+			assert.LessOrEqual(t, len(response.Todos), 2)
+
+			for _, todo := range response.Todos {
+				assert.False(t, seen[todo.ID], "todo %d returned twice", todo.ID)
+				seen[todo.ID] = true
+			}
+
+			url = ""
+			if response.NextCursor != "" {
+				link := w.Header().Get("Link")
+				assert.Contains(t, link, `rel="next"`)
+				url = "/todos?limit=2&count=false&cursor=" + response.NextCursor
+			}
+		}
+
+		total, err := todoModel.Count(models.TodoFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, total, len(seen))
 	})
 
 	t.Run("Get Todo By ID Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.GET("/todos/:id", todoHandler.GetTodo)
 
 		// Create a todo first
@@ -245,6 +283,7 @@ func TestTodoHandlers(t *testing.T) {
 
 	t.Run("Update Todo Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.PUT("/todos/:id", todoHandler.UpdateTodo)
 
 		// Create a todo first
@@ -281,6 +320,7 @@ func TestTodoHandlers(t *testing.T) {
 
 	t.Run("Delete Todo Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.DELETE("/todos/:id", todoHandler.DeleteTodo)
 
 		// Create a todo first
@@ -307,6 +347,7 @@ func TestTodoHandlers(t *testing.T) {
 
 	t.Run("Complete Todo Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.PATCH("/todos/:id/complete", todoHandler.CompleteTodo)
 
 		// Create a todo first
@@ -335,6 +376,7 @@ func TestTodoHandlers(t *testing.T) {
 
 	t.Run("Uncomplete Todo Handler", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.PATCH("/todos/:id/uncomplete", todoHandler.UncompleteTodo)
 
 		// Create a completed todo first
@@ -346,7 +388,7 @@ func TestTodoHandlers(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Mark as complete first
-		_, err = todoModel.ToggleComplete(createdTodo.ID, true)
+		_, err = todoModel.ToggleComplete(createdTodo.ID, true, models.ChildPolicyIgnore)
 		assert.NoError(t, err)
 
 		// Use strconv.Itoa to convert ID to string for URL
@@ -365,23 +407,121 @@ func TestTodoHandlers(t *testing.T) {
 	})
 }
 
+// TestPriority tests priority levels on todos
+func TestPriority(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_priority.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+
+	signIn, workspaceID := signInTestUser(t, db)
+	todoModel.WorkspaceID = workspaceID
+
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Create With Priority", func(t *testing.T) {
+		todo, err := todoModel.Create(models.CreateTodoRequest{Title: "Urgent", Priority: models.PriorityUrgent})
+		assert.NoError(t, err)
+
+		stored, err := todoModel.GetByID(todo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.PriorityUrgent, stored.Priority)
+
+		body, _ := json.Marshal(stored)
+		assert.Contains(t, string(body), `"priority":"urgent"`)
+	})
+
+	t.Run("Set Priority Handler", func(t *testing.T) {
+		router := gin.New()
+		router.Use(signIn...)
+		router.PATCH("/todos/:id/priority", todoHandler.SetTodoPriority)
+
+		createdTodo, err := todoModel.Create(models.CreateTodoRequest{Title: "Priority Handler Test"})
+		assert.NoError(t, err)
+		assert.Equal(t, models.PriorityNone, createdTodo.Priority)
+
+		idStr := strconv.Itoa(createdTodo.ID)
+		req, _ := http.NewRequest("PATCH", "/todos/"+idStr+"/priority", bytes.NewBufferString(`{"priority": "high"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response models.Todo
+		err = json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, models.PriorityHigh, response.Priority)
+	})
+
+	t.Run("Reject Invalid Priority", func(t *testing.T) {
+		router := gin.New()
+		router.Use(signIn...)
+		router.POST("/todos", todoHandler.CreateTodo)
+		router.PATCH("/todos/:id/priority", todoHandler.SetTodoPriority)
+
+		createdTodo, err := todoModel.Create(models.CreateTodoRequest{Title: "Invalid Priority Test"})
+		assert.NoError(t, err)
+		idStr := strconv.Itoa(createdTodo.ID)
+
+		for _, tc := range []struct{ method, url, body string }{
+			{"POST", "/todos", `{"title": "x", "priority": "critical"}`},
+			{"POST", "/todos", `{"title": "x", "priority": 3}`},
+			{"PATCH", "/todos/" + idStr + "/priority", `{}`},
+			{"PATCH", "/todos/" + idStr + "/priority", `{"priority": "whenever"}`},
+		} {
+			req, _ := http.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, tc.body)
+		}
+	})
+
+	t.Run("Sort By Priority Then Due Date", func(t *testing.T) {
+		soon := time.Now().Add(time.Hour)
+		later := time.Now().Add(48 * time.Hour)
+
+		a, _ := todoModel.Create(models.CreateTodoRequest{Title: "high later", Priority: models.PriorityHigh, DueAt: &later})
+		b, _ := todoModel.Create(models.CreateTodoRequest{Title: "high soon", Priority: models.PriorityHigh, DueAt: &soon})
+		c, _ := todoModel.Create(models.CreateTodoRequest{Title: "high undated", Priority: models.PriorityHigh})
+		d, _ := todoModel.Create(models.CreateTodoRequest{Title: "low soon", Priority: models.PriorityLow, DueAt: &soon})
+
+		sort, err := models.ParseSort("-priority,due_at")
+		assert.NoError(t, err)
+
+		order := []int{}
+		page, err := todoModel.List(models.ListParams{Sort: sort, Limit: models.MaxPageLimit})
+		assert.NoError(t, err)
+		for _, todo := range page.Todos {
+			if todo.ID == a.ID || todo.ID == b.ID || todo.ID == c.ID || todo.ID == d.ID {
+				order = append(order, todo.ID)
+			}
+		}
+		assert.Equal(t, []int{b.ID, a.ID, c.ID, d.ID}, order)
+	})
+}
+
 // TestValidation tests input validation
 func TestValidation(t *testing.T) {
 	// Use a test database
-	dbPath := "test_validation.db"
-	defer os.Remove(dbPath)
-
-	db, err := database.InitDB(dbPath)
-	assert.NoError(t, err)
-	defer database.CloseDB(db)
+	db := openTestDB(t, "test_validation.db")
 
 	todoModel := models.NewTodoModel(db)
 	todoHandler := handlers.NewTodoHandler(todoModel)
 
+	signIn, workspaceID := signInTestUser(t, db)
+	todoModel.WorkspaceID = workspaceID
+
 	gin.SetMode(gin.TestMode)
 
 	t.Run("Create Todo with Empty Title", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.POST("/todos", todoHandler.CreateTodo)
 
 		reqBody := models.CreateTodoRequest{
@@ -401,6 +541,7 @@ func TestValidation(t *testing.T) {
 
 	t.Run("Invalid Todo ID", func(t *testing.T) {
 		router := gin.New()
+		router.Use(signIn...)
 		router.GET("/todos/:id", todoHandler.GetTodo)
 
 		req, _ := http.NewRequest("GET", "/todos/invalid", nil)
@@ -410,18 +551,26 @@ func TestValidation(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
+
+	t.Run("Invalid Pagination Parameters", func(t *testing.T) {
+		router := gin.New()
+		router.Use(signIn...)
+		router.GET("/todos", todoHandler.GetTodos)
+
+		for _, url := range []string{"/todos?limit=-1", "/todos?limit=1000", "/todos?cursor=not-a-cursor"} {
+			req, _ := http.NewRequest("GET", url, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, url)
+		}
+	})
 }
 
 // Benchmark tests for performance
 func BenchmarkCreateTodo(b *testing.B) {
-	dbPath := "benchmark.db"
-	defer os.Remove(dbPath)
-
-	db, err := database.InitDB(dbPath)
-	if err != nil {
-		b.Fatal(err)
-	}
-	defer database.CloseDB(db)
+	db := openTestDB(b, "benchmark.db")
 
 	todoModel := models.NewTodoModel(db)
 
@@ -439,14 +588,7 @@ func BenchmarkCreateTodo(b *testing.B) {
 }
 
 func BenchmarkGetAllTodos(b *testing.B) {
-	dbPath := "benchmark_get.db"
-	defer os.Remove(dbPath)
-
-	db, err := database.InitDB(dbPath)
-	if err != nil {
-		b.Fatal(err)
-	}
-	defer database.CloseDB(db)
+	db := openTestDB(b, "benchmark_get.db")
 
 	todoModel := models.NewTodoModel(db)
 
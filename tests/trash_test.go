@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestTrash tests soft deletion, the trash view, restoring and purging
+func TestTrash(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_trash.db")
+
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	trashHandler := handlers.NewTrashHandler(todoModel, time.Hour)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/todos", todoHandler.GetTodos)
+	router.GET("/todos/:id", todoHandler.GetTodo)
+	router.DELETE("/todos/:id", todoHandler.DeleteTodo)
+	router.GET("/todos/:id/children", todoHandler.GetTodoChildren)
+	router.POST("/todos/:id/restore", trashHandler.RestoreTodo)
+	router.GET("/trash", trashHandler.GetTrash)
+	router.DELETE("/trash", trashHandler.PurgeTrash)
+
+	send := func(method, url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(nil))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	listTrash := func() *models.TodoPage {
+		w := send("GET", "/trash")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page models.TodoPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return &page
+	}
+
+	parent, err := todoModel.Create(models.CreateTodoRequest{Title: "Parent"})
+	assert.NoError(t, err)
+	child, err := todoModel.Create(models.CreateTodoRequest{Title: "Child", ParentID: &parent.ID})
+	assert.NoError(t, err)
+	other, err := todoModel.Create(models.CreateTodoRequest{Title: "Other"})
+	assert.NoError(t, err)
+
+	t.Run("Delete Moves To Trash", func(t *testing.T) {
+		w := send("DELETE", "/todos/"+strconv.Itoa(parent.ID))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// The todo and its subtask are hidden everywhere but the trash
+		for _, id := range []int{parent.ID, child.ID} {
+			w = send("GET", "/todos/"+strconv.Itoa(id))
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+		todos, err := todoModel.GetAll()
+		assert.NoError(t, err)
+		assert.Len(t, todos, 1)
+
+		w = send("GET", "/todos")
+		var page models.TodoPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, 1, *page.Total)
+
+		trash := listTrash()
+		assert.Equal(t, 2, *trash.Total)
+		for _, todo := range trash.Todos {
+			assert.NotNil(t, todo.DeletedAt)
+		}
+
+		// Deleting a trashed todo again is a 404
+		w = send("DELETE", "/todos/"+strconv.Itoa(parent.ID))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		// A subtask cannot come back while its parent is in the trash
+		w := send("POST", "/todos/"+strconv.Itoa(child.ID)+"/restore")
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = send("POST", "/todos/"+strconv.Itoa(parent.ID)+"/restore")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var restored models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+		assert.Nil(t, restored.DeletedAt)
+
+		// Subtasks trashed along with the parent come back with it
+		w = send("GET", "/todos/"+strconv.Itoa(parent.ID)+"/children")
+		var children []*models.Todo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &children))
+		assert.Len(t, children, 1)
+		assert.Equal(t, 0, *listTrash().Total)
+
+		w = send("POST", "/todos/"+strconv.Itoa(other.ID)+"/restore")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Subtask Trashed On Its Own Stays In Trash", func(t *testing.T) {
+		assert.NoError(t, todoModel.Delete(child.ID, 0))
+		assert.NoError(t, todoModel.Delete(parent.ID, 0))
+
+		_, err := todoModel.Restore(parent.ID)
+		assert.NoError(t, err)
+		children, err := todoModel.GetChildren(parent.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, children)
+
+		_, err = todoModel.Restore(child.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		assert.NoError(t, todoModel.Delete(other.ID, 0))
+
+		// Nothing has been in the trash longer than the retention
+		w := send("DELETE", "/trash")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"purged": 0}`, w.Body.String())
+		assert.Equal(t, 1, *listTrash().Total)
+
+		w = send("DELETE", "/trash?all=true")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"purged": 1}`, w.Body.String())
+		assert.Equal(t, 0, *listTrash().Total)
+
+		w = send("POST", "/todos/"+strconv.Itoa(other.ID)+"/restore")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
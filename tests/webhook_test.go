@@ -0,0 +1,443 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// receivedWebhook is one delivery received by the test webhook server
+type receivedWebhook struct {
+	Header http.Header
+	Body   []byte
+}
+
+// TestWebhooks tests webhook management and the delivery queue
+func TestWebhooks(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_webhooks.db")
+
+	todoModel := models.NewTodoModel(db)
+	webhookModel := models.NewWebhookModel(db)
+	webhookHandler := handlers.NewWebhookHandler(webhookModel)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	signIn, workspaceID := signInTestUser(t, db)
+	router.Use(signIn...)
+	todoModel.WorkspaceID = workspaceID
+	router.GET("/webhooks", webhookHandler.GetWebhooks)
+	router.GET("/webhooks/:id", webhookHandler.GetWebhook)
+	router.POST("/webhooks", webhookHandler.CreateWebhook)
+	router.PUT("/webhooks/:id", webhookHandler.UpdateWebhook)
+	router.DELETE("/webhooks/:id", webhookHandler.DeleteWebhook)
+	router.GET("/webhooks/:id/deliveries", webhookHandler.GetDeliveries)
+	router.GET("/webhooks/:id/deliveries/:delivery_id", webhookHandler.GetDelivery)
+	router.POST("/webhooks/:id/deliveries/:delivery_id/replay", webhookHandler.ReplayDelivery)
+
+	send := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The receiver answers with the next status in statuses, or 204 once they run out
+	var mu sync.Mutex
+	var received []receivedWebhook
+	var statuses []int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, receivedWebhook{Header: r.Header, Body: body})
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	// The receiver listens on loopback, which the default client refuses
+	webhookModel.Client = receiver.Client()
+
+	// takeReceived returns the deliveries received so far and forgets them
+	takeReceived := func(fail ...int) []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := received
+		received = nil
+		statuses = fail
+		return taken
+	}
+
+	createWebhook := func(t *testing.T, body string) *models.Webhook {
+		w := send("POST", "/webhooks", body)
+		if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+			t.FailNow()
+		}
+		var webhook models.Webhook
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &webhook))
+		return &webhook
+	}
+
+	deliveries := func(t *testing.T, webhookID int, query string) []models.WebhookDelivery {
+		w := send("GET", "/webhooks/"+strconv.Itoa(webhookID)+"/deliveries"+query, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var list []models.WebhookDelivery
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		return list
+	}
+
+	t.Run("Webhook CRUD", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "https://ci.example.com/hook", "events": ["created", "completed"]}`)
+		assert.True(t, webhook.Active)
+		assert.Len(t, webhook.Secret, 64)
+		assert.Equal(t, []models.EventType{models.EventCreated, models.EventCompleted}, webhook.Events)
+
+		// The secret is only shown when the webhook is created
+		w := send("GET", "/webhooks/"+strconv.Itoa(webhook.ID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+
+		w = send("PUT", "/webhooks/"+strconv.Itoa(webhook.ID), `{"url": "https://ci.example.com/v2/hook", "events": ["deleted"], "active": false}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var updated models.Webhook
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		assert.Equal(t, "https://ci.example.com/v2/hook", updated.URL)
+		assert.False(t, updated.Active)
+
+		w = send("GET", "/webhooks", "")
+		var webhooks []models.Webhook
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &webhooks))
+		assert.Len(t, webhooks, 1)
+
+		w = send("DELETE", "/webhooks/"+strconv.Itoa(webhook.ID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = send("GET", "/webhooks/"+strconv.Itoa(webhook.ID), "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = send("PUT", "/webhooks/9999", `{"url": "https://example.com", "events": ["created"]}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Rejects Invalid Webhooks", func(t *testing.T) {
+		tests := []struct {
+			body  string
+			param string
+		}{
+			{`{"events": ["created"]}`, "url"},
+			{`{"url": "ftp://example.com/hook", "events": ["created"]}`, "url"},
+			{`{"url": "https://example.com/hook", "events": []}`, "events"},
+			{`{"url": "https://example.com/hook", "events": ["archived"]}`, "events[0]"},
+			{`{"url": "https://example.com/hook", "events": ["created"], "secret": "short"}`, "secret"},
+		}
+		for _, tt := range tests {
+			w := send("POST", "/webhooks", tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, tt.body)
+			var problem handlers.Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			if assert.NotEmpty(t, problem.InvalidParams, tt.body) {
+				assert.Equal(t, tt.param, problem.InvalidParams[0].Name, tt.body)
+			}
+		}
+	})
+
+	t.Run("Delivers Signed Events", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created", "completed"], "secret": "a-shared-secret-for-tests"}`)
+		defer webhookModel.Delete(webhook.ID)
+		assert.Equal(t, "a-shared-secret-for-tests", webhook.Secret)
+		takeReceived()
+
+		todo, _ := todoModel.Create(models.CreateTodoRequest{Title: "Ship it"})
+		// Events the webhook does not subscribe to are not delivered
+		todoModel.SetPriority(todo.ID, models.PriorityHigh)
+		todoModel.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+
+		attempted, err := webhookModel.DeliverDue(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempted)
+
+		got := takeReceived()
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "created", got[0].Header.Get(models.WebhookEventHeader))
+			assert.Equal(t, "completed", got[1].Header.Get(models.WebhookEventHeader))
+			timestamp, signature := got[0].Header.Get(models.WebhookTimestampHeader), got[0].Header.Get(models.WebhookSignatureHeader)
+			assert.NoError(t, models.VerifyWebhookSignature("a-shared-secret-for-tests", timestamp, signature, got[0].Body, time.Now()))
+
+			// The signature covers the timestamp, so an old delivery cannot be sent again as new
+			later := time.Now().Add(models.WebhookSignatureTolerance + time.Minute)
+			assert.ErrorIs(t, models.VerifyWebhookSignature("a-shared-secret-for-tests", timestamp, signature, got[0].Body, later), models.ErrInvalidWebhookSignature)
+			now := strconv.FormatInt(later.Unix(), 10)
+			assert.ErrorIs(t, models.VerifyWebhookSignature("a-shared-secret-for-tests", now, signature, got[0].Body, later), models.ErrInvalidWebhookSignature)
+			assert.ErrorIs(t, models.VerifyWebhookSignature("another-secret-entirely", timestamp, signature, got[0].Body, time.Now()), models.ErrInvalidWebhookSignature)
+
+			var event models.TodoEvent
+			assert.NoError(t, json.Unmarshal(got[0].Body, &event))
+			assert.Equal(t, todo.ID, event.TodoID)
+			assert.Equal(t, "Ship it", event.Todo.Title)
+		}
+
+		list := deliveries(t, webhook.ID, "")
+		if assert.Len(t, list, 2) {
+			// Newest first
+			assert.Equal(t, models.EventCompleted, list[0].EventType)
+			assert.Equal(t, models.DeliverySucceeded, list[0].Status)
+			assert.Equal(t, 1, list[0].Attempts)
+			assert.Equal(t, http.StatusNoContent, *list[0].ResponseStatus)
+			assert.NotNil(t, list[0].DeliveredAt)
+			assert.Equal(t, got[1].Header.Get(models.WebhookDeliveryHeader), strconv.Itoa(list[0].ID))
+		}
+
+		// Nothing is left to send
+		attempted, err = webhookModel.DeliverDue(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempted)
+	})
+
+	t.Run("Retries With Backoff", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		takeReceived(http.StatusInternalServerError, http.StatusBadGateway)
+
+		todoModel.Create(models.CreateTodoRequest{Title: "Flaky"})
+		now := time.Now()
+		webhookModel.DeliverDue(now)
+
+		list := deliveries(t, webhook.ID, "")
+		if !assert.Len(t, list, 1) {
+			t.FailNow()
+		}
+		delivery := list[0]
+		assert.Equal(t, models.DeliveryPending, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusInternalServerError, *delivery.ResponseStatus)
+		assert.Contains(t, delivery.LastError, "500")
+		assert.WithinDuration(t, now.Add(models.WebhookRetryDelay), *delivery.NextAttemptAt, time.Second)
+
+		// Not retried before it is due
+		attempted, _ := webhookModel.DeliverDue(now)
+		assert.Equal(t, 0, attempted)
+
+		// The second retry waits twice as long as the first
+		now = now.Add(models.WebhookRetryDelay + time.Second)
+		attempted, _ = webhookModel.DeliverDue(now)
+		assert.Equal(t, 1, attempted)
+		list = deliveries(t, webhook.ID, "")
+		assert.Equal(t, 2, list[0].Attempts)
+		assert.WithinDuration(t, now.Add(2*models.WebhookRetryDelay), *list[0].NextAttemptAt, time.Second)
+
+		attempted, _ = webhookModel.DeliverDue(now.Add(2*models.WebhookRetryDelay + time.Second))
+		assert.Equal(t, 1, attempted)
+		list = deliveries(t, webhook.ID, "?status=succeeded")
+		assert.Len(t, list, 1)
+		assert.Equal(t, 3, list[0].Attempts)
+		assert.Empty(t, list[0].LastError)
+		assert.Len(t, takeReceived(), 3)
+	})
+
+	t.Run("Gives Up After Max Attempts", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		fail := make([]int, models.WebhookMaxAttempts)
+		for i := range fail {
+			fail[i] = http.StatusServiceUnavailable
+		}
+		takeReceived(fail...)
+
+		todoModel.Create(models.CreateTodoRequest{Title: "Unreachable"})
+		now := time.Now()
+		for i := 0; i < models.WebhookMaxAttempts+2; i++ {
+			webhookModel.DeliverDue(now)
+			now = now.Add(24 * time.Hour)
+		}
+
+		list := deliveries(t, webhook.ID, "?status=failed")
+		if assert.Len(t, list, 1) {
+			assert.Equal(t, models.WebhookMaxAttempts, list[0].Attempts)
+			assert.Nil(t, list[0].NextAttemptAt)
+		}
+		assert.Len(t, takeReceived(), models.WebhookMaxAttempts)
+
+		// A failed delivery can be replayed once the webhook is fixed
+		w := send("POST", "/webhooks/"+strconv.Itoa(webhook.ID)+"/deliveries/"+strconv.Itoa(list[0].ID)+"/replay", "")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var replay models.WebhookDelivery
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &replay))
+		assert.NotEqual(t, list[0].ID, replay.ID)
+		assert.Equal(t, models.DeliveryPending, replay.Status)
+		assert.Equal(t, list[0].EventID, replay.EventID)
+
+		attempted, _ := webhookModel.DeliverDue(time.Now())
+		assert.Equal(t, 1, attempted)
+		w = send("GET", "/webhooks/"+strconv.Itoa(webhook.ID)+"/deliveries/"+strconv.Itoa(replay.ID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &replay))
+		assert.Equal(t, models.DeliverySucceeded, replay.Status)
+
+		// The original stays in the log as it was
+		list = deliveries(t, webhook.ID, "?status=failed")
+		assert.Len(t, list, 1)
+
+		w = send("POST", "/webhooks/"+strconv.Itoa(webhook.ID)+"/deliveries/9999/replay", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Only Committed Changes Are Queued", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created", "deleted"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		inactive := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"], "active": false}`)
+		defer webhookModel.Delete(inactive.ID)
+		takeReceived()
+
+		_, committed, err := todoModel.Bulk([]models.BulkOperation{
+			{Op: models.BulkCreate, Create: models.CreateTodoRequest{Title: "Rolled back"}},
+			{Op: models.BulkDelete, ID: 9999},
+		}, true)
+		assert.NoError(t, err)
+		assert.False(t, committed)
+		assert.Empty(t, deliveries(t, webhook.ID, ""))
+
+		todoModel.Create(models.CreateTodoRequest{Title: "Committed"})
+		assert.Len(t, deliveries(t, webhook.ID, ""), 1)
+		// Inactive webhooks are not sent events
+		assert.Empty(t, deliveries(t, inactive.ID, ""))
+
+		webhookModel.DeliverDue(time.Now())
+		assert.Len(t, takeReceived(), 1)
+	})
+
+	t.Run("Deleting A Webhook Deletes Its Deliveries", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		todoModel.Create(models.CreateTodoRequest{Title: "Never sent"})
+		assert.Len(t, deliveries(t, webhook.ID, "?status=pending"), 1)
+
+		assert.NoError(t, webhookModel.Delete(webhook.ID))
+		w := send("GET", "/webhooks/"+strconv.Itoa(webhook.ID)+"/deliveries", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		attempted, _ := webhookModel.DeliverDue(time.Now())
+		assert.Equal(t, 0, attempted)
+	})
+
+	t.Run("A Slow Endpoint Does Not Hold Up Others", func(t *testing.T) {
+		release := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer slow.Close()
+		defer close(release)
+
+		stuck := createWebhook(t, `{"url": "`+slow.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(stuck.ID)
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		takeReceived()
+
+		todoModel.Create(models.CreateTodoRequest{Title: "Both"})
+		done := make(chan int)
+		go func() {
+			attempted, _ := webhookModel.DeliverDue(time.Now())
+			done <- attempted
+		}()
+
+		// The fast endpoint is sent its delivery while the slow one is still answering
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		release <- struct{}{}
+		assert.Equal(t, 2, <-done)
+	})
+
+	t.Run("Waits After An Endpoint Fails", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		takeReceived(http.StatusServiceUnavailable)
+
+		todoModel.Create(models.CreateTodoRequest{Title: "First"})
+		todoModel.Create(models.CreateTodoRequest{Title: "Second"})
+		attempted, err := webhookModel.DeliverDue(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		// The second delivery was not tried, so it is still due
+		list := deliveries(t, webhook.ID, "")
+		if assert.Len(t, list, 2) {
+			assert.Equal(t, 0, list[0].Attempts)
+			assert.Equal(t, 1, list[1].Attempts)
+		}
+
+		// Nor is it sent before the first one is retried
+		now := time.Now()
+		attempted, _ = webhookModel.DeliverDue(now)
+		assert.Equal(t, 0, attempted)
+		attempted, _ = webhookModel.DeliverDue(now.Add(models.WebhookRetryDelay + time.Second))
+		assert.Equal(t, 2, attempted)
+		received := takeReceived()
+		if assert.Len(t, received, 3) {
+			assert.Equal(t, received[0].Header.Get(models.WebhookDeliveryHeader), received[1].Header.Get(models.WebhookDeliveryHeader))
+			assert.Contains(t, string(received[2].Body), `"Second"`)
+		}
+	})
+
+	t.Run("Keeps Order Past A Batch", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		takeReceived(http.StatusServiceUnavailable)
+
+		// More deliveries than DeliverDue fetches at a time
+		const count = 120
+		for i := 0; i < count; i++ {
+			todoModel.Create(models.CreateTodoRequest{Title: "Queued " + strconv.Itoa(i)})
+		}
+		attempted, err := webhookModel.DeliverDue(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		attempted, err = webhookModel.DeliverDue(time.Now().Add(models.WebhookRetryDelay + time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, count, attempted)
+
+		var ids []int
+		for _, r := range takeReceived()[1:] {
+			id, _ := strconv.Atoi(r.Header.Get(models.WebhookDeliveryHeader))
+			ids = append(ids, id)
+		}
+		assert.Len(t, ids, count)
+		assert.IsIncreasing(t, ids)
+	})
+
+	t.Run("Refuses Private Addresses", func(t *testing.T) {
+		webhook := createWebhook(t, `{"url": "`+receiver.URL+`", "events": ["created"]}`)
+		defer webhookModel.Delete(webhook.ID)
+		takeReceived()
+
+		todoModel.Create(models.CreateTodoRequest{Title: "Not for loopback"})
+		attempted, err := models.NewWebhookModel(db).DeliverDue(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+		assert.Empty(t, takeReceived())
+
+		list := deliveries(t, webhook.ID, "")
+		if assert.Len(t, list, 1) {
+			assert.Equal(t, models.DeliveryPending, list[0].Status)
+			assert.Nil(t, list[0].ResponseStatus)
+			assert.Contains(t, list[0].LastError, models.ErrWebhookAddressBlocked.Error())
+		}
+	})
+}
@@ -0,0 +1,503 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/umair/go-todo-api/database"
+	"github.com/umair/go-todo-api/handlers"
+	"github.com/umair/go-todo-api/models"
+)
+
+// TestWorkspaces tests sharing workspaces, what each role may do in them,
+// and that nothing in one workspace can be seen or changed from another
+func TestWorkspaces(t *testing.T) {
+	// Use a test database
+	db := openTestDB(t, "test_workspaces.db")
+
+	userModel := models.NewUserModel(db)
+	workspaceModel := models.NewWorkspaceModel(db)
+	workspaceHandler := handlers.NewWorkspaceHandler(workspaceModel)
+	todoModel := models.NewTodoModel(db)
+	todoHandler := handlers.NewTodoHandler(todoModel)
+	trashHandler := handlers.NewTrashHandler(todoModel, time.Hour)
+	tagHandler := handlers.NewTagHandler(models.NewTagModel(db, todoModel.Events))
+	projectHandler := handlers.NewProjectHandler(models.NewProjectModel(db, todoModel.Events), todoModel)
+	webhookHandler := handlers.NewWebhookHandler(models.NewWebhookModel(db))
+	issuer := newTestTokenIssuer(t, time.Minute)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(handlers.Authenticate(issuer, models.NewAPIKeyModel(db)))
+	private := router.Group("", handlers.RequireUser())
+	private.GET("/workspaces", workspaceHandler.GetWorkspaces)
+	private.POST("/workspaces", workspaceHandler.CreateWorkspace)
+	workspace := private.Group("/workspaces/:workspace_id", handlers.Workspace(workspaceModel))
+	workspace.GET("", workspaceHandler.GetWorkspace)
+	workspace.PUT("", workspaceHandler.RenameWorkspace)
+	workspace.DELETE("", workspaceHandler.DeleteWorkspace)
+	workspace.GET("/members", workspaceHandler.GetMembers)
+	workspace.POST("/members", workspaceHandler.AddMember)
+	workspace.PUT("/members/:user_id", workspaceHandler.UpdateMember)
+	workspace.DELETE("/members/:user_id", workspaceHandler.RemoveMember)
+	for _, group := range []*gin.RouterGroup{workspace, private.Group("", handlers.Workspace(workspaceModel))} {
+		group.GET("/todos", todoHandler.GetTodos)
+		group.POST("/todos", todoHandler.CreateTodo)
+		group.GET("/todos/:id", todoHandler.GetTodo)
+		group.PUT("/todos/:id", todoHandler.UpdateTodo)
+		group.DELETE("/todos/:id", todoHandler.DeleteTodo)
+		group.DELETE("/trash", trashHandler.PurgeTrash)
+		tags := group.Group("/tags", handlers.RequireRole(models.RoleViewer, models.RoleEditor))
+		tags.GET("", tagHandler.GetTags)
+		tags.POST("", tagHandler.CreateTag)
+		tags.POST("/:id/merge", tagHandler.MergeTag)
+		projects := group.Group("/projects", handlers.RequireRole(models.RoleViewer, models.RoleEditor))
+		projects.GET("/:id", projectHandler.GetProject)
+		projects.POST("", projectHandler.CreateProject)
+		projects.DELETE("/:id", projectHandler.DeleteProject)
+		webhooks := group.Group("/webhooks", handlers.RequireRole(models.RoleAdmin, models.RoleAdmin))
+		webhooks.GET("", webhookHandler.GetWebhooks)
+		webhooks.POST("", webhookHandler.CreateWebhook)
+	}
+
+	send := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, status int, v interface{}) {
+		if !assert.Equal(t, status, w.Code, w.Body.String()) {
+			t.FailNow()
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+
+	register := func(email string) (*models.User, string) {
+		user, err := userModel.Register(models.RegisterRequest{Email: email, Password: "correct horse"})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		token, _, err := issuer.Issue(user)
+		assert.NoError(t, err)
+		return user, token
+	}
+	alice, owner := register("alice@example.com")
+	_, admin := register("dave@example.com")
+	bob, editor := register("bob@example.com")
+	carol, viewer := register("carol@example.com")
+	_, outsider := register("mallory@example.com")
+
+	var team models.Workspace
+	var teamURL string
+	t.Run("Personal Workspace", func(t *testing.T) {
+		var workspaces []models.Workspace
+		decode(send("GET", "/workspaces", owner, ""), http.StatusOK, &workspaces)
+		if assert.Len(t, workspaces, 1) {
+			assert.True(t, workspaces[0].Personal)
+			assert.Equal(t, models.RoleOwner, workspaces[0].Role)
+		}
+
+		// Todos outside /workspaces go in the personal workspace
+		var todo models.Todo
+		decode(send("POST", "/todos", owner, `{"title": "Alice's own"}`), http.StatusCreated, &todo)
+		if assert.NotNil(t, todo.WorkspaceID) {
+			assert.Equal(t, workspaces[0].ID, *todo.WorkspaceID)
+		}
+
+		// Personal workspaces are not shared or deleted
+		url := "/workspaces/" + strconv.Itoa(workspaces[0].ID)
+		w := send("POST", url+"/members", owner, `{"email": "bob@example.com", "role": "viewer"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, http.StatusForbidden, send("DELETE", url, owner, "").Code)
+	})
+
+	t.Run("Share", func(t *testing.T) {
+		decode(send("POST", "/workspaces", owner, `{"name": "Team"}`), http.StatusCreated, &team)
+		assert.False(t, team.Personal)
+		assert.Equal(t, models.RoleOwner, team.Role)
+		teamURL = "/workspaces/" + strconv.Itoa(team.ID)
+
+		for email, role := range map[string]string{"dave@example.com": "admin", "bob@example.com": "editor", "carol@example.com": "viewer"} {
+			var member models.Member
+			decode(send("POST", teamURL+"/members", owner, `{"email": "`+email+`", "role": "`+role+`"}`), http.StatusCreated, &member)
+			assert.Equal(t, models.Role(role), member.Role)
+		}
+		w := send("POST", teamURL+"/members", owner, `{"email": "bob@example.com", "role": "viewer"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		w = send("POST", teamURL+"/members", owner, `{"email": "nobody@example.com", "role": "viewer"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = send("POST", teamURL+"/members", owner, `{"email": "nobody@example.com", "role": "superuser"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var members []models.Member
+		decode(send("GET", teamURL+"/members", viewer, ""), http.StatusOK, &members)
+		assert.Len(t, members, 4)
+
+		var workspaces []models.Workspace
+		decode(send("GET", "/workspaces", viewer, ""), http.StatusOK, &workspaces)
+		if assert.Len(t, workspaces, 2) {
+			assert.Equal(t, team.ID, workspaces[1].ID)
+			assert.Equal(t, models.RoleViewer, workspaces[1].Role)
+		}
+	})
+
+	var teamTodo models.Todo
+	t.Run("Roles", func(t *testing.T) {
+		// Viewers only read
+		assert.Equal(t, http.StatusForbidden, send("POST", teamURL+"/todos", viewer, `{"title": "Not allowed"}`).Code)
+		assert.Equal(t, http.StatusForbidden, send("POST", teamURL+"/tags", viewer, `{"name": "nope"}`).Code)
+		assert.Equal(t, http.StatusForbidden, send("POST", teamURL+"/projects", viewer, `{"name": "Nope"}`).Code)
+		assert.Equal(t, http.StatusOK, send("GET", teamURL+"/todos", viewer, "").Code)
+
+		// Editors change todos, tags and projects
+		decode(send("POST", teamURL+"/todos", editor, `{"title": "Launch", "tags": ["work"]}`), http.StatusCreated, &teamTodo)
+		assert.Equal(t, team.ID, *teamTodo.WorkspaceID)
+		url := teamURL + "/todos/" + strconv.Itoa(teamTodo.ID)
+		w := send("PUT", url, viewer, `{"title": "Viewer's now"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "role")
+		assert.Equal(t, http.StatusForbidden, send("DELETE", url, viewer, "").Code)
+		assert.Equal(t, http.StatusOK, send("PUT", url, editor, `{"title": "Launch", "tags": ["work"]}`).Code)
+		assert.Equal(t, http.StatusCreated, send("POST", teamURL+"/projects", editor, `{"name": "Roadmap"}`).Code)
+
+		// Only admins empty the trash and see webhooks
+		assert.Equal(t, http.StatusForbidden, send("DELETE", teamURL+"/trash", editor, "").Code)
+		assert.Equal(t, http.StatusOK, send("DELETE", teamURL+"/trash", admin, "").Code)
+		assert.Equal(t, http.StatusForbidden, send("GET", teamURL+"/webhooks", editor, "").Code)
+		w = send("POST", teamURL+"/webhooks", admin, `{"url": "http://example.com/hook", "events": ["created"]}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		// Admins manage members below owner, and only owners manage owners
+		assert.Equal(t, http.StatusForbidden, send("PUT", teamURL+"/members/"+strconv.Itoa(bob.ID), editor, `{"role": "admin"}`).Code)
+		assert.Equal(t, http.StatusForbidden, send("PUT", teamURL+"/members/"+strconv.Itoa(bob.ID), admin, `{"role": "owner"}`).Code)
+		assert.Equal(t, http.StatusForbidden, send("DELETE", teamURL+"/members/"+strconv.Itoa(alice.ID), admin, "").Code)
+		assert.Equal(t, http.StatusForbidden, send("PUT", teamURL, editor, `{"name": "Mine"}`).Code)
+		var renamed models.Workspace
+		decode(send("PUT", teamURL, admin, `{"name": "Launch Team"}`), http.StatusOK, &renamed)
+		assert.Equal(t, "Launch Team", renamed.Name)
+
+		// The last owner cannot leave or step down
+		w = send("PUT", teamURL+"/members/"+strconv.Itoa(alice.ID), owner, `{"role": "admin"}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, http.StatusConflict, send("DELETE", teamURL+"/members/"+strconv.Itoa(alice.ID), owner, "").Code)
+
+		// Roles change what members may do right away
+		assert.Equal(t, http.StatusOK, send("PUT", teamURL+"/members/"+strconv.Itoa(carol.ID), admin, `{"role": "editor"}`).Code)
+		assert.Equal(t, http.StatusCreated, send("POST", teamURL+"/todos", viewer, `{"title": "Promoted"}`).Code)
+	})
+
+	t.Run("No Data Leaks Between Tenants", func(t *testing.T) {
+		todoURL := "/todos/" + strconv.Itoa(teamTodo.ID)
+
+		// Outsiders cannot tell the workspace exists
+		for _, url := range []string{teamURL, teamURL + "/members", teamURL + "/todos", teamURL + todoURL, teamURL + "/tags"} {
+			assert.Equal(t, http.StatusNotFound, send("GET", url, outsider, "").Code, url)
+		}
+		assert.Equal(t, http.StatusNotFound, send("POST", teamURL+"/todos", outsider, `{"title": "Sneaky"}`).Code)
+
+		// Nor reach its todos through their own workspace
+		assert.Equal(t, http.StatusNotFound, send("GET", todoURL, outsider, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("PUT", todoURL, outsider, `{"title": "Mine now"}`).Code)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", todoURL, outsider, "").Code)
+		var page models.TodoPage
+		decode(send("GET", "/todos", outsider, ""), http.StatusOK, &page)
+		assert.Empty(t, page.Todos)
+
+		// Members' personal workspaces stay personal too
+		assert.Equal(t, http.StatusNotFound, send("GET", todoURL, editor, "").Code)
+		decode(send("GET", "/todos", owner, ""), http.StatusOK, &page)
+		if assert.Len(t, page.Todos, 1) {
+			assert.Equal(t, "Alice's own", page.Todos[0].Title)
+		}
+
+		// Tags of the same name are separate in each workspace
+		var tag models.Tag
+		decode(send("POST", "/tags", outsider, `{"name": "work"}`), http.StatusCreated, &tag)
+		var tags []models.Tag
+		decode(send("GET", teamURL+"/tags", viewer, ""), http.StatusOK, &tags)
+		if assert.Len(t, tags, 1) {
+			assert.Equal(t, 1, tags[0].TodoCount)
+			assert.NotEqual(t, tag.ID, tags[0].ID)
+			w := send("POST", "/tags/"+strconv.Itoa(tag.ID)+"/merge", outsider, `{"target_id": `+strconv.Itoa(tags[0].ID)+`}`)
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+
+		// Projects cannot be used, read or deleted from another workspace
+		var project models.Project
+		decode(send("POST", teamURL+"/projects", editor, `{"name": "Secret"}`), http.StatusCreated, &project)
+		projectURL := "/projects/" + strconv.Itoa(project.ID)
+		assert.Equal(t, http.StatusNotFound, send("GET", projectURL, outsider, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("DELETE", projectURL, outsider, "").Code)
+		w := send("POST", "/todos", outsider, `{"title": "Into theirs", "project_id": `+strconv.Itoa(project.ID)+`}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, http.StatusOK, send("GET", teamURL+projectURL, viewer, "").Code)
+
+		// Webhooks only hear about their own workspace
+		var hooks []models.Webhook
+		decode(send("GET", "/webhooks", outsider, ""), http.StatusOK, &hooks)
+		assert.Empty(t, hooks)
+		var hook models.Webhook
+		decode(send("POST", "/webhooks", outsider, `{"url": "http://example.com/spy", "events": ["created"]}`), http.StatusCreated, &hook)
+		assert.Equal(t, http.StatusCreated, send("POST", teamURL+"/todos", editor, `{"title": "Quiet"}`).Code)
+		var deliveries int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?`, hook.ID).Scan(&deliveries))
+		assert.Zero(t, deliveries)
+
+		// Models limited to no workspace see and create nothing
+		projects := models.NewProjectModel(db, nil).ForWorkspace(0)
+		_, err := projects.GetByID(project.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = projects.Create(models.ProjectRequest{Name: "Nowhere"})
+		assert.ErrorIs(t, err, models.ErrNoWorkspace)
+		noTags, err := models.NewTagModel(db, nil).ForWorkspace(0).GetAll()
+		assert.NoError(t, err)
+		assert.Empty(t, noTags)
+		webhooks := models.NewWebhookModel(db).ForWorkspace(0)
+		_, err = webhooks.GetByID(hook.ID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = webhooks.Create(models.WebhookRequest{URL: "http://example.com/nowhere", Events: []models.EventType{models.EventCreated}})
+		assert.ErrorIs(t, err, models.ErrNoWorkspace)
+
+		// So do handlers served outside Workspace
+		outside := gin.New()
+		outside.GET("/todos", todoHandler.GetTodos)
+		outside.POST("/tags", tagHandler.CreateTag)
+		w = httptest.NewRecorder()
+		outside.ServeHTTP(w, httptest.NewRequest("GET", "/todos", nil))
+		decode(w, http.StatusOK, &page)
+		assert.Empty(t, page.Todos)
+		w = httptest.NewRecorder()
+		outside.ServeHTTP(w, httptest.NewRequest("POST", "/tags", bytes.NewBufferString(`{"name": "loose"}`)))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// Removed members lose access
+		assert.Equal(t, http.StatusOK, send("DELETE", teamURL+"/members/"+strconv.Itoa(carol.ID), admin, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", teamURL+"/todos", viewer, "").Code)
+
+		// Members below admin may only remove themselves
+		assert.Equal(t, http.StatusForbidden, send("DELETE", teamURL+"/members/"+strconv.Itoa(alice.ID), editor, "").Code)
+		assert.Equal(t, http.StatusOK, send("DELETE", teamURL+"/members/"+strconv.Itoa(bob.ID), editor, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", teamURL+"/todos", editor, "").Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("DELETE", teamURL, admin, "").Code)
+		assert.Equal(t, http.StatusOK, send("DELETE", teamURL, owner, "").Code)
+		assert.Equal(t, http.StatusNotFound, send("GET", teamURL+"/todos", owner, "").Code)
+
+		for _, table := range []string{"todos", "projects", "tags", "webhooks", "workspace_members"} {
+			var count int
+			assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE workspace_id = ?`, team.ID).Scan(&count))
+			assert.Zero(t, count, table)
+		}
+	})
+}
+
+// TestRoleGuard tests the role checks between the handlers and a repository
+func TestRoleGuard(t *testing.T) {
+	repo := models.NewMemoryTodoRepository().ForWorkspace(1)
+	todo, err := repo.Create(models.CreateTodoRequest{Title: "Shared"})
+	assert.NoError(t, err)
+
+	viewer := models.NewRoleGuard(repo, models.RoleViewer)
+	_, err = viewer.GetByID(todo.ID)
+	assert.NoError(t, err)
+	_, err = viewer.Create(models.CreateTodoRequest{Title: "Not allowed"})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = viewer.ToggleComplete(todo.ID, true, models.ChildPolicyIgnore)
+	assert.ErrorIs(t, err, models.ErrInsufficientRole)
+	_, _, err = viewer.Bulk([]models.BulkOperation{{Op: models.BulkDelete, ID: todo.ID}}, true)
+	assert.ErrorIs(t, err, models.ErrInsufficientRole)
+
+	// Guards carry over to other workspaces
+	_, err = viewer.ForWorkspace(2).Create(models.CreateTodoRequest{Title: "Not here either"})
+	assert.ErrorIs(t, err, models.ErrInsufficientRole)
+
+	editor := models.NewRoleGuard(repo, models.RoleEditor)
+	_, err = editor.SetPriority(todo.ID, models.PriorityHigh)
+	assert.NoError(t, err)
+	assert.NoError(t, editor.Delete(todo.ID, 0))
+	_, err = editor.Purge(time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, models.ErrInsufficientRole)
+
+	admin := models.NewRoleGuard(repo, models.RoleAdmin)
+	purged, err := admin.Purge(time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	// Roles that are not known may do nothing
+	_, err = models.NewRoleGuard(repo, models.Role("")).Create(models.CreateTodoRequest{Title: "Nobody"})
+	assert.ErrorIs(t, err, models.ErrInsufficientRole)
+}
+
+// TestClaimUnowned tests moving the rows that belong to no workspace into one
+func TestClaimUnowned(t *testing.T) {
+	db := openTestDB(t, "test_claim_unowned.db")
+
+	userModel := models.NewUserModel(db)
+	workspaceModel := models.NewWorkspaceModel(db)
+	todoModel := models.NewTodoModel(db)
+	projectModel := models.NewProjectModel(db, nil)
+	tagModel := models.NewTagModel(db, nil)
+	webhookModel := models.NewWebhookModel(db)
+
+	personal := func(email string) int {
+		user, err := userModel.Register(models.RegisterRequest{Email: email, Password: "correct horse"})
+		assert.NoError(t, err)
+		workspace, err := workspaceModel.Personal(user.ID)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return workspace.ID
+	}
+	aliceID, bobID := personal("alice@example.com"), personal("bob@example.com")
+	alice := todoModel.ForWorkspace(aliceID)
+	_, err := alice.Create(models.CreateTodoRequest{Title: "Alice's", Tags: []string{"Work"}})
+	assert.NoError(t, err)
+
+	// Rows from before workspaces: a todo in a project of its own, a project
+	// one of Bob's todos is in, tags and a webhook
+	errands, err := projectModel.Create(models.ProjectRequest{Name: "Errands"})
+	assert.NoError(t, err)
+	shared, err := projectModel.Create(models.ProjectRequest{Name: "Shared"})
+	assert.NoError(t, err)
+	old, err := todoModel.Create(models.CreateTodoRequest{Title: "Old", ProjectID: &errands.ID, Tags: []string{"work", "home"}})
+	assert.NoError(t, err)
+	bobs, err := todoModel.ForWorkspace(bobID).Create(models.CreateTodoRequest{Title: "Bob's"})
+	assert.NoError(t, err)
+	_, err = db.Exec(`UPDATE todos SET project_id = ? WHERE id = ?`, shared.ID, bobs.ID)
+	assert.NoError(t, err)
+	_, err = webhookModel.Create(models.WebhookRequest{URL: "http://example.com/hook", Events: []models.EventType{models.EventCreated}})
+	assert.NoError(t, err)
+
+	_, err = alice.GetByID(old.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = workspaceModel.ClaimUnowned(9999)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	result, err := workspaceModel.ClaimUnowned(aliceID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, models.ClaimResult{Todos: 1, Projects: 1, Tags: 2, Webhooks: 1}, *result)
+
+	claimed, err := alice.GetByID(old.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home", "Work"}, claimed.Tags)
+	assert.Equal(t, errands.ID, *claimed.ProjectID)
+	events, err := alice.EventsSince(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	// The tag Alice already had takes over the old todo's tag of that name
+	tags, err := tagModel.ForWorkspace(aliceID).GetAll()
+	assert.NoError(t, err)
+	if assert.Len(t, tags, 2) {
+		assert.Equal(t, "Work", tags[1].Name)
+		assert.Equal(t, 2, tags[1].TodoCount)
+	}
+
+	_, err = projectModel.ForWorkspace(aliceID).GetByID(errands.ID)
+	assert.NoError(t, err)
+	_, err = projectModel.ForWorkspace(aliceID).GetByID(shared.ID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	hooks, err := webhookModel.ForWorkspace(aliceID).GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, hooks, 1)
+
+	// Claiming again finds nothing left but the project Bob uses
+	result, err = workspaceModel.ClaimUnowned(aliceID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ClaimResult{}, *result)
+}
+
+// TestWorkspaceMigration tests that moving to workspaces keeps every user's
+// todos and tags to themselves, and that it can be undone
+func TestWorkspaceMigration(t *testing.T) {
+	if os.Getenv("TEST_DATABASE_URL") != "" {
+		t.Skip("the migration's data is checked against SQLite")
+	}
+	db := openTestDB(t, "test_workspace_migration.db")
+
+	migrator, err := database.NewMigrator(db)
+	assert.NoError(t, err)
+	if !assert.NoError(t, migrator.To(3)) {
+		t.FailNow()
+	}
+
+	// Two users share a tag name before workspaces, and one project is used
+	// by both of them
+	_, err = db.Exec(`
+		INSERT INTO users (id, email, password_hash, created_at, updated_at) VALUES
+			(1, 'alice@example.com', 'x', '2024-01-01 00:00:00', '2024-01-01 00:00:00'),
+			(2, 'bob@example.com', 'x', '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+		INSERT INTO projects (id, name, created_at, updated_at) VALUES
+			(1, 'Alice''s', '2024-01-01 00:00:00', '2024-01-01 00:00:00'),
+			(2, 'Shared', '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+		INSERT INTO todos (id, title, description, owner_id, project_id, created_at, updated_at) VALUES
+			(1, 'Alice''s', '', 1, 1, '2024-01-01 00:00:00', '2024-01-01 00:00:00'),
+			(2, 'Bob''s', '', 2, 2, '2024-01-01 00:00:00', '2024-01-01 00:00:00'),
+			(3, 'Alice''s too', '', 1, 2, '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+		INSERT INTO tags (id, name, created_at, updated_at) VALUES (1, 'work', '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+		INSERT INTO todo_tags (todo_id, tag_id) VALUES (1, 1), (2, 1), (3, 1);
+	`)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NoError(t, migrator.Up())
+
+	workspaceModel := models.NewWorkspaceModel(db)
+	todoModel := models.NewTodoModel(db)
+	for _, userID := range []int{1, 2} {
+		personal, err := workspaceModel.Personal(userID)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, models.RoleOwner, personal.Role)
+
+		todos, err := todoModel.ForWorkspace(personal.ID).GetAll()
+		assert.NoError(t, err)
+		for _, todo := range todos {
+			assert.Equal(t, []string{"work"}, todo.Tags)
+		}
+		tags, err := models.NewTagModel(db, nil).ForWorkspace(personal.ID).GetAll()
+		assert.NoError(t, err)
+		if assert.Len(t, tags, 1) {
+			assert.Equal(t, len(todos), tags[0].TodoCount)
+		}
+	}
+
+	// Projects follow their todos, unless those are in several workspaces
+	project, err := models.NewProjectModel(db, nil).ForWorkspace(1).GetByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, project.TodoCount)
+	_, err = models.NewProjectModel(db, nil).ForWorkspace(2).GetByID(2)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// New workspaces do not reuse the personal workspaces' IDs
+	user, err := models.NewUserModel(db).Register(models.RegisterRequest{Email: "carol@example.com", Password: "correct horse"})
+	assert.NoError(t, err)
+	personal, err := workspaceModel.Personal(user.ID)
+	assert.NoError(t, err)
+	assert.Greater(t, personal.ID, 2)
+
+	assert.NoError(t, migrator.To(3))
+	var ownerID, tags int
+	assert.NoError(t, db.QueryRow(`SELECT owner_id FROM todos WHERE id = 2`).Scan(&ownerID))
+	assert.Equal(t, 2, ownerID)
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&tags))
+	assert.Equal(t, 1, tags)
+	assert.NoError(t, migrator.Up())
+}